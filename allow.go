@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+)
+
+// multiOperations are the endpoints applying several operations, which
+// can be disabled by -allow-operations as well.
+var multiOperations = map[string]bool{"pipeline": true, "batch": true, "jobs": true}
+
+// validateAllowedOperations checks the -allow-operations names.
+func validateAllowedOperations(names []string) error {
+	for _, name := range names {
+		if !isOperation(name) && !multiOperations[name] {
+			return fmt.Errorf("invalid allowed operation: %s", name)
+		}
+	}
+	return nil
+}
+
+// operationAllowed reports whether the operation is enabled. Every
+// operation is enabled unless -allow-operations is defined.
+func operationAllowed(o ServerOptions, name string) bool {
+	if len(o.AllowOperations) == 0 {
+		return true
+	}
+	for _, allowed := range o.AllowOperations {
+		if name == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func operationForbidden(name string) error {
+	return NewError(fmt.Sprintf("operation not allowed: %s", name), http.StatusForbidden)
+}
+
+// allowOperation replies 403 to the image operations not enabled.
+func allowOperation(o ServerOptions, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if name := ps.ByName("operation"); !operationAllowed(o, name) {
+			writeError(w, operationForbidden(name))
+			return
+		}
+		next(w, r, ps)
+	}
+}
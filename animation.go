@@ -0,0 +1,119 @@
+package main
+
+/*
+#cgo pkg-config: vips
+#include <stdlib.h>
+#include <vips/vips.h>
+
+// image_n_pages returns the number of frames of the image.
+static int
+image_n_pages(void *buf, size_t len) {
+	VipsImage *image = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (image == NULL) {
+		vips_error_clear();
+		return 1;
+	}
+	int pages = vips_image_get_n_pages(image);
+	g_object_unref(image);
+	return pages;
+}
+
+// resize_animated scales every frame of an animated image by the same
+// factor, keeping the frame delays and loop count, and saves it with the
+// given suffix format.
+static int
+resize_animated(void *buf, size_t len, int frames, double scale, const char *suffix, void **out, size_t *out_len) {
+	char options[32];
+	snprintf(options, sizeof(options), "n=%d", frames);
+
+	VipsImage *in = vips_image_new_from_buffer(buf, len, options, NULL);
+	if (in == NULL) {
+		return 1;
+	}
+
+	// Intermediate images are released with the input image
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(in), 2);
+	int page_height = vips_image_get_page_height(in);
+	int pages = in->Ysize / page_height;
+	int height = VIPS_MAX(1, (int) (page_height * scale + 0.5));
+
+	int err = vips_resize(in, &t[0], scale, "vscale", (double) height * pages / in->Ysize, NULL);
+	if (err == 0) {
+		err = vips_copy(t[0], &t[1], NULL);
+	}
+	if (err == 0) {
+		vips_image_set_int(t[1], "page-height", height);
+		err = vips_image_write_to_buffer(t[1], suffix, out, out_len, NULL);
+	}
+
+	g_object_unref(in);
+	return err;
+}
+*/
+import "C"
+
+import (
+	"gopkg.in/h2non/bimg.v1"
+	"math"
+	"unsafe"
+)
+
+// isAnimated reports whether the image is a multi frame GIF or WebP.
+func isAnimated(image []byte) bool {
+	kind := bimg.DetermineImageType(image)
+	if len(image) == 0 || (kind != bimg.GIF && kind != bimg.WEBP) {
+		return false
+	}
+	return C.image_n_pages(unsafe.Pointer(&image[0]), C.size_t(len(image))) > 1
+}
+
+// animatedType returns the output type of an animated source image,
+// or UNKNOWN if the requested type is static, so only the first frame
+// is processed.
+func animatedType(image []byte, opts Options) bimg.ImageType {
+	kind := opts.Type
+	if kind == bimg.UNKNOWN {
+		kind = bimg.DetermineImageType(image)
+	}
+	if (kind == bimg.GIF || kind == bimg.WEBP) && bimg.IsTypeSupportedSave(kind) {
+		return kind
+	}
+	return bimg.UNKNOWN
+}
+
+// resizeAnimated scales every frame to fit the requested size, up to the
+// max number of frames. Frames are not cropped, so the crop operation
+// behaves as resize for animated images.
+func resizeAnimated(image []byte, kind bimg.ImageType, opts Options) ([]byte, error) {
+	size, err := bimg.Size(image)
+	if err != nil {
+		return nil, err
+	}
+
+	frames := C.image_n_pages(unsafe.Pointer(&image[0]), C.size_t(len(image)))
+	if opts.MaxFrames > 0 && int(frames) > opts.MaxFrames {
+		frames = C.int(opts.MaxFrames)
+	}
+
+	scale := 1.0
+	if opts.Width > 0 {
+		scale = float64(opts.Width) / float64(size.Width)
+	}
+	if opts.Height > 0 {
+		scale = math.Min(scale, float64(opts.Height)/float64(size.Height))
+		if opts.Width == 0 {
+			scale = float64(opts.Height) / float64(size.Height)
+		}
+	}
+
+	suffix := C.CString("." + bimg.ImageTypeName(kind))
+	defer C.free(unsafe.Pointer(suffix))
+
+	var out unsafe.Pointer
+	var length C.size_t
+	if C.resize_animated(unsafe.Pointer(&image[0]), C.size_t(len(image)), frames, C.double(scale), suffix, &out, &length) != 0 {
+		return nil, vipsErrorf("cannot resize animated image")
+	}
+	defer C.g_free(C.gpointer(out))
+	return C.GoBytes(out, C.int(length)), nil
+}
@@ -0,0 +1,143 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+import (
+	"bytes"
+	"gopkg.in/h2non/bimg.v1"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"testing"
+)
+
+// animatedFixture returns an animated GIF looping forever, with a frame
+// of the size for every delay.
+func animatedFixture(t *testing.T, width, height int, delays []int) []byte {
+	colors := []color.Color{color.RGBA{255, 0, 0, 255}, color.RGBA{0, 255, 0, 255}, color.RGBA{0, 0, 255, 255}}
+	anim := &gif.GIF{LoopCount: 0}
+	for i, delay := range delays {
+		frame := image.NewPaletted(image.Rect(0, 0, width, height), palette.Plan9)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				frame.Set(x, y, colors[i%len(colors)])
+			}
+		}
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, delay)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestResizeAnimatedGIF(t *testing.T) {
+	delays := []int{10, 20, 30}
+	fixture := animatedFixture(t, 40, 20, delays)
+	if !isAnimated(fixture) {
+		t.Fatal("expected the fixture to be animated")
+	}
+
+	cases := []struct {
+		operation string
+		maxFrames int
+		frames    int
+	}{
+		{"resize", 100, 3},
+		{"crop", 100, 3},
+		{"resize", 0, 3},
+		{"resize", 2, 2},
+	}
+
+	for _, c := range cases {
+		opts := NewOptions(testServerOptions(), c.operation)
+		opts.Width, opts.Height, opts.MaxFrames = 20, 10, c.maxFrames
+		buf, err := Resize(fixture, opts)
+		if err != nil {
+			t.Fatalf("%s (max frames %d): unexpected error: %s", c.operation, c.maxFrames, err)
+		}
+		if kind := bimg.DetermineImageType(buf); kind != bimg.GIF {
+			t.Fatalf("%s (max frames %d): expected a gif, got %s", c.operation, c.maxFrames, bimg.ImageTypeName(kind))
+		}
+
+		anim, err := gif.DecodeAll(bytes.NewReader(buf))
+		if err != nil {
+			t.Fatalf("%s (max frames %d): cannot decode the output: %s", c.operation, c.maxFrames, err)
+		}
+		if anim.Config.Width != 20 || anim.Config.Height != 10 {
+			t.Errorf("%s (max frames %d): expected 20x10, got %dx%d", c.operation, c.maxFrames, anim.Config.Width, anim.Config.Height)
+		}
+		if len(anim.Image) != c.frames {
+			t.Errorf("%s (max frames %d): expected %d frames, got %d", c.operation, c.maxFrames, c.frames, len(anim.Image))
+			continue
+		}
+		for i, delay := range anim.Delay {
+			if delay != delays[i] {
+				t.Errorf("%s (max frames %d): expected the frame %d delay %d, got %d", c.operation, c.maxFrames, i, delays[i], delay)
+			}
+		}
+		if anim.LoopCount != 0 {
+			t.Errorf("%s (max frames %d): expected the image to loop forever, got the loop count %d", c.operation, c.maxFrames, anim.LoopCount)
+		}
+	}
+}
+
+func TestResizeAnimatedWebP(t *testing.T) {
+	if !bimg.IsTypeSupportedSave(bimg.WEBP) {
+		t.Skip("libvips has no webp support")
+	}
+
+	fixture := animatedFixture(t, 40, 20, []int{10, 20, 30})
+	opts := NewOptions(testServerOptions(), "resize")
+	opts.Width, opts.Type = 20, bimg.WEBP
+	buf, err := Resize(fixture, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if kind := bimg.DetermineImageType(buf); kind != bimg.WEBP {
+		t.Fatalf("expected a webp, got %s", bimg.ImageTypeName(kind))
+	}
+	if !isAnimated(buf) {
+		t.Error("expected the webp to be animated")
+	}
+	if size, err := bimg.Size(buf); err != nil || size.Width != 20 || size.Height != 10 {
+		t.Errorf("expected the frames to be 20x10, got %+v (%v)", size, err)
+	}
+
+	// An animated webp is resized to an animated gif
+	opts = NewOptions(testServerOptions(), "resize")
+	opts.Width, opts.Type = 10, bimg.GIF
+	if buf, err = Resize(buf, opts); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	anim, err := gif.DecodeAll(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("cannot decode the output: %s", err)
+	}
+	if len(anim.Image) != 3 || anim.Config.Width != 10 || anim.Config.Height != 5 {
+		t.Errorf("expected 3 frames of 10x5, got %d frames of %dx%d", len(anim.Image), anim.Config.Width, anim.Config.Height)
+	}
+}
+
+func TestResizeAnimatedStatic(t *testing.T) {
+	fixture := animatedFixture(t, 40, 20, []int{10, 20, 30})
+	for _, kind := range []bimg.ImageType{bimg.JPEG, bimg.PNG} {
+		opts := NewOptions(testServerOptions(), "resize")
+		opts.Width, opts.Height, opts.Type = 20, 10, kind
+		buf, err := Resize(fixture, opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", bimg.ImageTypeName(kind), err)
+		}
+		if bimg.DetermineImageType(buf) != kind || isAnimated(buf) {
+			t.Errorf("%s: expected a static %s", bimg.ImageTypeName(kind), bimg.ImageTypeName(kind))
+		}
+		if size := decodeImage(t, buf).Bounds().Size(); size.X != 20 || size.Y != 10 {
+			t.Errorf("%s: expected the first frame at 20x10, got %dx%d", bimg.ImageTypeName(kind), size.X, size.Y)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/time/rate"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const apiKeyHeader = "API-Key"
+
+// KeyConfig defines the rate limit of an API key, in requests per second.
+// A zero rate means unlimited.
+type KeyConfig struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// parseAPIKeys merges the single -key flag with the -keys list, which is
+// either a file path with one entry per line or a comma separated list.
+// Each entry is formatted as key[:rate[:burst]]. The burst defaults to
+// the rate rounded up.
+func parseAPIKeys(key, keys string) (map[string]KeyConfig, error) {
+	entries := parseList(keys)
+	if len(entries) == 1 {
+		if _, err := os.Stat(entries[0]); err == nil {
+			buf, err := ioutil.ReadFile(entries[0])
+			if err != nil {
+				return nil, fmt.Errorf("cannot read keys file: %s", err)
+			}
+			entries = nil
+			for _, line := range strings.Split(string(buf), "\n") {
+				if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+					entries = append(entries, line)
+				}
+			}
+		}
+	}
+	configs := map[string]KeyConfig{}
+	for _, entry := range entries {
+		parts := strings.Split(entry, ":")
+		if parts[0] == "" || len(parts) > 3 {
+			return nil, fmt.Errorf("invalid API key entry: %s", entry)
+		}
+
+		var config KeyConfig
+		if len(parts) > 1 {
+			r, err := strconv.ParseFloat(parts[1], 64)
+			if err != nil || r < 0 {
+				return nil, fmt.Errorf("invalid API key rate: %s", parts[1])
+			}
+			config.Rate = r
+		}
+		if len(parts) > 2 {
+			b, err := strconv.Atoi(parts[2])
+			if err != nil || b < 1 {
+				return nil, fmt.Errorf("invalid API key burst: %s", parts[2])
+			}
+			config.Burst = b
+		}
+		if config.Burst == 0 {
+			config.Burst = int(math.Max(1, math.Ceil(config.Rate)))
+		}
+		configs[parts[0]] = config
+	}
+
+	// The single -key is kept as is and never throttled
+	if key != "" {
+		configs[key] = KeyConfig{}
+	}
+	return configs, nil
+}
+
+// resolveAPIKey returns the single API key, read from the key file, the
+// API_KEY environment variable or the -key flag, in order of precedence.
+// The file and the variable keep the key out of the process list.
+func resolveAPIKey(key, keyFile string) (string, error) {
+	if keyFile != "" {
+		buf, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return "", fmt.Errorf("cannot read key file: %s", err)
+		}
+		if key = strings.TrimSpace(string(buf)); key == "" {
+			return "", fmt.Errorf("empty key file: %s", keyFile)
+		}
+		return key, nil
+	}
+	if env := os.Getenv("API_KEY"); env != "" {
+		return env, nil
+	}
+	return key, nil
+}
+
+// apiKey is a configured key with its own token bucket.
+type apiKey struct {
+	key     []byte
+	limiter *rate.Limiter
+}
+
+var keyLocations = map[string]bool{"header": true, "query": true, "both": true}
+
+// requestKey returns the API key given in the Authorization bearer token,
+// the X-API-Key or API-Key headers, or the "key" query param, as allowed
+// by the key location.
+func requestKey(r *http.Request, location string) string {
+	if location != "query" {
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+		}
+		for _, name := range []string{"X-API-Key", apiKeyHeader} {
+			if key := r.Header.Get(name); key != "" {
+				return key
+			}
+		}
+	}
+	if location != "header" {
+		return r.URL.Query().Get("key")
+	}
+	return ""
+}
+
+// validateAdminKey replies 403 to the requests without the admin key, in the
+// location defined by -key-location. API keys do not grant admin access.
+func validateAdminKey(key, location string, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if subtle.ConstantTimeCompare([]byte(requestKey(r, location)), []byte(key)) != 1 {
+			writeError(w, NewError("missing or invalid admin key", http.StatusForbidden))
+			return
+		}
+		next(w, r, ps)
+	}
+}
+
+// validateKey rejects requests without a valid API key, in the location
+// defined by -key-location, and throttles every key to its own rate limit.
+func validateKey(keys map[string]KeyConfig, location string, next httprouter.Handle) httprouter.Handle {
+	var known []apiKey
+	for key, config := range keys {
+		k := apiKey{key: []byte(key)}
+		if config.Rate > 0 {
+			k.limiter = rate.NewLimiter(rate.Limit(config.Rate), config.Burst)
+		}
+		known = append(known, k)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		given := requestKey(r, location)
+
+		var match *apiKey
+		for i := range known {
+			if subtle.ConstantTimeCompare([]byte(given), known[i].key) == 1 {
+				match = &known[i]
+			}
+		}
+		if match == nil {
+			writeError(w, NewError("missing or invalid API key", http.StatusUnauthorized))
+			return
+		}
+
+		if match.limiter != nil {
+			reservation := match.limiter.Reserve()
+			if delay := reservation.Delay(); delay > 0 {
+				reservation.Cancel()
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+				writeError(w, NewError("API key rate limit exceeded", http.StatusTooManyRequests))
+				return
+			}
+		}
+
+		next(w, r, ps)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveAPIKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resizr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "key")
+	emptyFile := filepath.Join(dir, "empty")
+	ioutil.WriteFile(keyFile, []byte("file-key\n"), 0600)
+	ioutil.WriteFile(emptyFile, []byte("\n"), 0600)
+
+	cases := []struct {
+		name     string
+		key      string
+		keyFile  string
+		env      string
+		expected string
+		fails    bool
+	}{
+		{"flag", "flag-key", "", "", "flag-key", false},
+		{"env over flag", "flag-key", "", "env-key", "env-key", false},
+		{"file over env and flag", "flag-key", keyFile, "env-key", "file-key", false},
+		{"empty file", "flag-key", emptyFile, "", "", true},
+		{"missing file", "flag-key", filepath.Join(dir, "missing"), "", "", true},
+		{"no key", "", "", "", "", false},
+	}
+
+	defer os.Unsetenv("API_KEY")
+	for _, c := range cases {
+		os.Setenv("API_KEY", c.env)
+		key, err := resolveAPIKey(c.key, c.keyFile)
+		if c.fails {
+			if err == nil {
+				t.Errorf("%s: expected an error", c.name)
+			}
+			continue
+		}
+		if err != nil || key != c.expected {
+			t.Errorf("%s: expected key %q, got %q (%v)", c.name, c.expected, key, err)
+		}
+	}
+}
+
+func TestValidateKeyLocation(t *testing.T) {
+	cases := []struct {
+		location string
+		header   string
+		value    string
+		query    string
+		expected int
+	}{
+		{"header", "Authorization", "Bearer secret", "", http.StatusOK},
+		{"header", "X-API-Key", "secret", "", http.StatusOK},
+		{"header", apiKeyHeader, "secret", "", http.StatusOK},
+		{"header", apiKeyHeader, "other", "", http.StatusUnauthorized},
+		{"header", apiKeyHeader, "secre", "", http.StatusUnauthorized},
+		{"header", "", "", "key=secret", http.StatusUnauthorized},
+		{"query", apiKeyHeader, "secret", "", http.StatusUnauthorized},
+		{"query", "", "", "key=secret", http.StatusOK},
+		{"both", apiKeyHeader, "secret", "", http.StatusOK},
+		{"both", "", "", "key=secret", http.StatusOK},
+		{"both", "", "", "", http.StatusUnauthorized},
+	}
+
+	keys := map[string]KeyConfig{"secret": {}}
+	for _, c := range cases {
+		handler := validateKey(keys, c.location, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {})
+		req := httptest.NewRequest("GET", "/resize?"+c.query, nil)
+		if c.header != "" {
+			req.Header.Set(c.header, c.value)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req, nil)
+		if w.Code != c.expected {
+			t.Errorf("%s (%s %q %s): expected status %d, got %d", c.location, c.header, c.value, c.query, c.expected, w.Code)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+/*
+#cgo pkg-config: vips
+#include <vips/vips.h>
+
+// ssim_buffer computes the mean SSIM of the luminance of both images, with
+// a gaussian window of sigma 1.5. The alpha channels are ignored.
+static int
+ssim_buffer(void *a, size_t a_len, void *b, size_t b_len, double *out) {
+	VipsImage *base = vips_image_new(), **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 28);
+	void *bufs[2] = {a, b};
+	size_t lens[2] = {a_len, b_len};
+	VipsImage *x[2];
+
+	for (int i = 0; i < 2; i++) {
+		VipsImage **u = t + i * 3;
+		if ((u[0] = vips_image_new_from_buffer(bufs[i], lens[i], "", NULL)) == NULL ||
+			vips_colourspace(u[0], &u[1], VIPS_INTERPRETATION_B_W, NULL) ||
+			vips_extract_band(u[1], &u[2], 0, NULL)) {
+			g_object_unref(base);
+			return 1;
+		}
+		x[i] = u[2];
+	}
+	if (x[0]->Xsize != x[1]->Xsize || x[0]->Ysize != x[1]->Ysize) {
+		vips_error("ssim", "images differ in size");
+		g_object_unref(base);
+		return 1;
+	}
+
+	// (2 mu_x mu_y + c1)(2 sigma_xy + c2) / ((mu_x^2 + mu_y^2 + c1)(sigma_x^2 + sigma_y^2 + c2))
+	double c1 = 6.5025, c2 = 58.5225;
+	if (vips_cast(x[0], &t[6], VIPS_FORMAT_FLOAT, NULL) ||
+		vips_cast(x[1], &t[7], VIPS_FORMAT_FLOAT, NULL) ||
+		vips_gaussblur(t[6], &t[8], 1.5, NULL) ||
+		vips_gaussblur(t[7], &t[9], 1.5, NULL) ||
+		vips_multiply(t[6], t[6], &t[10], NULL) ||
+		vips_multiply(t[7], t[7], &t[11], NULL) ||
+		vips_multiply(t[6], t[7], &t[12], NULL) ||
+		vips_gaussblur(t[10], &t[13], 1.5, NULL) ||
+		vips_gaussblur(t[11], &t[14], 1.5, NULL) ||
+		vips_gaussblur(t[12], &t[15], 1.5, NULL) ||
+		vips_multiply(t[8], t[9], &t[16], NULL) ||
+		vips_multiply(t[8], t[8], &t[17], NULL) ||
+		vips_multiply(t[9], t[9], &t[18], NULL) ||
+		vips_subtract(t[15], t[16], &t[19], NULL) ||
+		vips_linear1(t[16], &t[20], 2, c1, NULL) ||
+		vips_linear1(t[19], &t[21], 2, c2, NULL) ||
+		vips_multiply(t[20], t[21], &t[22], NULL) ||
+		vips_add(t[17], t[18], &t[23], NULL) ||
+		vips_add(t[13], t[14], &t[24], NULL) ||
+		vips_subtract(t[24], t[23], &t[25], NULL) ||
+		vips_linear1(t[23], &t[26], 1, c1, NULL) ||
+		vips_linear1(t[25], &t[27], 1, c2, NULL)) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	VipsImage *denominator = NULL, *ssim = NULL;
+	int err = vips_multiply(t[26], t[27], &denominator, NULL) ||
+		vips_divide(t[22], denominator, &ssim, NULL) ||
+		vips_avg(ssim, out, NULL);
+
+	if (denominator != NULL) {
+		g_object_unref(denominator);
+	}
+	if (ssim != NULL) {
+		g_object_unref(ssim);
+	}
+	g_object_unref(base);
+	return err;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"gopkg.in/h2non/bimg.v1"
+	"time"
+	"unsafe"
+)
+
+// The quality=auto search range, and its bound of trial encodes.
+const (
+	autoQualityMin    = 30
+	autoQualityMax    = 95
+	autoQualityTrials = 6
+)
+
+// autoQualityTypes are the lossy output types supporting quality=auto.
+var autoQualityTypes = map[bimg.ImageType]bool{
+	bimg.JPEG: true,
+	bimg.WEBP: true,
+	bimg.AVIF: true,
+}
+
+// autoQuality encodes the image with the lowest quality whose SSIM to the
+// losslessly processed image meets the -auto-quality-target, with a binary
+// search bounded by autoQualityTrials and by the processing deadline.
+func autoQuality(image []byte, kind bimg.ImageType, opts Options) ([]byte, error) {
+	// The options are reset to process losslessly once, as the reference
+	ref := opts
+	ref.AutoQuality, ref.Subsample, ref.Palette = false, "", PaletteOptions{}
+	ref.Flatten = opts.Flatten && !flattensBlack(kind, opts)
+	ref.Type, ref.Compression = bimg.PNG, 1
+	ref.WebP, ref.TIFF = WebPOptions{NearLossless: -1, Effort: -1}, TIFFOptions{}
+	reference, err := Resize(image, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	// The reference has the color filter applied already
+	trial := opts
+	trial.AutoQuality, trial.Color = false, ColorFilter{}
+	encodeAt := func(quality int) ([]byte, error) {
+		trial.Quality = quality
+		if save := customEncoder(kind, trial); save != nil {
+			return save(reference)
+		}
+		return defaultEncoder(kind, trial)(reference)
+	}
+
+	var best []byte
+	low, high := autoQualityMin, autoQualityMax
+	for trials := 0; low <= high && trials < autoQualityTrials; trials++ {
+		if !opts.Deadline.IsZero() && time.Now().After(opts.Deadline) {
+			break
+		}
+
+		quality := (low + high) / 2
+		buf, err := encodeAt(quality)
+		if err != nil {
+			return nil, err
+		}
+		score, err := ssim(reference, buf)
+		if err != nil {
+			return nil, err
+		}
+		if score >= opts.AutoQualityTarget {
+			best, high = buf, quality-1
+		} else {
+			low = quality + 1
+		}
+	}
+
+	if best == nil {
+		// No trial met the target in time, so the max quality is used
+		return encodeAt(autoQualityMax)
+	}
+	return best, nil
+}
+
+// ssim returns the structural similarity of the images luminance,
+// 1 when identical.
+func ssim(a, b []byte) (float64, error) {
+	if len(a) == 0 || len(b) == 0 {
+		return 0, errors.New("empty image")
+	}
+
+	var score C.double
+	if C.ssim_buffer(unsafe.Pointer(&a[0]), C.size_t(len(a)), unsafe.Pointer(&b[0]), C.size_t(len(b)), &score) != 0 {
+		return 0, vipsErrorf("cannot compare images")
+	}
+	return float64(score), nil
+}
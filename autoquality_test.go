@@ -0,0 +1,99 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+import (
+	"bytes"
+	"gopkg.in/h2non/bimg.v1"
+	"image"
+	"image/color"
+	"image/png"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// gradientFixture returns a PNG of a smooth gradient, simple to compress.
+func gradientFixture(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / width), uint8(y * 255 / height), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAutoQuality(t *testing.T) {
+	fixture := gradientFixture(t, 256, 256)
+	o := testServerOptions()
+
+	resize := func(query string, deadline time.Time) []byte {
+		values, _ := url.ParseQuery(query)
+		opts := NewOptions(o, "resize")
+		opts.Width, opts.Height, opts.Deadline = 200, 200, deadline
+		if err := readParams(values, &opts); err != nil {
+			t.Fatalf("%s: unexpected error: %s", query, err)
+		}
+		buf, err := Resize(fixture, opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", query, err)
+		}
+		return buf
+	}
+
+	for _, kind := range []bimg.ImageType{bimg.JPEG, bimg.WEBP} {
+		name := bimg.ImageTypeName(kind)
+		if !bimg.IsTypeSupportedSave(kind) {
+			continue
+		}
+
+		auto := resize("quality=auto&type="+name, time.Time{})
+		maxQuality := resize("quality=100&type="+name, time.Time{})
+		if bimg.DetermineImageType(auto) != kind {
+			t.Fatalf("%s: expected a %s output", name, name)
+		}
+		if len(auto) >= len(maxQuality) {
+			t.Errorf("%s: expected the auto quality image smaller than the max quality, got %d and %d bytes", name, len(auto), len(maxQuality))
+		}
+
+		reference := resize("type=png", time.Time{})
+		score, err := ssim(reference, auto)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+		if score < o.AutoQualityTarget {
+			t.Errorf("%s: expected the SSIM to meet the target %g, got %g", name, o.AutoQualityTarget, score)
+		}
+
+		// Past the deadline no trial runs, so the max search quality is used
+		late := resize("quality=auto&type="+name, time.Now().Add(-time.Second))
+		if bimg.DetermineImageType(late) != kind || len(late) < len(auto) {
+			t.Errorf("%s: expected the max search quality past the deadline, got %d bytes (auto %d)", name, len(late), len(auto))
+		}
+	}
+}
+
+func TestSSIM(t *testing.T) {
+	fixture := gradientFixture(t, 64, 64)
+	if score, err := ssim(fixture, fixture); err != nil || score < 0.999 {
+		t.Errorf("expected identical images to score 1, got %g (%v)", score, err)
+	}
+
+	blurred, err := bimg.Resize(fixture, bimg.Options{Type: bimg.PNG, GaussianBlur: bimg.GaussianBlur{Sigma: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if score, err := ssim(fixture, blurred); err != nil || score >= 0.999 {
+		t.Errorf("expected a blurred image to score lower, got %g (%v)", score, err)
+	}
+
+	if _, err := ssim(fixture, gradientFixture(t, 32, 32)); err == nil {
+		t.Error("expected an error for images of different sizes")
+	}
+}
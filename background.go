@@ -0,0 +1,52 @@
+package main
+
+import (
+	"image/color"
+	"math"
+)
+
+// edgeSpread is the max channel difference between the edge samples and
+// their average, beyond which the edges have no uniform color.
+const edgeSpread = 48
+
+// edgeColor returns the average color of the image corners and edge
+// midpoints, sampled from the downsampled image, so letterboxed images
+// blend into the background. It falls back to white when the edges differ,
+// or are transparent.
+func edgeColor(buf []byte, noAutoRotate bool) (Color, error) {
+	img, err := sampleImage(buf, noAutoRotate)
+	if err != nil {
+		return Color{}, err
+	}
+
+	b := img.Bounds()
+	xs := []int{b.Min.X, (b.Min.X + b.Max.X - 1) / 2, b.Max.X - 1}
+	ys := []int{b.Min.Y, (b.Min.Y + b.Max.Y - 1) / 2, b.Max.Y - 1}
+	var samples []color.NRGBA
+	for i, y := range ys {
+		for j, x := range xs {
+			if i == 1 && j == 1 {
+				// The center is not an edge
+				continue
+			}
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A < 128 {
+				return white, nil
+			}
+			samples = append(samples, c)
+		}
+	}
+
+	var r, g, bl float64
+	for _, c := range samples {
+		r, g, bl = r+float64(c.R), g+float64(c.G), bl+float64(c.B)
+	}
+	n := float64(len(samples))
+	r, g, bl = r/n, g/n, bl/n
+	for _, c := range samples {
+		if math.Abs(float64(c.R)-r) > edgeSpread || math.Abs(float64(c.G)-g) > edgeSpread || math.Abs(float64(c.B)-bl) > edgeSpread {
+			return white, nil
+		}
+	}
+	return Color{uint8(math.Round(r)), uint8(math.Round(g)), uint8(math.Round(bl)), 255}, nil
+}
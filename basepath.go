@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// normalizeBasePath validates the route prefix, returning it with no
+// trailing slash, or empty for the root path.
+func normalizeBasePath(path string) (string, error) {
+	path = strings.TrimRight(path, "/")
+	if path == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, "?#") {
+		return "", fmt.Errorf("invalid base path: %s", path)
+	}
+	return path, nil
+}
+
+// withBasePath serves the routes under the base path, stripping it from the
+// request path, so the URL signatures are computed without the prefix and
+// remain valid wherever resizr is mounted. The health and metrics endpoints
+// are optionally kept at the root path, for the probes of the ingress.
+func withBasePath(base string, rootHealth, rootMetrics bool, next http.Handler) http.Handler {
+	strip := http.StripPrefix(base, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logOperation(r, requestOperation(r))
+		next.ServeHTTP(w, r)
+	}))
+	mux := http.NewServeMux()
+	mux.Handle(base+"/", strip)
+	if rootHealth {
+		mux.HandleFunc("/health", healthController)
+		mux.HandleFunc("/health/ready", readinessController)
+	}
+	if rootMetrics {
+		mux.HandleFunc("/metrics", metricsController)
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, NewError("not found", http.StatusNotFound))
+	})
+
+	// The operations are only served under the base path, with no ServeMux
+	// path cleaning, which would redirect the image URLs given in the path
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logOperation(r, "")
+		if strings.HasPrefix(r.URL.Path, base+"/") {
+			strip.ServeHTTP(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNormalizeBasePath(t *testing.T) {
+	cases := []struct {
+		path     string
+		expected string
+		fails    bool
+	}{
+		{"", "", false},
+		{"/", "", false},
+		{"/images", "/images", false},
+		{"/images/", "/images", false},
+		{"/cdn/images", "/cdn/images", false},
+		{"images", "", true},
+		{"/images?v=1", "", true},
+		{"/images#top", "", true},
+	}
+
+	for _, c := range cases {
+		path, err := normalizeBasePath(c.path)
+		if (err != nil) != c.fails || path != c.expected {
+			t.Errorf("%q: expected %q (error %t), got %q (%v)", c.path, c.expected, c.fails, path, err)
+		}
+	}
+}
+
+func TestWithBasePath(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Path", r.URL.Path)
+	})
+
+	cases := []struct {
+		path       string
+		rootHealth bool
+		code       int
+		expected   string
+	}{
+		{"/images/resize/300x200/image.jpg", false, http.StatusOK, "/resize/300x200/image.jpg"},
+		{"/images/resize/300x200/http://server.com/image.jpg", false, http.StatusOK, "/resize/300x200/http://server.com/image.jpg"},
+		{"/images/health", false, http.StatusOK, "/health"},
+		{"/images/", false, http.StatusOK, "/"},
+		{"/resize/300x200/image.jpg", false, http.StatusNotFound, ""},
+		{"/imagesresize/300x200/image.jpg", false, http.StatusNotFound, ""},
+		{"/health", false, http.StatusNotFound, ""},
+		{"/health", true, http.StatusOK, ""},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		withBasePath("/images", c.rootHealth, false, next).ServeHTTP(w, httptest.NewRequest("GET", c.path, nil))
+		if w.Code != c.code {
+			t.Errorf("%s: expected status %d, got %d", c.path, c.code, w.Code)
+		}
+		if path := w.Header().Get("Path"); path != c.expected {
+			t.Errorf("%s: expected the %q stripped path, got %q", c.path, c.expected, path)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/h2non/bimg.v1"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const batchManifest = "manifest.json"
+
+var unsafeNameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// BatchResult describes a batch variant in the archive manifest.
+type BatchResult struct {
+	File      string                 `json:"file,omitempty"`
+	Operation string                 `json:"operation"`
+	Params    map[string]interface{} `json:"params"`
+	Fallback  string                 `json:"fallback,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+}
+
+// batchController replies a ZIP archive with a variant of the source image
+// per operation of the JSON body. Variants are streamed to the client as
+// soon as processed, and the failed ones are reported in the manifest.
+func batchController(o ServerOptions, sources []ImageSource, watermarks *WatermarkStore, queue *OpQueue) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		var variants []PipelineStage
+		body := http.MaxBytesReader(w, r.Body, maxPipelineBodySize(o))
+		if err := json.NewDecoder(body).Decode(&variants); err != nil {
+			writeError(w, jsonBodyError("batch", err))
+			return
+		}
+		if len(variants) == 0 {
+			badRequest(w, "batch requires at least one operation")
+			return
+		}
+		if len(variants) > o.MaxBatchVariants {
+			badRequest(w, fmt.Sprintf("batch exceeds the maximum of %d variants", o.MaxBatchVariants))
+			return
+		}
+
+		source := matchSource(sources, r)
+		image, err := source.GetImage(w, r, ps)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		metrics.AddBytesIn(len(image))
+
+		if err := validateImage(image, o); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		processing, cancel, err := newProcessing(r, o)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer cancel()
+		release, err := queue.Acquire(processing.ctx)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer processing.Release(release)
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="batch.zip"`)
+		archive := zip.NewWriter(w)
+
+		names := map[string]bool{}
+		results := make([]BatchResult, len(variants))
+		for i, variant := range variants {
+			results[i] = BatchResult{Operation: variant.Operation, Params: variant.Params}
+
+			buf, fallback, err := runStage(processing, o, r, watermarks, image, variant)
+			if err != nil {
+				results[i].Error = publicError(w, err).Error()
+				continue
+			}
+			results[i].Fallback = fallback
+
+			name := variantName(variant, bimg.DetermineImageType(buf), names)
+			file, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+			if err != nil {
+				debug("batch archive error: %s", err)
+				return
+			}
+			if _, err := file.Write(buf); err != nil {
+				debug("batch archive error: %s", err)
+				return
+			}
+			results[i].File = name
+		}
+
+		manifest, _ := json.MarshalIndent(results, "", "  ")
+		if file, err := archive.Create(batchManifest); err == nil {
+			file.Write(manifest)
+		}
+		archive.Close()
+	}
+}
+
+// variantName returns the archive file name of a variant, derived from
+// its operation and sorted params, such as resize_quality-80_width-300.jpeg
+func variantName(variant PipelineStage, kind bimg.ImageType, names map[string]bool) string {
+	keys := make([]string, 0, len(variant.Params))
+	for key := range variant.Params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := []string{variant.Operation}
+	for _, key := range keys {
+		parts = append(parts, key+"-"+fmt.Sprint(variant.Params[key]))
+	}
+	base := unsafeNameChars.ReplaceAllString(strings.Join(parts, "_"), "-")
+
+	name := base + "." + bimg.ImageTypeName(kind)
+	for n := 2; names[name]; n++ {
+		name = fmt.Sprintf("%s_%d.%s", base, n, bimg.ImageTypeName(kind))
+	}
+	names[name] = true
+	return name
+}
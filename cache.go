@@ -0,0 +1,217 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"gopkg.in/h2non/bimg.v1"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache stores processed images by request signature.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, buf []byte) error
+}
+
+var cacheIgnoredParams = map[string]bool{"sign": true, "timeout": true, "filename": true, "disposition": true, "expires": true}
+
+// cacheKey returns the signature of the operation request, output type and
+// source image, so any change in the params or in the source content
+// produces a new key. The signature, timeout and download params do not change
+// the output image. The server output defaults, the clamped size and the
+// watermark image content are signed as well, so they invalidate the
+// cached images and the ETags when changed.
+func cacheKey(r *http.Request, opts Options, image []byte) string {
+	query := url.Values{}
+	for key, values := range r.URL.Query() {
+		if !cacheIgnoredParams[key] {
+			query[key] = values
+		}
+	}
+	source := sha256.Sum256(image)
+
+	hash := sha256.New()
+	hash.Write([]byte(r.URL.EscapedPath() + "?" + query.Encode() + "\n"))
+	hash.Write([]byte(bimg.ImageTypeName(opts.Type) + "\n"))
+	fmt.Fprintf(hash, "%dx%d autorotate=%t interlace=%t stripprofile=%t strip=%t srgb=%t enlarge=%t frames=%d target=%g quality=%+v\n",
+		opts.Width, opts.Height, !opts.NoAutoRotate, opts.Interlace, opts.StripProfile, opts.StripMetadata, opts.ConvertSRGB,
+		opts.Enlarge, opts.MaxFrames, opts.AutoQualityTarget, opts.Defaults)
+	if opts.DefaultBackground != nil {
+		fmt.Fprintf(hash, "background=%+v\n", *opts.DefaultBackground)
+	}
+	if len(opts.Watermark.Image) > 0 {
+		watermark := sha256.Sum256(opts.Watermark.Image)
+		hash.Write(watermark[:])
+	}
+	hash.Write(source[:])
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// cacheTempPrefix names the files being written, which are not entries yet.
+const cacheTempPrefix = ".tmp-"
+
+type diskEntry struct {
+	key      string
+	size     int64
+	modified time.Time
+}
+
+// DiskCache stores cached images as files in a directory, evicting the least
+// recently used entries when the size limit is exceeded, and the entries
+// older than the TTL in background.
+type DiskCache struct {
+	dir     string
+	maxSize int64
+	ttl     time.Duration
+
+	mutex   sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+func NewDiskCache(dir string, maxSize int64, ttl time.Duration) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	c := &DiskCache{
+		dir:     dir,
+		maxSize: maxSize,
+		ttl:     ttl,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	if ttl > 0 {
+		go c.cleanup()
+	}
+	return c, nil
+}
+
+// load indexes the existing cache files, the oldest first.
+func (c *DiskCache) load() error {
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().After(files[j].ModTime())
+	})
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		// Left over by a Set interrupted before the rename
+		if strings.HasPrefix(file.Name(), cacheTempPrefix) {
+			os.Remove(c.path(file.Name()))
+			continue
+		}
+		entry := &diskEntry{file.Name(), file.Size(), file.ModTime()}
+		c.entries[entry.key] = c.lru.PushBack(entry)
+		c.size += entry.size
+	}
+	c.evict()
+	return nil
+}
+
+func (c *DiskCache) Get(key string) ([]byte, bool) {
+	c.mutex.Lock()
+	elem, ok := c.entries[key]
+	if ok {
+		if c.expired(elem.Value.(*diskEntry)) {
+			c.remove(elem)
+			ok = false
+		} else {
+			c.lru.MoveToFront(elem)
+		}
+	}
+	c.mutex.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	buf, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return buf, true
+}
+
+func (c *DiskCache) Set(key string, buf []byte) error {
+	// Write to a temporary file first, so readers never see partial files
+	tmp, err := ioutil.TempFile(c.dir, cacheTempPrefix)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.size -= elem.Value.(*diskEntry).size
+		c.lru.Remove(elem)
+	}
+	entry := &diskEntry{key, int64(len(buf)), time.Now()}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.size += entry.size
+	c.evict()
+	return nil
+}
+
+func (c *DiskCache) evict() {
+	for c.maxSize > 0 && c.size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *DiskCache) remove(elem *list.Element) {
+	entry := elem.Value.(*diskEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+	os.Remove(c.path(entry.key))
+}
+
+func (c *DiskCache) expired(entry *diskEntry) bool {
+	return c.ttl > 0 && time.Since(entry.modified) > c.ttl
+}
+
+func (c *DiskCache) cleanup() {
+	ticker := time.NewTicker(time.Minute)
+	for range ticker.C {
+		c.mutex.Lock()
+		for _, elem := range c.entries {
+			if c.expired(elem.Value.(*diskEntry)) {
+				c.remove(elem)
+			}
+		}
+		c.mutex.Unlock()
+	}
+}
+
+func (c *DiskCache) path(key string) string {
+	return filepath.Join(c.dir, key)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"gopkg.in/h2non/bimg.v1"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestCache(t *testing.T, maxSize int64, ttl time.Duration) (*DiskCache, string) {
+	dir, err := ioutil.TempDir("", "resizr-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	cache, err := NewDiskCache(dir, maxSize, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache, dir
+}
+
+func TestDiskCacheGetSet(t *testing.T) {
+	cache, _ := newTestCache(t, 0, 0)
+	if _, ok := cache.Get("missing"); ok {
+		t.Error("expected a miss")
+	}
+	if err := cache.Set("key", []byte("image")); err != nil {
+		t.Fatal(err)
+	}
+	if buf, ok := cache.Get("key"); !ok || string(buf) != "image" {
+		t.Errorf("expected a hit, got %q", buf)
+	}
+	if err := cache.Set("key", []byte("new image")); err != nil {
+		t.Fatal(err)
+	}
+	if buf, ok := cache.Get("key"); !ok || string(buf) != "new image" {
+		t.Errorf("expected the replaced image, got %q", buf)
+	}
+	if cache.size != int64(len("new image")) {
+		t.Errorf("expected the replaced entry size only, got %d", cache.size)
+	}
+}
+
+func TestDiskCacheEviction(t *testing.T) {
+	cache, dir := newTestCache(t, 10, 0)
+	cache.Set("a", []byte("aaaa"))
+	cache.Set("b", []byte("bbbb"))
+	// Reading a makes b the least recently used entry
+	cache.Get("a")
+	cache.Set("c", []byte("cccc"))
+
+	for key, expected := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := cache.Get(key); ok != expected {
+			t.Errorf("%s: expected cached %t, got %t", key, expected, ok)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b")); !os.IsNotExist(err) {
+		t.Error("expected the evicted file to be removed")
+	}
+	if cache.size != 8 {
+		t.Errorf("expected a cache size of 8, got %d", cache.size)
+	}
+}
+
+func TestDiskCacheExpiry(t *testing.T) {
+	cache, dir := newTestCache(t, 0, time.Hour)
+	cache.Set("key", []byte("image"))
+	cache.entries["key"].Value.(*diskEntry).modified = time.Now().Add(-2 * time.Hour)
+
+	if _, ok := cache.Get("key"); ok {
+		t.Error("expected the expired entry to be a miss")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "key")); !os.IsNotExist(err) {
+		t.Error("expected the expired file to be removed")
+	}
+}
+
+func TestDiskCacheLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resizr-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "old"), []byte("old!"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "new"), []byte("new!"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, cacheTempPrefix+"123"), []byte("partial"), 0644)
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(dir, "old"), past, past)
+
+	cache, err := NewDiskCache(dir, 6, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get("new"); !ok {
+		t.Error("expected the existing file to be loaded")
+	}
+	if _, ok := cache.Get("old"); ok {
+		t.Error("expected the oldest file to be evicted over the size limit")
+	}
+	if _, ok := cache.entries[cacheTempPrefix+"123"]; ok {
+		t.Error("expected the temporary file not to be loaded")
+	}
+	if _, err := os.Stat(filepath.Join(dir, cacheTempPrefix+"123")); !os.IsNotExist(err) {
+		t.Error("expected the temporary file to be removed")
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	key := func(target string, opts Options, image string) string {
+		return cacheKey(httptest.NewRequest("GET", target, nil), opts, []byte(image))
+	}
+	const target = "/resize/300x200/http://server.com/image.jpg?quality=80"
+	opts := Options{Width: 300, Height: 200, Type: bimg.WEBP}
+	base := key(target, opts, "image")
+
+	if key(target, opts, "image") != base {
+		t.Error("expected the same request to have the same key")
+	}
+	if key(target+"&sign=abc&timeout=5&filename=photo.jpg", opts, "image") != base {
+		t.Error("expected the ignored params not to change the key")
+	}
+
+	watermarked := opts
+	watermarked.Watermark.Image = []byte("watermark")
+	changed := watermarked
+	changed.Watermark.Image = []byte("other watermark")
+	clamped := opts
+	clamped.Width = 250
+	converted := opts
+	converted.Type = bimg.AVIF
+
+	cases := []struct {
+		name string
+		key  string
+	}{
+		{"param", key("/resize/300x200/http://server.com/image.jpg?quality=90", opts, "image")},
+		{"path", key("/resize/300x300/http://server.com/image.jpg?quality=80", opts, "image")},
+		{"source", key(target, opts, "other image")},
+		{"type", key(target, converted, "image")},
+		{"size", key(target, clamped, "image")},
+		{"watermark", key(target, watermarked, "image")},
+		{"watermark image", key(target, changed, "image")},
+	}
+	for _, c := range cases {
+		if c.key == base {
+			t.Errorf("%s: expected a new key", c.name)
+		}
+	}
+	if cases[5].key == cases[6].key {
+		t.Error("expected the watermark image content to change the key")
+	}
+}
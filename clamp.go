@@ -0,0 +1,68 @@
+package main
+
+import (
+	"gopkg.in/h2non/bimg.v1"
+	"math"
+)
+
+// clampedHeader reports the requested dimensions were reduced to the limits.
+const clampedHeader = "X-Dimension-Clamped"
+
+// enlargeOperations are the operations which upscale smaller images to the size.
+var enlargeOperations = map[string]bool{
+	"resize":    true,
+	"crop":      true,
+	"thumbnail": true,
+	"blur":      true,
+	"sharpen":   true,
+	"watermark": true,
+}
+
+// clampOutput scales down the requested dimensions to the -max-output-width
+// and -max-output-height limits, keeping their aspect ratio.
+func clampOutput(opts *Options, o ServerOptions) bool {
+	scale := 1.0
+	if o.MaxOutputWidth > 0 && opts.Width > o.MaxOutputWidth {
+		scale = math.Min(scale, float64(o.MaxOutputWidth)/float64(opts.Width))
+	}
+	if o.MaxOutputHeight > 0 && opts.Height > o.MaxOutputHeight {
+		scale = math.Min(scale, float64(o.MaxOutputHeight)/float64(opts.Height))
+	}
+	return scaleDimensions(opts, scale)
+}
+
+// clampEnlarge scales down the requested dimensions to the source image size,
+// unless the enlarge param is enabled.
+func clampEnlarge(image []byte, opts *Options) (bool, error) {
+	if opts.Enlarge || !enlargeOperations[opts.Operation] {
+		return false, nil
+	}
+
+	meta, err := bimg.Metadata(image)
+	if err != nil {
+		return false, err
+	}
+	width, height := orientedSize(meta, opts.NoAutoRotate)
+
+	scale := 1.0
+	if opts.Width > width {
+		scale = math.Min(scale, float64(width)/float64(opts.Width))
+	}
+	if opts.Height > height {
+		scale = math.Min(scale, float64(height)/float64(opts.Height))
+	}
+	return scaleDimensions(opts, scale), nil
+}
+
+func scaleDimensions(opts *Options, scale float64) bool {
+	if scale >= 1 {
+		return false
+	}
+	if opts.Width > 0 {
+		opts.Width = int(math.Max(1, math.Floor(float64(opts.Width)*scale)))
+	}
+	if opts.Height > 0 {
+		opts.Height = int(math.Max(1, math.Floor(float64(opts.Height)*scale)))
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"sync"
+)
+
+// sourceFetch downloads the source image, setting the response headers
+// derived from the source, such as Last-Modified.
+type sourceFetch func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) ([]byte, error)
+
+// sourceGroup coalesces the concurrent fetches of the same source image,
+// so a stampede of identical requests downloads it once. A nil group
+// fetches every request.
+type sourceGroup struct {
+	mutex sync.Mutex
+	calls map[string]*sourceCall
+}
+
+type sourceCall struct {
+	done     chan struct{}
+	image    []byte
+	header   http.Header
+	err      error
+	canceled bool
+}
+
+func newSourceGroup() *sourceGroup {
+	return &sourceGroup{calls: map[string]*sourceCall{}}
+}
+
+// Do fetches the image identified by key, or waits for the running fetch
+// of the same key, sharing its image and headers. The fetches aborted by
+// the leading client disconnection are retried by the waiting requests.
+func (g *sourceGroup) Do(key string, w http.ResponseWriter, r *http.Request, ps httprouter.Params, fetch sourceFetch) ([]byte, error) {
+	if g == nil || key == "" {
+		return fetch(w, r, ps)
+	}
+
+	g.mutex.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mutex.Unlock()
+		select {
+		case <-call.done:
+		case <-r.Context().Done():
+			return nil, fetchError(r.Context().Err())
+		}
+		if call.canceled {
+			return fetch(w, r, ps)
+		}
+		copyHeader(w.Header(), call.header)
+		return call.image, call.err
+	}
+	call := &sourceCall{done: make(chan struct{}), header: http.Header{}}
+	g.calls[key] = call
+	g.mutex.Unlock()
+
+	call.image, call.err = fetch(headerRecorder{header: call.header}, r, ps)
+	call.canceled = call.err != nil && r.Context().Err() != nil
+
+	g.mutex.Lock()
+	delete(g.calls, key)
+	g.mutex.Unlock()
+	close(call.done)
+
+	copyHeader(w.Header(), call.header)
+	return call.image, call.err
+}
+
+func copyHeader(dst, src http.Header) {
+	for name, values := range src {
+		dst[name] = append([]string(nil), values...)
+	}
+}
+
+// headerRecorder records the headers set by a source fetch, which writes no body.
+type headerRecorder struct {
+	header http.Header
+}
+
+func (h headerRecorder) Header() http.Header {
+	return h.header
+}
+
+func (h headerRecorder) Write(buf []byte) (int, error) {
+	return len(buf), nil
+}
+
+func (h headerRecorder) WriteHeader(status int) {}
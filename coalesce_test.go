@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSourceGroupCoalesces(t *testing.T) {
+	const clients = 50
+
+	var requests int32
+	var started sync.WaitGroup
+	started.Add(clients)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		// Hold the download until every client is waiting for it
+		started.Wait()
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("image"))
+	}))
+	defer server.Close()
+
+	fetcher := newTestFetcher(t, FetchOptions{Timeout: 5 * time.Second, MaxRedirects: 5})
+	fetch := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) ([]byte, error) {
+		w.Header().Set("Last-Modified", "Wed, 14 Oct 2026 10:00:00 GMT")
+		return fetcher.Fetch(r.Context(), server.URL)
+	}
+
+	group := newSourceGroup()
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			started.Done()
+			buf, err := group.Do(server.URL, w, httptest.NewRequest("GET", "/resize", nil), nil, fetch)
+			if err != nil || string(buf) != "image" {
+				t.Errorf("expected the shared image, got %v", err)
+			}
+			if w.Header().Get("Last-Modified") == "" {
+				t.Error("expected the shared source headers")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if requests != 1 {
+		t.Errorf("expected the upstream to be hit once, got %d requests", requests)
+	}
+}
+
+func TestSourceGroupWaiterCanceled(t *testing.T) {
+	release := make(chan struct{})
+	fetching := make(chan struct{})
+	fetch := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) ([]byte, error) {
+		close(fetching)
+		<-release
+		return []byte("image"), nil
+	}
+
+	group := newSourceGroup()
+	go group.Do("key", httptest.NewRecorder(), httptest.NewRequest("GET", "/resize", nil), nil, fetch)
+	<-fetching
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest("GET", "/resize", nil).WithContext(ctx)
+	if _, err := group.Do("key", httptest.NewRecorder(), r, nil, fetch); errorCode(err) != statusClientClosed {
+		t.Errorf("expected a client closed error, got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	r = httptest.NewRequest("GET", "/resize", nil).WithContext(ctx)
+	if _, err := group.Do("key", httptest.NewRecorder(), r, nil, fetch); errorCode(err) != http.StatusGatewayTimeout {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+}
+
+func TestSourceGroupLeaderCanceled(t *testing.T) {
+	var fetches int32
+	fetching := make(chan struct{})
+	fetch := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) ([]byte, error) {
+		if atomic.AddInt32(&fetches, 1) == 1 {
+			close(fetching)
+			<-r.Context().Done()
+			return nil, fetchError(r.Context().Err())
+		}
+		return []byte("image"), nil
+	}
+
+	group := newSourceGroup()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		group.Do("key", httptest.NewRecorder(), httptest.NewRequest("GET", "/resize", nil).WithContext(ctx), nil, fetch)
+	}()
+	<-fetching
+
+	result := make(chan error)
+	go func() {
+		buf, err := group.Do("key", httptest.NewRecorder(), httptest.NewRequest("GET", "/resize", nil), nil, fetch)
+		if err == nil && string(buf) != "image" {
+			err = NewError("unexpected image", http.StatusInternalServerError)
+		}
+		result <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	<-done
+
+	if err := <-result; err != nil {
+		t.Errorf("expected the waiter to fetch the image again, got %v", err)
+	}
+}
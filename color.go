@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"gopkg.in/h2non/bimg.v1"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Color is an RGBA color.
+type Color struct {
+	R, G, B, A uint8
+}
+
+var (
+	white       = Color{255, 255, 255, 255}
+	transparent = Color{0, 0, 0, 0}
+)
+
+// parseColor parses a color defined as comma separated "r,g,b[,a]"
+// values, or as hex "rrggbb[aa]" digits with an optional # prefix.
+func parseColor(value string) (Color, error) {
+	invalid := NewError(fmt.Sprintf("invalid color: %s", value), http.StatusBadRequest)
+
+	if strings.Contains(value, ",") {
+		parts := strings.Split(value, ",")
+		if len(parts) != 3 && len(parts) != 4 {
+			return Color{}, invalid
+		}
+		channels := []uint8{0, 0, 0, 255}
+		for i, part := range parts {
+			num, err := strconv.ParseUint(strings.TrimSpace(part), 10, 8)
+			if err != nil {
+				return Color{}, invalid
+			}
+			channels[i] = uint8(num)
+		}
+		return Color{channels[0], channels[1], channels[2], channels[3]}, nil
+	}
+
+	buf, err := hex.DecodeString(strings.TrimPrefix(value, "#"))
+	if err != nil || (len(buf) != 3 && len(buf) != 4) {
+		return Color{}, invalid
+	}
+	if len(buf) == 3 {
+		buf = append(buf, 255)
+	}
+	return Color{buf[0], buf[1], buf[2], buf[3]}, nil
+}
+
+// hasAlpha reports whether the image type supports transparency.
+func hasAlpha(code bimg.ImageType) bool {
+	return code == bimg.PNG || code == bimg.WEBP || code == bimg.AVIF || code == bimg.HEIF || code == bimg.TIFF
+}
+
+// readBackground reads the background param of the operations filling
+// an area, such as rotate or embed. The auto background is resolved from
+// the image edges.
+func readBackground(query url.Values, opts *Options) error {
+	if query.Get("background") == "auto" {
+		opts.AutoBackground = true
+		return nil
+	}
+	if value := query.Get("background"); value != "" {
+		color, err := parseColor(value)
+		if err != nil {
+			return err
+		}
+		opts.Background = &color
+	}
+	return nil
+}
+
+// fill returns the color filling an area: the background param, else the
+// -default-background, else the operation default.
+func (o Options) fill(color Color) Color {
+	if o.Background != nil {
+		return *o.Background
+	}
+	if o.DefaultBackground != nil {
+		return *o.DefaultBackground
+	}
+	return color
+}
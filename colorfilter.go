@@ -0,0 +1,141 @@
+package main
+
+/*
+#cgo pkg-config: vips
+#include <vips/vips.h>
+
+// color_filter_buffer converts the image to grayscale or sepia, and inverts
+// it, keeping the alpha channel as is. The image is saved as PNG.
+static int
+color_filter_buffer(void *buf, size_t len, int grayscale, int sepia, int negate, void **out, size_t *out_len) {
+	static double sepia_matrix[] = {
+		0.393, 0.769, 0.189,
+		0.349, 0.686, 0.168,
+		0.272, 0.534, 0.131,
+	};
+
+	VipsImage *base = vips_image_new(), **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 8);
+	VipsImage *image = t[0] = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (image == NULL) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	VipsImage *alpha = NULL;
+	if (vips_image_hasalpha(image)) {
+		if (vips_extract_band(image, &t[1], image->Bands - 1, NULL) ||
+			vips_extract_band(image, &t[2], 0, "n", image->Bands - 1, NULL)) {
+			g_object_unref(base);
+			return 1;
+		}
+		alpha = t[1];
+		image = t[2];
+	}
+
+	if (grayscale) {
+		if (vips_colourspace(image, &t[3], VIPS_INTERPRETATION_B_W, NULL)) {
+			g_object_unref(base);
+			return 1;
+		}
+		image = t[3];
+	}
+	if (sepia) {
+		t[4] = vips_image_new_matrix_from_array(3, 3, sepia_matrix, 9);
+		if (vips_colourspace(image, &t[5], VIPS_INTERPRETATION_sRGB, NULL) ||
+			vips_recomb(t[5], &t[6], t[4], NULL) ||
+			vips_cast(t[6], &t[7], VIPS_FORMAT_UCHAR, NULL)) {
+			g_object_unref(base);
+			return 1;
+		}
+		image = t[7];
+	}
+
+	VipsImage *inverted = NULL, *joined = NULL;
+	int err = 0;
+	if (negate) {
+		err = vips_invert(image, &inverted, NULL);
+		image = inverted;
+	}
+	if (err == 0 && alpha != NULL) {
+		err = vips_bandjoin2(image, alpha, &joined, NULL);
+		image = joined;
+	}
+	if (err == 0) {
+		err = vips_image_write_to_buffer(image, ".png", out, out_len, NULL);
+	}
+
+	if (inverted != NULL) {
+		g_object_unref(inverted);
+	}
+	if (joined != NULL) {
+		g_object_unref(joined);
+	}
+	g_object_unref(base);
+	return err;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"unsafe"
+)
+
+// ColorFilter defines the color transforms applied to the output image.
+type ColorFilter struct {
+	Grayscale bool
+	Sepia     bool
+	Negate    bool
+}
+
+func (f ColorFilter) enabled() bool {
+	return f.Grayscale || f.Sepia || f.Negate
+}
+
+// readColorParams reads the grayscale, sepia and negate params.
+// Sepia tones a grayscale image itself, so both are mutually exclusive.
+func readColorParams(query url.Values, opts *Options) error {
+	var err error
+	if opts.Color.Grayscale, err = parseBoolParam(query, "grayscale", false); err != nil {
+		return err
+	}
+	if opts.Color.Sepia, err = parseBoolParam(query, "sepia", false); err != nil {
+		return err
+	}
+	if opts.Color.Negate, err = parseBoolParam(query, "negate", false); err != nil {
+		return err
+	}
+
+	if opts.Color.Grayscale && opts.Color.Sepia {
+		return NewError("grayscale and sepia params are mutually exclusive", http.StatusBadRequest)
+	}
+	return nil
+}
+
+// colorFilter applies the color transforms to a losslessly processed image.
+func colorFilter(image []byte, f ColorFilter) ([]byte, error) {
+	if len(image) == 0 {
+		return nil, errors.New("empty image")
+	}
+
+	grayscale, sepia, negate := C.int(0), C.int(0), C.int(0)
+	if f.Grayscale {
+		grayscale = 1
+	}
+	if f.Sepia {
+		sepia = 1
+	}
+	if f.Negate {
+		negate = 1
+	}
+
+	var out unsafe.Pointer
+	var length C.size_t
+	if C.color_filter_buffer(unsafe.Pointer(&image[0]), C.size_t(len(image)), grayscale, sepia, negate, &out, &length) != 0 {
+		return nil, vipsErrorf("cannot apply color filter")
+	}
+	defer C.g_free(C.gpointer(out))
+	return C.GoBytes(out, C.int(length)), nil
+}
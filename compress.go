@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// compressibleTypes are the response types worth compressing. Encoded
+// images, such as JPEG or WebP, are already compressed.
+var compressibleTypes = map[string]bool{
+	"application/json": true,
+	"image/svg+xml":    true,
+	"text/plain":       true,
+	"text/html":        true,
+}
+
+// negotiateEncoding returns the preferred enabled encoding accepted by
+// the client, brotli first, or an empty string for identity. The *
+// wildcard only applies to the encodings the client does not list, so an
+// explicit q=0 always refuses the encoding.
+func negotiateEncoding(accept string, gzipEnabled, brotliEnabled bool) string {
+	accepted := acceptedValues(accept)
+	accepts := func(encoding string) bool {
+		if value, listed := accepted[encoding]; listed {
+			return value
+		}
+		return accepted["*"]
+	}
+	if brotliEnabled && accepts("br") {
+		return "br"
+	}
+	if gzipEnabled && accepts("gzip") {
+		return "gzip"
+	}
+	return ""
+}
+
+// withCompression compresses the JSON, SVG and text responses with the
+// encoding negotiated by the Accept-Encoding request header.
+func withCompression(gzipEnabled, brotliEnabled bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writer := &compressWriter{
+			ResponseWriter: w,
+			encoding:       negotiateEncoding(r.Header.Get("Accept-Encoding"), gzipEnabled, brotliEnabled),
+		}
+		defer writer.Close()
+		next.ServeHTTP(writer, r)
+	})
+}
+
+// compressWriter decides whether to compress on the response headers.
+// The compressed body is buffered, so the reply has an accurate
+// Content-Length, unless the handler flushes it as a stream.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	wroteHeader bool
+	status      int
+	buffer      *bytes.Buffer
+	writer      io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	header := w.Header()
+	if isCompressible(header.Get("Content-Type")) && header.Get("Content-Encoding") == "" {
+		header.Add("Vary", "Accept-Encoding")
+		if w.encoding != "" && status != http.StatusNoContent && status != http.StatusNotModified {
+			header.Set("Content-Encoding", w.encoding)
+			header.Del("Content-Length")
+			w.status, w.buffer = status, &bytes.Buffer{}
+			if w.encoding == "br" {
+				w.writer = brotli.NewWriter(writerFunc(w.writeCompressed))
+			} else {
+				w.writer = gzip.NewWriter(writerFunc(w.writeCompressed))
+			}
+			// The header is written once the body length is known
+			return
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressWriter) Write(buf []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(buf))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.writer != nil {
+		return w.writer.Write(buf)
+	}
+	return w.ResponseWriter.Write(buf)
+}
+
+func (w *compressWriter) writeCompressed(buf []byte) (int, error) {
+	if w.buffer != nil {
+		return w.buffer.Write(buf)
+	}
+	return w.ResponseWriter.Write(buf)
+}
+
+// stream writes the header and the buffered body, then writes the
+// compressed data as it comes, with no Content-Length.
+func (w *compressWriter) stream() {
+	if w.buffer == nil {
+		return
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(w.buffer.Bytes())
+	w.buffer = nil
+}
+
+// Close flushes the compressed stream, or replies the buffered body.
+func (w *compressWriter) Close() error {
+	if w.writer == nil {
+		return nil
+	}
+	err := w.writer.Close()
+	if w.buffer != nil {
+		w.Header().Set("Content-Length", strconv.Itoa(w.buffer.Len()))
+		w.stream()
+	}
+	return err
+}
+
+// Flush sends the compressed data written so far, for streamed responses.
+func (w *compressWriter) Flush() {
+	if flusher, ok := w.writer.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	w.stream()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// writerFunc adapts a function to an io.Writer.
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(buf []byte) (int, error) {
+	return f(buf)
+}
+
+// isCompressible reports whether the media type is compressed.
+func isCompressible(contentType string) bool {
+	kind, _, _ := mime.ParseMediaType(contentType)
+	return compressibleTypes[strings.ToLower(kind)]
+}
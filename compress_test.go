@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := []struct {
+		accept   string
+		gzip     bool
+		brotli   bool
+		expected string
+	}{
+		{"", true, true, ""},
+		{"gzip", true, true, "gzip"},
+		{"gzip, br", true, true, "br"},
+		{"gzip, br", true, false, "gzip"},
+		{"br", true, false, ""},
+		{"GZIP", true, true, "gzip"},
+		{"gzip;q=0.5, br;q=0.8", true, true, "br"},
+		{"br;q=0", true, true, ""},
+		{"br;q=0, gzip", true, true, "gzip"},
+		{"*", true, true, "br"},
+		{"*", true, false, "gzip"},
+		{"*", false, false, ""},
+		{"*;q=0", true, true, ""},
+		{"*, br;q=0", true, true, "gzip"},
+		{"br;q=0, *", true, true, "gzip"},
+		{"gzip;q=0, br;q=0, *", true, true, ""},
+		{"*;q=0, gzip", true, true, "gzip"},
+		{"identity", true, true, ""},
+	}
+
+	for _, c := range cases {
+		if encoding := negotiateEncoding(c.accept, c.gzip, c.brotli); encoding != c.expected {
+			t.Errorf("%q (gzip=%t, brotli=%t): expected %q, got %q", c.accept, c.gzip, c.brotli, c.expected, encoding)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/h2non/bimg.v1"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// skippedHeader reports the source image was replied as is, since the
+// if param condition did not match.
+const skippedHeader = "X-Processing-Skipped"
+
+// conditionOperators are sorted so the two characters operators match first.
+var conditionOperators = []string{">=", "<=", "==", "!=", ">", "<"}
+
+var conditionProperties = map[string]bool{
+	"width":  true,
+	"height": true,
+	"size":   true,
+}
+
+// Condition is the if param rule, comparing a source image property
+// with a number, such as width>2000.
+type Condition struct {
+	Property string
+	Operator string
+	Value    float64
+}
+
+// parseCondition parses the property, operator, number expression.
+func parseCondition(expr string) (*Condition, error) {
+	for _, operator := range conditionOperators {
+		i := strings.Index(expr, operator)
+		if i < 0 {
+			continue
+		}
+
+		property := strings.TrimSpace(expr[:i])
+		if !conditionProperties[property] {
+			return nil, NewError(fmt.Sprintf("unsupported if param property: %s", property), http.StatusBadRequest)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(expr[i+len(operator):]), 64)
+		if err != nil {
+			return nil, NewError(fmt.Sprintf("invalid if param number: %s", expr), http.StatusBadRequest)
+		}
+		return &Condition{Property: property, Operator: operator, Value: value}, nil
+	}
+	return nil, NewError(fmt.Sprintf("invalid if param expression: %s", expr), http.StatusBadRequest)
+}
+
+// Match evaluates the condition on the source image. The dimensions are
+// the displayed ones, once auto rotated, and the size is in bytes.
+func (c *Condition) Match(image []byte, noAutoRotate bool) (bool, error) {
+	var value float64
+	if c.Property == "size" {
+		value = float64(len(image))
+	} else {
+		meta, err := bimg.Metadata(image)
+		if err != nil {
+			return false, err
+		}
+		width, height := orientedSize(meta, noAutoRotate)
+		value = float64(width)
+		if c.Property == "height" {
+			value = float64(height)
+		}
+	}
+
+	switch c.Operator {
+	case ">=":
+		return value >= c.Value, nil
+	case "<=":
+		return value <= c.Value, nil
+	case "==":
+		return value == c.Value, nil
+	case "!=":
+		return value != c.Value, nil
+	case ">":
+		return value > c.Value, nil
+	default:
+		return value < c.Value, nil
+	}
+}
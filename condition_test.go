@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseCondition(t *testing.T) {
+	cases := []struct {
+		expr     string
+		expected Condition
+		code     int
+	}{
+		{"width>2000", Condition{"width", ">", 2000}, 0},
+		{"height<=1080", Condition{"height", "<=", 1080}, 0},
+		{"width >= 300", Condition{"width", ">=", 300}, 0},
+		{"size==1024", Condition{"size", "==", 1024}, 0},
+		{"size!=0", Condition{"size", "!=", 0}, 0},
+		{"height<1.5e3", Condition{"height", "<", 1500}, 0},
+		{"width", Condition{}, http.StatusBadRequest},
+		{"", Condition{}, http.StatusBadRequest},
+		{"depth>8", Condition{}, http.StatusBadRequest},
+		{"width>large", Condition{}, http.StatusBadRequest},
+		{"width=>300", Condition{}, http.StatusBadRequest},
+		{">300", Condition{}, http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		condition, err := parseCondition(c.expr)
+		if c.code != 0 {
+			if err == nil || errorCode(err) != c.code {
+				t.Errorf("%q: expected status %d, got %v", c.expr, c.code, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", c.expr, err)
+			continue
+		}
+		if *condition != c.expected {
+			t.Errorf("%q: expected %+v, got %+v", c.expr, c.expected, *condition)
+		}
+	}
+}
+
+func TestConditionMatchSize(t *testing.T) {
+	image := make([]byte, 1000)
+	cases := []struct {
+		expr     string
+		expected bool
+	}{
+		{"size>500", true},
+		{"size>1000", false},
+		{"size>=1000", true},
+		{"size<1000", false},
+		{"size<=1000", true},
+		{"size==1000", true},
+		{"size!=1000", false},
+	}
+
+	for _, c := range cases {
+		condition, err := parseCondition(c.expr)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %s", c.expr, err)
+		}
+		match, err := condition.Match(image, false)
+		if err != nil || match != c.expected {
+			t.Errorf("%q: expected match %t, got %t (%v)", c.expr, c.expected, match, err)
+		}
+	}
+}
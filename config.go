@@ -0,0 +1,258 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"strings"
+)
+
+// configFlags maps every configuration file key to the flag that overrides it.
+var configFlags = map[string]string{
+	"port":                   "p",
+	"logFormat":              "log-format",
+	"logLevel":               "log-level",
+	"accessLog":              "access-log",
+	"socket":                 "socket",
+	"socketMode":             "socket-mode",
+	"listen":                 "listen",
+	"address":                "a",
+	"burst":                  "burst",
+	"maxConcurrentOps":       "max-concurrent-ops",
+	"ipRateLimit":            "ip-rate-limit",
+	"ipRateWindow":           "ip-rate-window",
+	"trustProxy":             "trust-proxy",
+	"processingTimeout":      "processing-timeout",
+	"maxProcessingTimeout":   "max-processing-timeout",
+	"queueTimeout":           "queue-timeout",
+	"concurrency":            "concurrency",
+	"httpReadTimeout":        "http-read-timeout",
+	"httpWriteTimeout":       "http-write-timeout",
+	"httpIdleTimeout":        "http-idle-timeout",
+	"maxConnections":         "max-connections",
+	"shutdownTimeout":        "shutdown-timeout",
+	"publicVersions":         "public-versions",
+	"noIndex":                "no-index",
+	"noDebug":                "no-debug",
+	"noSourceCoalescing":     "no-source-coalescing",
+	"exposeSizeHeaders":      "expose-size-headers",
+	"maintenance":            "maintenance",
+	"metrics":                "metrics",
+	"metricsPort":            "metrics-port",
+	"basePath":               "base-path",
+	"rootHealth":             "root-health",
+	"maxPipelineOps":         "max-pipeline-ops",
+	"maxBatchVariants":       "max-batch-variants",
+	"maxConcurrentJobs":      "max-concurrent-jobs",
+	"maxQueuedJobs":          "max-queued-jobs",
+	"jobTTL":                 "job-ttl",
+	"maxBodySize":            "max-body-size",
+	"maxHeaderBytes":         "max-header-bytes",
+	"maxImageWidth":          "max-image-width",
+	"maxImageHeight":         "max-image-height",
+	"maxImagePixels":         "max-image-megapixels",
+	"maxDpr":                 "max-dpr",
+	"maxOutputWidth":         "max-output-width",
+	"maxOutputHeight":        "max-output-height",
+	"noEnlarge":              "no-enlarge",
+	"maxAnimationFrames":     "max-animation-frames",
+	"autoRotate":             "auto-rotate",
+	"interlace":              "interlace",
+	"stripProfile":           "strip-profile",
+	"convertSrgb":            "convert-srgb",
+	"stripMetadata":          "strip-metadata",
+	"quality.jpeg":           "jpeg-quality",
+	"quality.webp":           "webp-quality",
+	"quality.avif":           "avif-quality",
+	"quality.pngCompression": "png-compression",
+	"autoFormat":             "auto-format",
+	"autoQualityTarget":      "auto-quality-target",
+	"defaultFormat":          "default-format",
+	"passthroughUnchanged":   "passthrough-unchanged",
+	"formatFallback":         "format-fallback",
+	"defaultBackground":      "default-background",
+	"cacheDir":               "cache-dir",
+	"cacheMaxSize":           "cache-max-size",
+	"cacheTtl":               "cache-ttl",
+	"watermarkCacheTtl":      "watermark-cache-ttl",
+	"watermarkCacheMaxSize":  "watermark-cache-max-size",
+	"defaultFallbackImage":   "default-fallback-image",
+	"fallbackStatus":         "fallback-status",
+	"cors":                   "cors",
+	"corsOrigins":            "cors-origins",
+	"allowOperations":        "allow-operations",
+	"brotli":                 "brotli",
+	"gzip":                   "gzip",
+	"apiKey":                 "key",
+	"apiKeyFile":             "key-file",
+	"keys":                   "keys",
+	"keyLocation":            "key-location",
+	"adminKey":               "admin-key",
+	"certFile":               "certfile",
+	"http2":                  "http2",
+	"keyFile":                "keyfile",
+	"urlSignatureKey":        "url-signature-key",
+	"urlAllowHosts":          "url-allow-hosts",
+	"urlSourceTimeout":       "url-source-timeout",
+	"urlSourceRetries":       "url-source-retries",
+	"urlSourceMaxRedirects":  "url-source-max-redirects",
+	"urlSourceMaxBytes":      "url-source-max-bytes",
+	"urlSourceAllowTypes":    "url-source-allow-types",
+	"urlSourceUserAgent":     "url-source-user-agent",
+	"urlSourceHeaders":       "url-source-header",
+	"s3.enabled":             "enable-s3-source",
+	"s3.bucket":              "s3-bucket",
+	"s3.region":              "s3-region",
+	"s3.timeout":             "s3-timeout",
+	"s3.retries":             "s3-retries",
+	"gcs.enabled":            "enable-gcs-source",
+	"gcs.bucket":             "gcs-bucket",
+	"gcs.endpoint":           "gcs-endpoint",
+	"azure.enabled":          "enable-azure-source",
+	"azure.account":          "azure-account",
+	"azure.container":        "azure-container",
+	"azure.timeout":          "azure-timeout",
+	"vips.cacheMax":          "vips-cache-max",
+	"vips.cacheMaxMem":       "vips-cache-max-mem",
+	"vips.concurrency":       "vips-concurrency",
+	"vips.maxFiles":          "vips-max-files",
+}
+
+// configFile is the configuration file layout. API and admin keys are not
+// ServerOptions fields, so they are never dumped with the effective config.
+type configFile struct {
+	ServerOptions `yaml:",inline"`
+	ApiKey        string `yaml:"apiKey"`
+	ApiKeyFile    string `yaml:"apiKeyFile"`
+	Keys          string `yaml:"keys"`
+	AdminKey      string `yaml:"adminKey"`
+}
+
+// LoadConfig reads a YAML or JSON configuration file into ServerOptions,
+// including the API keys and the admin key. Unknown keys are reported as
+// an error.
+func LoadConfig(path string) (ServerOptions, error) {
+	var c configFile
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c.ServerOptions, fmt.Errorf("cannot read config file: %s", err)
+	}
+
+	if err := yaml.UnmarshalStrict(buf, &c); err != nil {
+		return c.ServerOptions, fmt.Errorf("invalid config file: %s", err)
+	}
+
+	key, err := resolveAPIKey(c.ApiKey, c.ApiKeyFile)
+	if err != nil {
+		return c.ServerOptions, err
+	}
+	if c.APIKeys, err = parseAPIKeys(key, c.Keys); err != nil {
+		return c.ServerOptions, err
+	}
+	c.ServerOptions.AdminKey = c.AdminKey
+	return c.ServerOptions, nil
+}
+
+// applyConfig sets every flag not explicitly passed on the command line
+// from the configuration file, so precedence is flag > file > default.
+func applyConfig(flags *flag.FlagSet, path string) error {
+	if _, err := LoadConfig(path); err != nil {
+		return err
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	values := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(buf, &values); err != nil {
+		return err
+	}
+
+	explicit := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	return setConfigFlags(flags, "", values, explicit)
+}
+
+func setConfigFlags(flags *flag.FlagSet, prefix string, values map[interface{}]interface{}, explicit map[string]bool) error {
+	for k, value := range values {
+		key := prefix + fmt.Sprint(k)
+		if nested, ok := value.(map[interface{}]interface{}); ok {
+			if err := setConfigFlags(flags, key+".", nested, explicit); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := configFlags[key]
+		if explicit[name] {
+			continue
+		}
+		if list, ok := value.([]interface{}); ok && isRepeated(flags, name) {
+			// Repeated flags are set once per value, which may contain commas
+			for _, item := range list {
+				if err := flags.Set(name, fmt.Sprint(item)); err != nil {
+					return fmt.Errorf("invalid config value for %s: %s", key, err)
+				}
+			}
+			continue
+		}
+		if err := flags.Set(name, configValue(value)); err != nil {
+			return fmt.Errorf("invalid config value for %s: %s", key, err)
+		}
+	}
+	return nil
+}
+
+func isRepeated(flags *flag.FlagSet, name string) bool {
+	f := flags.Lookup(name)
+	if f == nil {
+		return false
+	}
+	_, ok := f.Value.(*repeatedFlag)
+	return ok
+}
+
+func configValue(value interface{}) string {
+	if list, ok := value.([]interface{}); ok {
+		values := make([]string, len(list))
+		for i, v := range list {
+			values[i] = fmt.Sprint(v)
+		}
+		return strings.Join(values, ",")
+	}
+	return fmt.Sprint(value)
+}
+
+// redactedValue replaces the secrets in the dumped config.
+const redactedValue = "REDACTED"
+
+// dumpConfig prints the effective config. The API and admin keys are
+// never dumped, while the URL signature key and the URL source header
+// values are redacted.
+func dumpConfig(o ServerOptions) {
+	buf, err := yaml.Marshal(redactConfig(o))
+	if err != nil {
+		exitWithError("cannot dump config: %s\n", err)
+	}
+	fmt.Print(string(buf))
+}
+
+func redactConfig(o ServerOptions) ServerOptions {
+	if o.URLSignatureKey != "" {
+		o.URLSignatureKey = redactedValue
+	}
+	if len(o.URLSourceHeaders) > 0 {
+		headers := make([]string, len(o.URLSourceHeaders))
+		for i, header := range o.URLSourceHeaders {
+			headers[i] = strings.TrimSpace(strings.SplitN(header, ":", 2)[0]) + ": " + redactedValue
+		}
+		o.URLSourceHeaders = headers
+	}
+	return o
+}
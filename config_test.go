@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, config string) string {
+	dir, err := ioutil.TempDir("", "resizr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `
+port: 9001
+gzip: true
+quality:
+  jpeg: 70
+apiKey: file-key
+keys: "limited:10:20"
+adminKey: admin-key
+`)
+
+	o, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Port != 9001 || !o.Gzip || o.Quality.JPEG != 70 {
+		t.Errorf("unexpected options: %+v", o)
+	}
+	if _, ok := o.APIKeys["file-key"]; !ok {
+		t.Errorf("expected the apiKey to be loaded, got %v", o.APIKeys)
+	}
+	if o.APIKeys["limited"] != (KeyConfig{Rate: 10, Burst: 20}) {
+		t.Errorf("expected the keys to be loaded, got %v", o.APIKeys)
+	}
+	if o.AdminKey != "admin-key" {
+		t.Errorf("expected the adminKey to be loaded, got %q", o.AdminKey)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		config string
+	}{
+		{"unknown key", "port: 9001\nunknownKey: true\n"},
+		{"unknown nested key", "quality:\n  gif: 80\n"},
+		{"invalid value", "port: high\n"},
+		{"invalid keys", `keys: "key:fast"`},
+	}
+
+	for _, c := range cases {
+		if _, err := LoadConfig(writeConfig(t, c.config)); err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+	}
+	if _, err := LoadConfig(filepath.Join(os.TempDir(), "missing-resizr-config.yaml")); err == nil {
+		t.Error("expected a missing file error")
+	}
+}
+
+func TestApplyConfigPrecedence(t *testing.T) {
+	flags := flag.NewFlagSet("resizr", flag.ContinueOnError)
+	port := flags.Int("p", 9000, "")
+	address := flags.String("a", "", "")
+	gzip := flags.Bool("gzip", false, "")
+	quality := flags.Int("jpeg-quality", 82, "")
+	var headers repeatedFlag
+	flags.Var(&headers, "url-source-header", "")
+	if err := flags.Parse([]string{"-p", "8080", "-jpeg-quality", "90"}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeConfig(t, `
+port: 9001
+address: 127.0.0.1
+quality:
+  jpeg: 70
+urlSourceHeaders:
+  - "Accept: image/webp, image/*"
+  - "X-Origin: resizr"
+`)
+	if err := applyConfig(flags, path); err != nil {
+		t.Fatal(err)
+	}
+
+	if *port != 8080 || *quality != 90 {
+		t.Errorf("expected the flags to override the file, got port %d and quality %d", *port, *quality)
+	}
+	if *address != "127.0.0.1" {
+		t.Errorf("expected the file to override the default, got address %q", *address)
+	}
+	if *gzip {
+		t.Error("expected the default to be kept")
+	}
+	if len(headers) != 2 || headers[0] != "Accept: image/webp, image/*" {
+		t.Errorf("expected a header flag per value, got %v", headers)
+	}
+}
+
+func TestDumpConfigRedacted(t *testing.T) {
+	o := ServerOptions{
+		Port:             8080,
+		APIKeys:          map[string]KeyConfig{"api-s3cr3t": {}},
+		AdminKey:         "admin-s3cr3t",
+		URLSignatureKey:  "signature-s3cr3t",
+		URLSourceHeaders: []string{"Authorization: Bearer header-s3cr3t", "X-Origin-Token:token-s3cr3t"},
+	}
+	buf, err := yaml.Marshal(redactConfig(o))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dump := string(buf)
+	if strings.Contains(dump, "s3cr3t") {
+		t.Errorf("expected the secrets to be redacted:\n%s", dump)
+	}
+	for _, expected := range []string{"urlSignatureKey: REDACTED", "Authorization: REDACTED", "X-Origin-Token: REDACTED"} {
+		if !strings.Contains(dump, expected) {
+			t.Errorf("expected %q in the dumped config:\n%s", expected, dump)
+		}
+	}
+	if o.URLSignatureKey != "signature-s3cr3t" || o.URLSourceHeaders[0] != "Authorization: Bearer header-s3cr3t" {
+		t.Error("expected the config to be left unchanged")
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const corsMaxAge = 86400
+
+const (
+	corsAllowMethods  = "GET, POST, OPTIONS"
+	corsAllowHeaders  = "Authorization, Content-Type, API-Key, X-API-Key, X-Request-ID"
+	corsExposeHeaders = "Error, ETag, Retry-After, X-Request-ID, X-Bytes-In, X-Bytes-Out, X-Compression-Ratio, " +
+		"X-Dimension-Clamped, X-Fallback-Used, X-Format-Fallback, X-Processing-Skipped, X-Trim-Applied, X-Job-ID, X-Job-Status"
+)
+
+// withCORS wraps the handler adding the CORS headers to the requests from
+// the allowed origins, and replying the preflight requests. Requests from
+// other origins are served with no CORS headers.
+func withCORS(origins []string, next http.Handler) http.Handler {
+	anyOrigin := false
+	allowed := map[string]bool{}
+	for _, origin := range origins {
+		if origin == "*" {
+			anyOrigin = true
+		}
+		allowed[strings.ToLower(origin)] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || (!anyOrigin && !allowed[strings.ToLower(origin)]) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if anyOrigin {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		}
+
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithCORS(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	cases := []struct {
+		name          string
+		origins       []string
+		method        string
+		origin        string
+		preflight     bool
+		code          int
+		allowOrigin   string
+		allowsHeaders bool
+		exposes       bool
+	}{
+		{"no origin", []string{"*"}, "GET", "", false, http.StatusTeapot, "", false, false},
+		{"any origin", []string{"*"}, "GET", "https://app.com", false, http.StatusTeapot, "*", false, true},
+		{"any origin preflight", []string{"*"}, "OPTIONS", "https://app.com", true, http.StatusNoContent, "*", true, false},
+		{"allowed origin", []string{"https://app.com"}, "GET", "https://app.com", false, http.StatusTeapot, "https://app.com", false, true},
+		{"allowed origin case", []string{"https://App.com"}, "GET", "https://app.COM", false, http.StatusTeapot, "https://app.COM", false, true},
+		{"allowed origin preflight", []string{"https://app.com"}, "OPTIONS", "https://app.com", true, http.StatusNoContent, "https://app.com", true, false},
+		{"denied origin", []string{"https://app.com"}, "GET", "https://evil.com", false, http.StatusTeapot, "", false, false},
+		{"denied origin preflight", []string{"https://app.com"}, "OPTIONS", "https://evil.com", true, http.StatusTeapot, "", false, false},
+		{"options without preflight", []string{"*"}, "OPTIONS", "https://app.com", false, http.StatusTeapot, "*", false, true},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(c.method, "/resize/300x/image.jpg", nil)
+		if c.origin != "" {
+			r.Header.Set("Origin", c.origin)
+		}
+		if c.preflight {
+			r.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		w := httptest.NewRecorder()
+		withCORS(c.origins, next).ServeHTTP(w, r)
+
+		if w.Code != c.code {
+			t.Errorf("%s: expected status %d, got %d", c.name, c.code, w.Code)
+		}
+		if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != c.allowOrigin {
+			t.Errorf("%s: expected Access-Control-Allow-Origin %q, got %q", c.name, c.allowOrigin, origin)
+		}
+		if allows := w.Header().Get("Access-Control-Allow-Headers") == corsAllowHeaders &&
+			w.Header().Get("Access-Control-Allow-Methods") == corsAllowMethods &&
+			w.Header().Get("Access-Control-Max-Age") == "86400"; allows != c.allowsHeaders {
+			t.Errorf("%s: expected the preflight headers %t, got %v", c.name, c.allowsHeaders, w.Header())
+		}
+		if exposes := w.Header().Get("Access-Control-Expose-Headers") == corsExposeHeaders; exposes != c.exposes {
+			t.Errorf("%s: expected the exposed headers %t", c.name, c.exposes)
+		}
+		if c.allowOrigin != "" && c.allowOrigin != "*" && w.Header().Get("Vary") != "Origin" {
+			t.Errorf("%s: expected Vary: Origin", c.name)
+		}
+	}
+}
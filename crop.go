@@ -0,0 +1,65 @@
+package main
+
+import "gopkg.in/h2non/bimg.v1"
+
+// cornerGravities are the crop gravities bimg lacks, anchored by cropCorner.
+var cornerGravities = map[string]bool{
+	"northwest": true, "northeast": true, "southwest": true, "southeast": true,
+}
+
+// resizeImage resizes the image with bimg, or with cropCorner for the crops
+// of both dimensions anchored at a corner.
+func resizeImage(image []byte, params bimg.Options, opts Options) ([]byte, error) {
+	if opts.CropCorner == "" || !params.Crop || params.Force || params.Width == 0 || params.Height == 0 {
+		return bimg.Resize(image, params)
+	}
+	return cropCorner(image, params, opts.CropCorner)
+}
+
+// cropCorner scales the image to cover the size, keeping the aspect ratio,
+// and then extracts the area of the size anchored at the corner.
+func cropCorner(image []byte, params bimg.Options, corner string) ([]byte, error) {
+	meta, err := bimg.Metadata(image)
+	if err != nil {
+		return nil, err
+	}
+	width, height := orientedSize(meta, params.NoAutoRotate)
+	if params.Type == bimg.UNKNOWN {
+		params.Type = bimg.DetermineImageType(image)
+	}
+
+	// Fitting the relatively smaller dimension covers the other one
+	cover := params
+	cover.Type, cover.Crop = bimg.PNG, false
+	if width*params.Height > height*params.Width {
+		cover.Width = 0
+	} else {
+		cover.Height = 0
+	}
+	if image, err = bimg.Resize(image, cover); err != nil {
+		return nil, err
+	}
+
+	size, err := bimg.Size(image)
+	if err != nil {
+		return nil, err
+	}
+	areaWidth, areaHeight := clamp(params.Width, 1, size.Width), clamp(params.Height, 1, size.Height)
+	left, top := anchorPosition(corner, size.Width, size.Height, areaWidth, areaHeight)
+	return bimg.Resize(image, bimg.Options{
+		Top:           top,
+		Left:          left,
+		AreaWidth:     areaWidth,
+		AreaHeight:    areaHeight,
+		Type:          params.Type,
+		Quality:       params.Quality,
+		Compression:   params.Compression,
+		Speed:         params.Speed,
+		Interlace:     params.Interlace,
+		NoProfile:     params.NoProfile,
+		StripMetadata: params.StripMetadata,
+		Lossless:      params.Lossless,
+		Background:    params.Background,
+		NoAutoRotate:  true,
+	})
+}
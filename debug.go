@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/h2non/bimg.v1"
+)
+
+var gravityNames = map[bimg.Gravity]string{
+	bimg.GravityCentre: "centre",
+	bimg.GravityNorth:  "north",
+	bimg.GravitySouth:  "south",
+	bimg.GravityEast:   "east",
+	bimg.GravityWest:   "west",
+	bimg.GravitySmart:  "smart",
+}
+
+// DebugParams is the debug param JSON reply, with the operation params
+// as parsed and validated, including the server defaults and the clamps.
+type DebugParams struct {
+	Operation     string      `json:"operation"`
+	Width         int         `json:"width"`
+	Height        int         `json:"height"`
+	Clamped       bool        `json:"clamped"`
+	DPR           float64     `json:"dpr,omitempty"`
+	Type          string      `json:"type"`
+	Quality       int         `json:"quality,omitempty"`
+	Compression   int         `json:"compression,omitempty"`
+	Gravity       string      `json:"gravity,omitempty"`
+	Fit           string      `json:"fit,omitempty"`
+	Force         bool        `json:"force"`
+	Enlarge       bool        `json:"enlarge"`
+	AutoRotate    bool        `json:"autoRotate"`
+	StripMetadata bool        `json:"strip"`
+	Interlace     bool        `json:"interlace"`
+	Background    string      `json:"background,omitempty"`
+	Watermark     bool        `json:"watermark"`
+	Source        DebugSource `json:"source"`
+}
+
+// DebugSource is the resolved source image.
+type DebugSource struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Bytes  int    `json:"bytes"`
+}
+
+// debugParams returns the params the image would be processed with.
+func debugParams(image []byte, source string, opts Options, clamped bool) DebugParams {
+	kind := opts.Type
+	if kind == bimg.UNKNOWN {
+		kind = bimg.DetermineImageType(image)
+	}
+	applyQuality(kind, &opts)
+	opts.Compression = pngLevel(opts.Compression)
+
+	params := DebugParams{
+		Operation:     opts.Operation,
+		Width:         opts.Width,
+		Height:        opts.Height,
+		Clamped:       clamped,
+		DPR:           opts.DPR,
+		Type:          bimg.ImageTypeName(kind),
+		Quality:       opts.Quality,
+		Compression:   opts.Compression,
+		Gravity:       gravityNames[opts.Gravity],
+		Fit:           opts.Fit,
+		Force:         opts.Force,
+		Enlarge:       opts.Enlarge,
+		AutoRotate:    !opts.NoAutoRotate,
+		StripMetadata: opts.StripMetadata,
+		Interlace:     opts.Interlace,
+		Watermark:     opts.Watermark.URL != "",
+		Source: DebugSource{
+			Name:  source,
+			Type:  bimg.ImageTypeName(bimg.DetermineImageType(image)),
+			Bytes: len(image),
+		},
+	}
+	if opts.CropCorner != "" {
+		params.Gravity = opts.CropCorner
+	}
+	if background := opts.Background; background != nil || opts.DefaultBackground != nil {
+		if background == nil {
+			background = opts.DefaultBackground
+		}
+		params.Background = fmt.Sprintf("#%02x%02x%02x%02x", background.R, background.G, background.B, background.A)
+	}
+	if size, err := bimg.Size(image); err == nil {
+		params.Source.Width, params.Source.Height = size.Width, size.Height
+	}
+	return params
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// readDispositionParams reads the download filename, and the disposition,
+// which defaults to attachment when the filename is defined.
+func readDispositionParams(query url.Values, opts *Options) error {
+	opts.Filename = sanitizeFilename(query.Get("filename"))
+	opts.Disposition = query.Get("disposition")
+	switch opts.Disposition {
+	case "":
+		if opts.Filename != "" {
+			opts.Disposition = "attachment"
+		}
+	case "attachment", "inline":
+	default:
+		return NewError("unsupported disposition: must be attachment or inline", http.StatusBadRequest)
+	}
+	return nil
+}
+
+// sanitizeFilename keeps the base name, with no control characters nor quotes,
+// so the filename cannot traverse directories nor break the header.
+func sanitizeFilename(name string) string {
+	name = path.Base(strings.Replace(name, "\\", "/", -1))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "." || name == ".." || name == "/" {
+		return ""
+	}
+	return name
+}
+
+// setDisposition replies the Content-Disposition header, if requested.
+// Non ASCII filenames are encoded as defined by RFC 2231.
+func setDisposition(w http.ResponseWriter, opts Options) {
+	if opts.Disposition == "" {
+		return
+	}
+	params := map[string]string{}
+	if opts.Filename != "" {
+		params["filename"] = opts.Filename
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(opts.Disposition, params))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected string
+	}{
+		{"", ""},
+		{"photo.jpg", "photo.jpg"},
+		{"../../etc/passwd", "passwd"},
+		{"..\\..\\windows\\win.ini", "win.ini"},
+		{"/var/images/", "images"},
+		{"..", ""},
+		{".", ""},
+		{"/", ""},
+		{"  photo.jpg  ", "photo.jpg"},
+		{"pho\"to\".jpg", "photo.jpg"},
+		{"photo.jpg\r\nSet-Cookie: id=1", "photo.jpgSet-Cookie: id=1"},
+		{"fo\x00to\x7f.jpg", "foto.jpg"},
+		{"fräulein.jpg", "fräulein.jpg"},
+	}
+
+	for _, c := range cases {
+		if name := sanitizeFilename(c.name); name != c.expected {
+			t.Errorf("%q: expected %q, got %q", c.name, c.expected, name)
+		}
+	}
+}
+
+func TestSetDisposition(t *testing.T) {
+	cases := []struct {
+		opts     Options
+		expected string
+	}{
+		{Options{}, ""},
+		{Options{Disposition: "attachment", Filename: "photo.jpg"}, "attachment; filename=photo.jpg"},
+		{Options{Disposition: "inline", Filename: "my photo.jpg"}, `inline; filename="my photo.jpg"`},
+		{Options{Disposition: "inline"}, "inline"},
+		{Options{Disposition: "attachment", Filename: "fräulein.jpg"}, "attachment; filename*=utf-8''fr%C3%A4ulein.jpg"},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		setDisposition(w, c.opts)
+		if header := w.Header().Get("Content-Disposition"); header != c.expected {
+			t.Errorf("%+v: expected %q, got %q", c.opts, c.expected, header)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+/*
+#cgo pkg-config: vips
+#include <stdlib.h>
+#include <vips/vips.h>
+
+// exceeds_limits reports whether the loaded image header exceeds the
+// dimension limits. Zero limits are unbounded.
+static int
+exceeds_limits(VipsImage *image, int max_width, int max_height, double max_pixels) {
+	return (max_width > 0 && image->Xsize > max_width) ||
+		(max_height > 0 && image->Ysize > max_height) ||
+		(max_pixels > 0 && (double) image->Xsize * image->Ysize > max_pixels);
+}
+
+// pdf_render_buffer renders a PDF page at the given DPI and saves it as PNG.
+// The page is not rendered, returning 2, when its size exceeds the limits.
+static int
+pdf_render_buffer(void *buf, size_t len, int page, double dpi, int max_width, int max_height, double max_pixels, int *width, int *height, void **out, size_t *out_len) {
+	VipsImage *image;
+	if (vips_pdfload_buffer(buf, len, &image, "page", page, "dpi", dpi, NULL)) {
+		return 1;
+	}
+
+	*width = image->Xsize;
+	*height = image->Ysize;
+	if (exceeds_limits(image, max_width, max_height, max_pixels)) {
+		g_object_unref(image);
+		return 2;
+	}
+
+	int err = vips_image_write_to_buffer(image, ".png", out, out_len, NULL);
+	g_object_unref(image);
+	return err;
+}
+
+// svg_render_buffer rasterizes a SVG image and saves it as PNG. The scale
+// is computed to cover the target size, if any, from the natural size.
+// The image is not rasterized, returning 2, when its scaled size exceeds
+// the limits.
+static int
+svg_render_buffer(void *buf, size_t len, double dpi, int width, int height, double max_scale, int max_width, int max_height, double max_pixels, int *out_width, int *out_height, void **out, size_t *out_len) {
+	VipsImage *image;
+	if (vips_svgload_buffer(buf, len, &image, "dpi", dpi, NULL)) {
+		return 1;
+	}
+
+	double scale = 1.0;
+	if (width > 0 && (double) width / image->Xsize > scale) {
+		scale = (double) width / image->Xsize;
+	}
+	if (height > 0 && (double) height / image->Ysize > scale) {
+		scale = (double) height / image->Ysize;
+	}
+	if (scale > max_scale) {
+		scale = max_scale;
+	}
+
+	if (scale != 1.0) {
+		g_object_unref(image);
+		if (vips_svgload_buffer(buf, len, &image, "dpi", dpi, "scale", scale, NULL)) {
+			return 1;
+		}
+	}
+
+	*out_width = image->Xsize;
+	*out_height = image->Ysize;
+	if (exceeds_limits(image, max_width, max_height, max_pixels)) {
+		g_object_unref(image);
+		return 2;
+	}
+
+	int err = vips_image_write_to_buffer(image, ".png", out, out_len, NULL);
+	g_object_unref(image);
+	return err;
+}
+*/
+import "C"
+
+import (
+	"bytes"
+	"errors"
+	"gopkg.in/h2non/bimg.v1"
+	"net/http"
+	"net/url"
+	"unsafe"
+)
+
+// defaultDPI is the libvips default PDF and SVG rendering resolution.
+const defaultDPI = 72
+
+// maxSVGScale bounds the SVG rasterization scale, on top of the image dimension limits.
+const maxSVGScale = 32
+
+func isPDF(buf []byte) bool {
+	return bytes.HasPrefix(buf, []byte("%PDF"))
+}
+
+// isDocument reports whether the image is a PDF or SVG rendered before processing.
+func isDocument(buf []byte) bool {
+	return isPDF(buf) || bimg.IsSVGImage(buf)
+}
+
+// readDocumentParams reads the PDF page and rendering resolution.
+func readDocumentParams(query url.Values, opts *Options) error {
+	var err error
+	if opts.Page, err = parseIntParam(query, "page", 0, 100000); err != nil {
+		return err
+	}
+	if opts.DPI, err = parseFloatParam(query, "dpi", 1, 600); err != nil {
+		return err
+	}
+	return nil
+}
+
+// renderDocument rasterizes PDF and SVG input as PNG, so the operations
+// process it as any other image. Other images are returned as is. The
+// rendered size is read from the document header and checked against the
+// image limits before rasterizing.
+func renderDocument(image []byte, opts Options) ([]byte, error) {
+	dpi := opts.DPI
+	if dpi == 0 {
+		dpi = defaultDPI
+	}
+
+	switch {
+	case isPDF(image):
+		if !bimg.IsTypeSupported(bimg.PDF) {
+			return nil, NewError("PDF documents require libvips compiled with poppler support", http.StatusUnsupportedMediaType)
+		}
+		return renderPDF(image, opts.Page, dpi, opts.Limits)
+	case bimg.IsSVGImage(image):
+		if !bimg.IsTypeSupported(bimg.SVG) {
+			return nil, NewError("SVG images require libvips compiled with librsvg support", http.StatusUnsupportedMediaType)
+		}
+		if err := checkSVG(image); err != nil {
+			return nil, err
+		}
+		// An explicit resolution disables the rasterization at the output size
+		width, height := opts.Width, opts.Height
+		if opts.DPI > 0 {
+			width, height = 0, 0
+		}
+		return renderSVG(image, dpi, width, height, opts.Limits)
+	}
+	return image, nil
+}
+
+func renderPDF(image []byte, page int, dpi float64, limits ImageLimits) ([]byte, error) {
+	if len(image) == 0 {
+		return nil, errors.New("empty image")
+	}
+
+	var out unsafe.Pointer
+	var length C.size_t
+	var width, height C.int
+	switch C.pdf_render_buffer(unsafe.Pointer(&image[0]), C.size_t(len(image)), C.int(page), C.double(dpi),
+		C.int(limits.Width), C.int(limits.Height), C.double(limits.Pixels*1e6), &width, &height, &out, &length) {
+	case 0:
+	case 2:
+		return nil, limits.Check(int(width), int(height))
+	default:
+		return nil, vipsErrorf("cannot render PDF page %d", page)
+	}
+	defer C.g_free(C.gpointer(out))
+	return C.GoBytes(out, C.int(length)), nil
+}
+
+func renderSVG(image []byte, dpi float64, width, height int, limits ImageLimits) ([]byte, error) {
+	var out unsafe.Pointer
+	var length C.size_t
+	var outWidth, outHeight C.int
+	switch C.svg_render_buffer(unsafe.Pointer(&image[0]), C.size_t(len(image)), C.double(dpi), C.int(width), C.int(height), maxSVGScale,
+		C.int(limits.Width), C.int(limits.Height), C.double(limits.Pixels*1e6), &outWidth, &outHeight, &out, &length) {
+	case 0:
+	case 2:
+		return nil, limits.Check(int(outWidth), int(outHeight))
+	default:
+		return nil, vipsErrorf("cannot render SVG image")
+	}
+	defer C.g_free(C.gpointer(out))
+	return C.GoBytes(out, C.int(length)), nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/h2non/bimg.v1"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"net/url"
+	"strings"
+)
+
+// sampleSize is the width of the downsampled image the color is computed from.
+const sampleSize = 32
+
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// ColorPlaceholder is the placeholder operation JSON reply.
+type ColorPlaceholder struct {
+	Hex      string `json:"hex"`
+	RGB      []int  `json:"rgb"`
+	BlurHash string `json:"blurhash,omitempty"`
+}
+
+// readPlaceholderParams reads the placeholder operation params. The output
+// image type is defined by the format param, otherwise the color is replied as JSON.
+func readPlaceholderParams(query url.Values, opts *Options) error {
+	if opts.Operation != "placeholder" {
+		return nil
+	}
+
+	var err error
+	if name := query.Get("format"); name != "" {
+		if opts.Type, err = parseImageType(name); err != nil {
+			return err
+		}
+	}
+	opts.BlurHash, err = parseBoolParam(query, "blurhash", false)
+	return err
+}
+
+// sampleImage downsamples the image, so the colors are computed from a few pixels only.
+func sampleImage(buf []byte, noAutoRotate bool) (image.Image, error) {
+	buf, err := bimg.Resize(buf, bimg.Options{Width: sampleSize, Type: bimg.PNG, NoAutoRotate: noAutoRotate})
+	if err != nil {
+		return nil, err
+	}
+	return png.Decode(bytes.NewReader(buf))
+}
+
+// averageColor returns the average color of the image, weighted by alpha.
+func averageColor(img image.Image) color.RGBA {
+	var r, g, b, total float64
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			pr, pg, pb, pa := img.At(x, y).RGBA()
+			if pa == 0 {
+				continue
+			}
+			weight := float64(pa) / 0xffff
+			// Undo the alpha premultiplication
+			r += float64(pr) / float64(pa) * 255 * weight
+			g += float64(pg) / float64(pa) * 255 * weight
+			b += float64(pb) / float64(pa) * 255 * weight
+			total += weight
+		}
+	}
+	if total == 0 {
+		return color.RGBA{255, 255, 255, 255}
+	}
+	return color.RGBA{uint8(math.Round(r / total)), uint8(math.Round(g / total)), uint8(math.Round(b / total)), 255}
+}
+
+// colorPlaceholder returns the average color, and optionally the BlurHash, of the image.
+func colorPlaceholder(buf []byte, opts Options) (ColorPlaceholder, error) {
+	img, err := sampleImage(buf, opts.NoAutoRotate)
+	if err != nil {
+		return ColorPlaceholder{}, err
+	}
+
+	c := averageColor(img)
+	reply := ColorPlaceholder{
+		Hex: fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B),
+		RGB: []int{int(c.R), int(c.G), int(c.B)},
+	}
+	if opts.BlurHash {
+		reply.BlurHash = blurHash(img, 4, 3)
+	}
+	return reply, nil
+}
+
+// solidPlaceholder returns an image filled with the average color of the
+// source image, with the requested size or a single pixel.
+func solidPlaceholder(buf []byte, params bimg.Options) ([]byte, error) {
+	img, err := sampleImage(buf, params.NoAutoRotate)
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := params.Width, params.Height
+	if width == 0 {
+		width = 1
+	}
+	if height == 0 {
+		height = width
+	}
+
+	solid := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	solid.Set(0, 0, averageColor(img))
+	var out bytes.Buffer
+	if err := png.Encode(&out, solid); err != nil {
+		return nil, err
+	}
+
+	return bimg.Resize(out.Bytes(), bimg.Options{
+		Width:   width,
+		Height:  height,
+		Force:   true,
+		Enlarge: true,
+		Type:    params.Type,
+		Quality: params.Quality,
+	})
+}
+
+// blurHash encodes the image with the BlurHash algorithm, using the given
+// number of horizontal and vertical components.
+// See: https://github.com/woltapp/blurhash/blob/master/Algorithm.md
+func blurHash(img image.Image, xComponents, yComponents int) string {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+
+			var factor [3]float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := normalisation * math.Cos(math.Pi*float64(i*x)/float64(width)) * math.Cos(math.Pi*float64(j*y)/float64(height))
+					c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+					factor[0] += basis * srgbToLinear(c.R)
+					factor[1] += basis * srgbToLinear(c.G)
+					factor[2] += basis * srgbToLinear(c.B)
+				}
+			}
+			scale := 1 / float64(width*height)
+			factors = append(factors, [3]float64{factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encode83((xComponents-1)+(yComponents-1)*9, 1))
+
+	maxValue := 1.0
+	if len(factors) > 1 {
+		actualMax := 0.0
+		for _, factor := range factors[1:] {
+			for _, value := range factor {
+				actualMax = math.Max(actualMax, math.Abs(value))
+			}
+		}
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantisedMax+1) / 166
+		hash.WriteString(encode83(quantisedMax, 1))
+	} else {
+		hash.WriteString(encode83(0, 1))
+	}
+
+	dc := factors[0]
+	hash.WriteString(encode83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+	for _, factor := range factors[1:] {
+		quant := func(value float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(value/maxValue, 0.5)*9+9.5))))
+		}
+		hash.WriteString(encode83(quant(factor[0])*19*19+quant(factor[1])*19+quant(factor[2]), 2))
+	}
+	return hash.String()
+}
+
+func encode83(value, length int) string {
+	buf := make([]byte, length)
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83
+		buf[i-1] = base83[digit]
+	}
+	return string(buf)
+}
+
+func srgbToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
@@ -0,0 +1,157 @@
+package main
+
+/*
+#cgo pkg-config: vips
+#include <stdlib.h>
+#include <vips/vips.h>
+
+// embed_buffer places the image in a larger canvas filled with the
+// background, and saves it as PNG.
+static int
+embed_buffer(void *buf, size_t len, int left, int top, int width, int height, double *rgba, int alpha, void **out, size_t *out_len) {
+	VipsImage *in = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (in == NULL) {
+		return 1;
+	}
+
+	// Intermediate images are released with the input image
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(in), 2);
+	VipsImage *image = in;
+	if (alpha && !vips_image_hasalpha(image)) {
+		if (vips_addalpha(image, &t[0], NULL)) {
+			g_object_unref(in);
+			return 1;
+		}
+		image = t[0];
+	}
+
+	// The background requires a value per band
+	double values[4];
+	int bands = image->Bands;
+	if (bands > 4) {
+		bands = 4;
+	}
+	if (bands < 3) {
+		values[0] = rgba[0];
+		values[1] = rgba[3];
+	} else {
+		for (int i = 0; i < bands; i++) {
+			values[i] = rgba[i];
+		}
+	}
+
+	VipsArrayDouble *background = vips_array_double_new(values, bands);
+	int err = vips_embed(image, &t[1], left, top, width, height,
+		"extend", VIPS_EXTEND_BACKGROUND, "background", background, NULL);
+	vips_area_unref(VIPS_AREA(background));
+	if (err == 0) {
+		err = vips_image_write_to_buffer(t[1], ".png", out, out_len, NULL);
+	}
+
+	g_object_unref(in);
+	return err;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/h2non/bimg.v1"
+	"net/http"
+	"net/url"
+	"strings"
+	"unsafe"
+)
+
+// readEmbedParams reads the embed operation gravity and background.
+// Both width and height are required to define the canvas.
+func readEmbedParams(query url.Values, opts *Options) error {
+	if opts.Operation != "embed" {
+		return nil
+	}
+	if opts.Width == 0 || opts.Height == 0 {
+		return NewError("embed operation requires both width and height", http.StatusBadRequest)
+	}
+
+	opts.EmbedGravity = "centre"
+	if name := query.Get("gravity"); name != "" {
+		if !watermarkGravities[name] {
+			return NewError(fmt.Sprintf("unsupported embed gravity: %s", name), http.StatusBadRequest)
+		}
+		opts.EmbedGravity = name
+	}
+	return readBackground(query, opts)
+}
+
+// embed scales the image to fit the options size, keeping the aspect ratio,
+// and pads the remainder with the background color: transparent for output
+// types with alpha, white otherwise.
+func embed(image []byte, params bimg.Options, opts Options) ([]byte, error) {
+	final := params
+	if final.Type == bimg.UNKNOWN {
+		final.Type = bimg.DetermineImageType(image)
+	}
+
+	background := white
+	if hasAlpha(final.Type) && !opts.Flatten {
+		background = transparent
+	}
+	background = opts.fill(background)
+
+	params.Type = bimg.PNG
+	params.Crop, params.Force = false, false
+	image, err := bimg.Resize(image, params)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := bimg.Size(image)
+	if err != nil {
+		return nil, err
+	}
+	left, top := anchorPosition(opts.EmbedGravity, opts.Width, opts.Height, size.Width, size.Height)
+	image, err = embedImage(image, left, top, opts.Width, opts.Height, background, hasAlpha(final.Type))
+	if err != nil {
+		return nil, err
+	}
+	return encode(image, final)
+}
+
+// anchorPosition returns the coordinates of the inner size anchored by gravity.
+func anchorPosition(gravity string, width, height, innerWidth, innerHeight int) (int, int) {
+	left, top := (width-innerWidth)/2, (height-innerHeight)/2
+	if strings.HasSuffix(gravity, "west") {
+		left = 0
+	}
+	if strings.HasSuffix(gravity, "east") {
+		left = width - innerWidth
+	}
+	if strings.HasPrefix(gravity, "north") {
+		top = 0
+	}
+	if strings.HasPrefix(gravity, "south") {
+		top = height - innerHeight
+	}
+	return left, top
+}
+
+func embedImage(image []byte, left, top, width, height int, background Color, alpha bool) ([]byte, error) {
+	if len(image) == 0 {
+		return nil, errors.New("empty image")
+	}
+
+	rgba := []C.double{C.double(background.R), C.double(background.G), C.double(background.B), C.double(background.A)}
+	withAlpha := C.int(0)
+	if alpha && background.A < 255 {
+		withAlpha = 1
+	}
+
+	var out unsafe.Pointer
+	var length C.size_t
+	if C.embed_buffer(unsafe.Pointer(&image[0]), C.size_t(len(image)), C.int(left), C.int(top), C.int(width), C.int(height), &rgba[0], withAlpha, &out, &length) != 0 {
+		return nil, vipsErrorf("cannot embed image")
+	}
+	defer C.g_free(C.gpointer(out))
+	return C.GoBytes(out, C.int(length)), nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// defaultRetryAfter is the Retry-After seconds of the 429 and 503 replies
+// with no delay of their own, such as the processing queue rejections.
+const defaultRetryAfter = 1
+
+// Error represents a failure that must be replied with a specific HTTP status.
+type Error struct {
+	Message string
+	Code    int
+}
+
+func (e Error) Error() string {
+	return e.Message
+}
+
+func NewError(msg string, code int) Error {
+	return Error{msg, code}
+}
+
+// errorCode returns the HTTP status code associated to the given error.
+func errorCode(err error) int {
+	if e, ok := err.(Error); ok {
+		return e.Code
+	}
+	return http.StatusBadRequest
+}
+
+// errorNames are the machine readable codes of the replied error statuses.
+var errorNames = map[int]string{
+	http.StatusBadRequest:            "invalid_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusNotAcceptable:         "not_acceptable",
+	http.StatusGone:                  "gone",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusTooManyRequests:       "rate_limited",
+	statusClientClosed:               "client_closed",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusBadGateway:            "bad_gateway",
+	http.StatusServiceUnavailable:    "unavailable",
+	http.StatusGatewayTimeout:        "timeout",
+}
+
+func errorName(code int) string {
+	if name, ok := errorNames[code]; ok {
+		return name
+	}
+	if code >= 500 {
+		return "internal_error"
+	}
+	return "invalid_request"
+}
+
+// ErrorResponse is the JSON envelope of the error replies.
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// writeError replies the error as a JSON envelope. The message is also
+// replied in the Error header, as the image placeholder replies do.
+func writeError(w http.ResponseWriter, err error) {
+	err = publicError(w, err)
+	code := errorCode(err)
+	body, _ := json.Marshal(ErrorResponse{ErrorBody{
+		Code:      errorName(code),
+		Message:   err.Error(),
+		RequestID: w.Header().Get(requestIDHeader),
+	}})
+
+	w.Header().Del("Last-Modified")
+	w.Header().Del("Content-Disposition")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Error", err.Error())
+	setRetryAfter(w, code)
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// setRetryAfter sets the default Retry-After of the throttled and
+// unavailable replies, unless the handler defined a delay already.
+func setRetryAfter(w http.ResponseWriter, code int) {
+	if code != http.StatusTooManyRequests && code != http.StatusServiceUnavailable {
+		return
+	}
+	if w.Header().Get("Retry-After") == "" {
+		w.Header().Set("Retry-After", strconv.Itoa(defaultRetryAfter))
+	}
+}
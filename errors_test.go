@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteError(t *testing.T) {
+	cases := []struct {
+		name       string
+		err        error
+		retryAfter string
+		code       int
+		errorCode  string
+		message    string
+		expected   string
+	}{
+		{
+			"invalid params",
+			NewError("invalid width", http.StatusBadRequest),
+			"",
+			http.StatusBadRequest,
+			"invalid_request",
+			"invalid width",
+			"",
+		},
+		{
+			"source error",
+			NewError("Error downloading image: too many redirects: the maximum is 5", http.StatusBadGateway),
+			"",
+			http.StatusBadGateway,
+			"bad_gateway",
+			"Error downloading image: too many redirects: the maximum is 5",
+			"",
+		},
+		{
+			"rate limited",
+			NewError("rate limit exceeded", http.StatusTooManyRequests),
+			"30",
+			http.StatusTooManyRequests,
+			"rate_limited",
+			"rate limit exceeded",
+			"30",
+		},
+		{
+			"queue timeout",
+			NewError("timeout waiting for a processing slot", http.StatusServiceUnavailable),
+			"",
+			http.StatusServiceUnavailable,
+			"unavailable",
+			"timeout waiting for a processing slot",
+			"1",
+		},
+		{
+			"libvips error",
+			vipsError{"VipsJpeg: Premature end of JPEG file"},
+			"",
+			http.StatusUnsupportedMediaType,
+			"unsupported_media_type",
+			"unsupported or corrupt image data",
+			"",
+		},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		w.Header().Set(requestIDHeader, "8f14e45fceea167a5a36dedd4bea2543")
+		if c.retryAfter != "" {
+			w.Header().Set("Retry-After", c.retryAfter)
+		}
+		writeError(w, c.err)
+
+		if w.Code != c.code {
+			t.Errorf("%s: expected status %d, got %d", c.name, c.code, w.Code)
+		}
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("%s: unexpected content type %s", c.name, contentType)
+		}
+		if header := w.Header().Get("Error"); header != c.message {
+			t.Errorf("%s: unexpected Error header %q", c.name, header)
+		}
+		if retryAfter := w.Header().Get("Retry-After"); retryAfter != c.expected {
+			t.Errorf("%s: expected Retry-After %q, got %q", c.name, c.expected, retryAfter)
+		}
+
+		var reply ErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil {
+			t.Fatalf("%s: invalid envelope: %s", c.name, err)
+		}
+		expected := ErrorBody{c.errorCode, c.message, "8f14e45fceea167a5a36dedd4bea2543"}
+		if reply.Error != expected {
+			t.Errorf("%s: expected %+v, got %+v", c.name, expected, reply.Error)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// imageETag returns the strong ETag of a processed image, derived from the
+// cache key, so identical requests of the same source always match.
+func imageETag(key string) string {
+	return `"` + key + `"`
+}
+
+// notModified reports whether the client already has the image, based on the
+// If-None-Match header, or If-Modified-Since when the source modification
+// time is known. If-None-Match takes precedence, as defined by RFC 7232.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		return etagMatch(match, etag)
+	}
+	if modified.IsZero() || (r.Method != "GET" && r.Method != "HEAD") {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !modified.Truncate(time.Second).After(since)
+}
+
+// etagMatch uses the weak comparison, since a weak validator from a
+// downstream cache still identifies the same image.
+func etagMatch(header, etag string) bool {
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimPrefix(strings.TrimSpace(value), "W/")
+		if value == "*" || value == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeNotModified replies 304 with the validators and no body.
+func writeNotModified(w http.ResponseWriter, etag string, modified time.Time) {
+	w.Header().Set("ETag", etag)
+	setLastModified(w, modified)
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// setLastModified replies the source modification time, when known, so
+// downstream caches can revalidate the image.
+func setLastModified(w http.ResponseWriter, modified time.Time) {
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// sourceModified returns the modification time replied by the image source.
+func sourceModified(w http.ResponseWriter) time.Time {
+	modified, _ := http.ParseTime(w.Header().Get("Last-Modified"))
+	return modified
+}
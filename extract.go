@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/h2non/bimg.v1"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Coordinate is an extract region coordinate, in pixels or as
+// a percentage of the image dimension.
+type Coordinate struct {
+	Value   float64
+	Percent bool
+	Defined bool
+}
+
+// resolve returns the coordinate in pixels for the given image dimension.
+func (c Coordinate) resolve(size int) int {
+	if c.Percent {
+		return int(math.Round(c.Value * float64(size) / 100))
+	}
+	return int(c.Value)
+}
+
+// Region is the extract operation area.
+type Region struct {
+	Top, Left, Width, Height Coordinate
+}
+
+// readExtractParams reads the extract operation region params.
+func readExtractParams(query url.Values, opts *Options) error {
+	if opts.Operation != "extract" {
+		return nil
+	}
+
+	params := []struct {
+		name  string
+		coord *Coordinate
+	}{
+		{"top", &opts.Region.Top},
+		{"left", &opts.Region.Left},
+		{"areawidth", &opts.Region.Width},
+		{"areaheight", &opts.Region.Height},
+	}
+	for _, param := range params {
+		coord, err := parseCoordinate(query, param.name)
+		if err != nil {
+			return err
+		}
+		*param.coord = coord
+	}
+	return nil
+}
+
+func parseCoordinate(query url.Values, name string) (Coordinate, error) {
+	value := query.Get(name)
+	if value == "" {
+		return Coordinate{}, nil
+	}
+
+	coord := Coordinate{Percent: strings.HasSuffix(value, "%"), Defined: true}
+	num, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil || math.IsNaN(num) || math.IsInf(num, 0) || num < 0 || (coord.Percent && num > 100) || (!coord.Percent && num != math.Trunc(num)) {
+		return Coordinate{}, NewError(fmt.Sprintf("invalid %s param: must be a positive number of pixels or a percentage", name), http.StatusBadRequest)
+	}
+	coord.Value = num
+	return coord, nil
+}
+
+// extract crops the region of the image, clamped to the image bounds, and then
+// resizes it if a size is requested. Undefined area dimensions extend to the
+// image edges.
+func extract(image []byte, params bimg.Options, region Region) ([]byte, error) {
+	meta, err := bimg.Metadata(image)
+	if err != nil {
+		return nil, err
+	}
+	kind := bimg.DetermineImageType(image)
+
+	top, left, areaWidth, areaHeight, err := extractArea(meta, params.NoAutoRotate, region)
+	if err != nil {
+		return nil, err
+	}
+
+	area := params
+	area.Width, area.Height, area.Crop = 0, 0, false
+	area.Top, area.Left, area.AreaWidth, area.AreaHeight = top, left, areaWidth, areaHeight
+	if params.Width == 0 && params.Height == 0 {
+		return bimg.Resize(image, area)
+	}
+
+	// Extract losslessly first, since bimg extracts after resizing
+	area.Type = bimg.PNG
+	image, err = bimg.Resize(image, area)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Type == bimg.UNKNOWN {
+		params.Type = kind
+	}
+	params.NoAutoRotate = true
+	params.Crop = false
+	return bimg.Resize(image, params)
+}
+
+// extractArea resolves the region to the image bounds. The region refers
+// to the auto rotated image.
+func extractArea(meta bimg.ImageMetadata, noAutoRotate bool, region Region) (top, left, width, height int, err error) {
+	imageWidth, imageHeight := orientedSize(meta, noAutoRotate)
+
+	left = clamp(region.Left.resolve(imageWidth), 0, imageWidth)
+	top = clamp(region.Top.resolve(imageHeight), 0, imageHeight)
+	width, height = imageWidth-left, imageHeight-top
+	if region.Width.Defined {
+		width = clamp(region.Width.resolve(imageWidth), 0, imageWidth-left)
+	}
+	if region.Height.Defined {
+		height = clamp(region.Height.resolve(imageHeight), 0, imageHeight-top)
+	}
+	if width == 0 || height == 0 {
+		return 0, 0, 0, 0, NewError("extract region has zero area", http.StatusBadRequest)
+	}
+	return top, left, width, height, nil
+}
+
+// orientedSize returns the image size once auto rotated.
+func orientedSize(meta bimg.ImageMetadata, noAutoRotate bool) (int, int) {
+	if !noAutoRotate && meta.Orientation >= 5 && meta.Orientation <= 8 {
+		return meta.Size.Height, meta.Size.Width
+	}
+	return meta.Size.Width, meta.Size.Height
+}
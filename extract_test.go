@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestParseCoordinate(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected Coordinate
+		code     int
+	}{
+		{"", Coordinate{}, 0},
+		{"120", Coordinate{Value: 120, Defined: true}, 0},
+		{"25%", Coordinate{Value: 25, Percent: true, Defined: true}, 0},
+		{"12.5%", Coordinate{Value: 12.5, Percent: true, Defined: true}, 0},
+		{"12.5", Coordinate{}, http.StatusBadRequest},
+		{"-10", Coordinate{}, http.StatusBadRequest},
+		{"101%", Coordinate{}, http.StatusBadRequest},
+		{"NaN%", Coordinate{}, http.StatusBadRequest},
+		{"Inf", Coordinate{}, http.StatusBadRequest},
+		{"left", Coordinate{}, http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		coord, err := parseCoordinate(url.Values{"left": {c.value}}, "left")
+		if c.code != 0 {
+			if err == nil || errorCode(err) != c.code {
+				t.Errorf("%q: expected status %d, got %v", c.value, c.code, err)
+			}
+			continue
+		}
+		if err != nil || coord != c.expected {
+			t.Errorf("%q: expected %+v, got %+v (%v)", c.value, c.expected, coord, err)
+		}
+	}
+}
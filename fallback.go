@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/h2non/bimg.v1"
+	"net/http"
+	"strings"
+)
+
+const fallbackHeader = "X-Format-Fallback"
+
+// parseFormatFallback parses the output type fallbacks defined as
+// "from=to" pairs, rejecting unknown types and fallback cycles.
+func parseFormatFallback(pairs []string) (map[bimg.ImageType]bimg.ImageType, error) {
+	fallbacks := map[bimg.ImageType]bimg.ImageType{}
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid format fallback: %s", pair)
+		}
+		from, ok := lookupImageType(parts[0])
+		if !ok {
+			return nil, fmt.Errorf("invalid format fallback: unknown type %s", parts[0])
+		}
+		to, ok := lookupImageType(parts[1])
+		if !ok {
+			return nil, fmt.Errorf("invalid format fallback: unknown type %s", parts[1])
+		}
+		fallbacks[from] = to
+	}
+
+	for from := range fallbacks {
+		seen := map[bimg.ImageType]bool{from: true}
+		for next, ok := fallbacks[from]; ok; next, ok = fallbacks[next] {
+			if seen[next] {
+				return nil, fmt.Errorf("invalid format fallback: cycle from %s", bimg.ImageTypeName(from))
+			}
+			seen[next] = true
+		}
+	}
+	return fallbacks, nil
+}
+
+// resolveOutputType degrades the requested output type to the configured
+// fallback when libvips has no encoder for it, returning the followed
+// chain, such as "avif->webp", to be reported to the client.
+func resolveOutputType(o ServerOptions, opts *Options) (string, error) {
+	if opts.Type == bimg.UNKNOWN || bimg.IsTypeSupportedSave(opts.Type) {
+		return "", nil
+	}
+
+	// Fallbacks are validated on startup
+	fallbacks, _ := parseFormatFallback(o.FormatFallback)
+	chain := []string{bimg.ImageTypeName(opts.Type)}
+	code := opts.Type
+	for !bimg.IsTypeSupportedSave(code) {
+		next, ok := fallbacks[code]
+		if !ok {
+			return "", NewError(fmt.Sprintf("%s encoder is not available in libvips", chain[0]), http.StatusUnsupportedMediaType)
+		}
+		code = next
+		chain = append(chain, bimg.ImageTypeName(code))
+	}
+
+	opts.Type = code
+	return strings.Join(chain, "->"), nil
+}
+
+func lookupImageType(name string) (bimg.ImageType, bool) {
+	for code, typeName := range bimg.ImageTypes {
+		if typeName == name {
+			return code, true
+		}
+	}
+	return bimg.UNKNOWN, false
+}
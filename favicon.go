@@ -0,0 +1,17 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+)
+
+// faviconData is a 16x16 icon, so browsers do not log a 404 on every visit.
+const faviconData = `AAABAAEAEBAAAAEAIABZAAAAFgAAAIlQTkcNChoKAAAADUlIRFIAAAAQAAAAEAgGAAAAH/P/YQAAACBJREFUeNpj0NZ1+k8JZhiGBnxdpowXjxowMgwYgXkBAGOe4hDIDLryAAAAAElFTkSuQmCC`
+
+var favicon, _ = base64.StdEncoding.DecodeString(faviconData)
+
+func faviconController(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "image/x-icon")
+	w.Header().Set("Cache-Control", "public, max-age=604800")
+	w.Write(favicon)
+}
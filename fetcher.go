@@ -1,41 +1,238 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 )
 
-func Fetch(imageUrl string) ([]byte, error) {
+// retryBackoff is the wait before the first fetch retry, doubled on each retry.
+const retryBackoff = 100 * time.Millisecond
+
+// FetchOptions defines the remote image fetch limits.
+type FetchOptions struct {
+	Timeout      time.Duration
+	Retries      int
+	MaxRedirects int
+	MaxBytes     int64
+	AllowTypes   []string
+	UserAgent    string
+	Headers      http.Header
+}
+
+// Fetcher downloads remote images, retrying on transient failures.
+type Fetcher struct {
+	client     *http.Client
+	retries    int
+	maxBytes   int64
+	allowTypes []string
+	userAgent  string
+	headers    http.Header
+}
+
+func NewFetcher(policy *HostPolicy, o FetchOptions) *Fetcher {
+	client := &http.Client{
+		Timeout: o.Timeout,
+		Transport: &http.Transport{
+			DialContext:         policy.DialContext,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > o.MaxRedirects {
+				return redirectError{o.MaxRedirects}
+			}
+			// The -url-source-header headers are only sent to the requested host
+			if !strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+				for name := range o.Headers {
+					req.Header.Del(name)
+				}
+			}
+			return nil
+		},
+	}
+	return &Fetcher{
+		client:     client,
+		retries:    o.Retries,
+		maxBytes:   o.MaxBytes,
+		allowTypes: o.AllowTypes,
+		userAgent:  o.UserAgent,
+		headers:    o.Headers,
+	}
+}
+
+type redirectError struct {
+	max int
+}
+
+func (e redirectError) Error() string {
+	return fmt.Sprintf("too many redirects: the maximum is %d", e.max)
+}
+
+// transientError is a fetch failure worth to retry.
+type transientError struct {
+	error
+}
+
+// upstreamError is a failure of the origin, such as an error status or a
+// connection error, replied with 502 Bad Gateway.
+type upstreamError struct {
+	message string
+}
+
+func (e upstreamError) Error() string {
+	return e.message
+}
+
+func (f *Fetcher) Fetch(ctx context.Context, imageUrl string) ([]byte, error) {
+	buf, _, err := f.FetchModified(ctx, imageUrl)
+	return buf, err
+}
+
+// FetchModified downloads the image and returns its upstream Last-Modified
+// time, which is zero when the origin does not reply a valid one. The
+// download and the retry waits end when the context is done.
+func (f *Fetcher) FetchModified(ctx context.Context, imageUrl string) ([]byte, time.Time, error) {
 	url, err := url.Parse(imageUrl)
 	if err != nil {
-		return nil, fmt.Errorf("Invalid image URL: (url=%s)", url.RequestURI())
+		return nil, time.Time{}, NewError(fmt.Sprintf("Invalid image URL: (url=%s)", imageUrl), http.StatusBadRequest)
+	}
+
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		buf, modified, err := f.fetchImage(ctx, url)
+		var transient transientError
+		if !errors.As(err, &transient) {
+			return buf, modified, replyError(err)
+		}
+		if attempt == f.retries {
+			return nil, time.Time{}, replyError(transient.error)
+		}
+
+		debug("retrying image download in %s: %s", backoff, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, time.Time{}, fetchError(ctx.Err())
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// replyError maps the origin failures to 502 Bad Gateway.
+func replyError(err error) error {
+	var upstream upstreamError
+	if errors.As(err, &upstream) {
+		return NewError(upstream.message, http.StatusBadGateway)
 	}
-	return fetchImage(url)
+	return err
 }
 
-func fetchImage(url *url.URL) ([]byte, error) {
-	req := createRequest(url)
-	res, err := http.DefaultClient.Do(req)
+func (f *Fetcher) fetchImage(ctx context.Context, url *url.URL) ([]byte, time.Time, error) {
+	req := f.createRequest(ctx, url)
+	res, err := f.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("Error downloading image: %v", err)
+		return nil, time.Time{}, fetchError(err)
 	}
 	defer res.Body.Close()
+	if res.StatusCode >= 500 {
+		return nil, time.Time{}, transientError{upstreamError{fmt.Sprintf("Error downloading image: (status=%d) (url=%s)", res.StatusCode, req.URL.RequestURI())}}
+	}
 	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("Error downloading image: (status=%d) (url=%s)", res.StatusCode, req.URL.RequestURI())
+		return nil, time.Time{}, upstreamError{fmt.Sprintf("Error downloading image: (status=%d) (url=%s)", res.StatusCode, req.URL.RequestURI())}
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type")); err == nil && !f.allowsType(mediaType) {
+		return nil, time.Time{}, NewError(fmt.Sprintf("URL source replied %s content instead of an image (url=%s)", mediaType, req.URL.RequestURI()), http.StatusUnsupportedMediaType)
+	}
+
+	// Abort oversized downloads before reading the body, and the responses
+	// lying about their length while reading it
+	tooLarge := NewError(fmt.Sprintf("image exceeds the maximum download size of %d bytes", f.maxBytes), http.StatusRequestEntityTooLarge)
+	body := io.Reader(res.Body)
+	if f.maxBytes > 0 {
+		if res.ContentLength > f.maxBytes {
+			return nil, time.Time{}, tooLarge
+		}
+		body = io.LimitReader(res.Body, f.maxBytes+1)
 	}
 
-	buf, err := ioutil.ReadAll(res.Body)
+	buf, err := ioutil.ReadAll(body)
+	if f.maxBytes > 0 && int64(len(buf)) > f.maxBytes {
+		return nil, time.Time{}, tooLarge
+	}
 	if err != nil {
-		return nil, fmt.Errorf("Unable to create image from response body: %s (url=%s)", err, req.URL.RequestURI())
+		if isTimeout(err) {
+			return nil, time.Time{}, NewError("Timeout downloading image", http.StatusGatewayTimeout)
+		}
+		return nil, time.Time{}, fmt.Errorf("Unable to create image from response body: %s (url=%s)", err, req.URL.RequestURI())
 	}
-	return buf, nil
+	modified, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+	return buf, modified, nil
 }
 
-func createRequest(url *url.URL) *http.Request {
-	req, _ := http.NewRequest("GET", url.RequestURI(), nil)
-	req.Header.Set("User-Agent", "resizr "+Version)
+// allowsType reports whether the media type is an image, a PDF document, or
+// one of the -url-source-allow-types, which accept type/* wildcards.
+// Responses with no content type are checked by their magic bytes.
+func (f *Fetcher) allowsType(mediaType string) bool {
+	if strings.HasPrefix(mediaType, "image/") || mediaType == "application/pdf" {
+		return true
+	}
+	for _, allowed := range f.allowTypes {
+		if allowed == mediaType || allowed == "*" || (strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchError maps the request errors to the reply status codes.
+// Connection errors are transient, while timeouts are not retried, so
+// slow origins do not hold the request for several timeouts. Canceled
+// requests are not retried either.
+func fetchError(err error) error {
+	if errors.Is(err, context.Canceled) {
+		return NewError("Image download canceled", statusClientClosed)
+	}
+	var denied hostDeniedError
+	if errors.As(err, &denied) {
+		return NewError(denied.Error(), http.StatusForbidden)
+	}
+	var redirect redirectError
+	if errors.As(err, &redirect) {
+		return NewError("Error downloading image: "+redirect.Error(), http.StatusBadGateway)
+	}
+	if isTimeout(err) {
+		return NewError("Timeout downloading image", http.StatusGatewayTimeout)
+	}
+	return transientError{upstreamError{fmt.Sprintf("Error downloading image: %v", err)}}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// createRequest returns the upstream request, with the -url-source-header
+// headers and the -url-source-user-agent. The headers are dropped on the
+// redirects to other hosts.
+func (f *Fetcher) createRequest(ctx context.Context, url *url.URL) *http.Request {
+	req, _ := http.NewRequestWithContext(ctx, "GET", url.RequestURI(), nil)
+	for name, values := range f.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("User-Agent", f.userAgent)
 	req.URL = url
 	return req
 }
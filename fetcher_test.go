@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestFetcher(t *testing.T, o FetchOptions) *Fetcher {
+	policy, err := NewHostPolicy([]string{"127.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewFetcher(policy, o)
+}
+
+func TestFetcherRetries(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("image"))
+	}))
+	defer server.Close()
+
+	fetcher := newTestFetcher(t, FetchOptions{Timeout: time.Second, Retries: 1, MaxRedirects: 5})
+	buf, err := fetcher.Fetch(context.Background(), server.URL)
+	if err != nil || string(buf) != "image" {
+		t.Fatalf("expected the retry to succeed, got %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 requests, got %d", requests)
+	}
+}
+
+func TestFetcherUpstreamErrors(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/failing", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cases := []struct {
+		path string
+		code int
+	}{
+		{"/missing", http.StatusBadGateway},
+		{"/failing", http.StatusBadGateway},
+		{"/loop", http.StatusBadGateway},
+		{"/slow", http.StatusGatewayTimeout},
+	}
+
+	fetcher := newTestFetcher(t, FetchOptions{Timeout: 50 * time.Millisecond, Retries: 1, MaxRedirects: 3})
+	for _, c := range cases {
+		_, err := fetcher.Fetch(context.Background(), server.URL+c.path)
+		if err == nil || errorCode(err) != c.code {
+			t.Errorf("%s: expected status %d, got %v", c.path, c.code, err)
+		}
+	}
+}
+
+func TestFetcherRetryCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	fetcher := newTestFetcher(t, FetchOptions{Timeout: time.Second, Retries: 10, MaxRedirects: 5})
+	start := time.Now()
+	_, err := fetcher.Fetch(ctx, server.URL)
+	if errorCode(err) != statusClientClosed {
+		t.Errorf("expected a client closed error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the retry wait to end on cancellation, took %s", elapsed)
+	}
+}
+
+func TestFetcherMaxBytes(t *testing.T) {
+	image := strings.Repeat("x", 100)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/small", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(image[:50]))
+	})
+	mux.HandleFunc("/limit", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(image))
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "101")
+		w.Write([]byte(image + "x"))
+	})
+	mux.HandleFunc("/chunked", func(w http.ResponseWriter, r *http.Request) {
+		// Flushed without Content-Length, so the size is known while reading
+		for i := 0; i < 3; i++ {
+			w.Write([]byte(image))
+			w.(http.Flusher).Flush()
+		}
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		mux.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		path string
+		size int
+		code int
+	}{
+		{"/small", 50, 0},
+		{"/limit", 100, 0},
+		{"/large", 0, http.StatusRequestEntityTooLarge},
+		{"/chunked", 0, http.StatusRequestEntityTooLarge},
+	}
+
+	fetcher := newTestFetcher(t, FetchOptions{Timeout: time.Second, MaxRedirects: 5, MaxBytes: 100})
+	for _, c := range cases {
+		buf, err := fetcher.Fetch(context.Background(), server.URL+c.path)
+		if c.code != 0 {
+			if err == nil || errorCode(err) != c.code {
+				t.Errorf("%s: expected status %d, got %v", c.path, c.code, err)
+			}
+			continue
+		}
+		if err != nil || len(buf) != c.size {
+			t.Errorf("%s: expected %d bytes, got %d (%v)", c.path, c.size, len(buf), err)
+		}
+	}
+}
+
+func TestFetcherInvalidURL(t *testing.T) {
+	fetcher := newTestFetcher(t, FetchOptions{Timeout: time.Second, MaxRedirects: 5})
+	for _, imageURL := range []string{"%zz", "http://[::1", "http://server.com/%zz"} {
+		_, err := fetcher.Fetch(context.Background(), imageURL)
+		if err == nil || errorCode(err) != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %v", imageURL, http.StatusBadRequest, err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"gopkg.in/h2non/bimg.v1"
+	"math"
+	"net/http"
+	"net/url"
+)
+
+// libvips sharpen defaults for the flat/jaggy threshold and the flat and
+// jaggy areas slopes
+const (
+	sharpenX1 = 2
+	sharpenM1 = 0
+	sharpenM2 = 3
+	sharpenY2 = 10
+	sharpenY3 = 20
+)
+
+// readFilterParams reads the blur and sharpen operation params.
+// Sigma is bounded to prevent absurdly expensive convolutions.
+func readFilterParams(query url.Values, opts *Options) error {
+	var err error
+
+	switch opts.Operation {
+	case "blur":
+		if opts.Blur.Sigma, err = parseFloatParam(query, "sigma", 0.1, 50); err != nil {
+			return err
+		}
+		if opts.Blur.Sigma == 0 {
+			return NewError("blur operation requires the sigma param", http.StatusBadRequest)
+		}
+		if opts.Blur.MinAmpl, err = parseFloatParam(query, "minampl", 0, 1); err != nil {
+			return err
+		}
+	case "sharpen":
+		sigma, err := parseFloatParam(query, "sigma", 0.5, 10)
+		if err != nil {
+			return err
+		}
+		if sigma == 0 {
+			return NewError("sharpen operation requires the sigma param", http.StatusBadRequest)
+		}
+		opts.Sharpen = bimg.Sharpen{
+			Radius: sharpenRadius(sigma),
+			X1:     sharpenX1,
+			M1:     sharpenM1,
+			M2:     sharpenM2,
+			Y2:     sharpenY2,
+			Y3:     sharpenY3,
+		}
+		// Zero is a valid value, so only the defined params override the defaults
+		for name, target := range map[string]*float64{"x1": &opts.Sharpen.X1, "m1": &opts.Sharpen.M1, "m2": &opts.Sharpen.M2} {
+			if query.Get(name) == "" {
+				continue
+			}
+			if *target, err = parseFloatParam(query, name, 0, 100); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sharpenRadius maps sigma to the legacy libvips sharpen radius used by bimg,
+// which libvips converts back as sigma = 1 + radius / 2.
+func sharpenRadius(sigma float64) int {
+	return int(math.Max(1, math.Round(2*(sigma-1))))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// fitModes are the fit param modes, named after the common image CDN semantics.
+var fitModes = map[string]bool{
+	"cover": true, "contain": true, "fill": true, "inside": true, "outside": true,
+}
+
+// fitOperations are the operations whose geometry is defined by the fit mode.
+var fitOperations = map[string]bool{
+	"resize": true, "crop": true, "blur": true, "sharpen": true, "watermark": true,
+}
+
+// readFitParams reads the fit mode.
+func readFitParams(query url.Values, opts *Options) error {
+	name := query.Get("fit")
+	if name == "" {
+		return nil
+	}
+	if !fitModes[name] {
+		return NewError(fmt.Sprintf("unsupported fit mode: %s", name), http.StatusBadRequest)
+	}
+	if fitOperations[opts.Operation] {
+		opts.Fit = name
+	}
+	return nil
+}
+
+// contains reports whether the image is letterboxed by the embed operation,
+// which requires both dimensions.
+func (o Options) contains() bool {
+	return o.Fit == "contain" && o.Width > 0 && o.Height > 0
+}
+
+// fitGeometry returns the bimg size, crop and force options of the fit mode,
+// always enlarging the image. cover crops to fill the size, fill stretches
+// the image to it, and inside fits the image within it. outside scales the
+// image to cover the size with no crop, so only the dimension with the lowest
+// factor is defined. contain fits the image, padded by the embed operation.
+func fitGeometry(fit string, inWidth, inHeight, width, height int, crop, force bool) (int, int, bool, bool) {
+	switch fit {
+	case "cover":
+		return width, height, true, false
+	case "fill":
+		return width, height, false, true
+	case "inside", "contain":
+		return width, height, false, false
+	case "outside":
+		if width > 0 && height > 0 {
+			if float64(inWidth)/float64(width) <= float64(inHeight)/float64(height) {
+				height = 0
+			} else {
+				width = 0
+			}
+		}
+		return width, height, false, false
+	}
+	return width, height, crop, force
+}
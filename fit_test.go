@@ -0,0 +1,103 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+import (
+	"bytes"
+	"gopkg.in/h2non/bimg.v1"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// solidFixture returns a red PNG image of the size.
+func solidFixture(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, fixtureRed)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFitModes(t *testing.T) {
+	landscape, portrait := solidFixture(t, 400, 200), solidFixture(t, 200, 400)
+
+	cases := []struct {
+		name          string
+		image         []byte
+		fit           string
+		width, height int
+		outWidth      int
+		outHeight     int
+		padded        bool
+	}{
+		{"landscape", landscape, "cover", 100, 100, 100, 100, false},
+		{"landscape", landscape, "contain", 100, 100, 100, 100, true},
+		{"landscape", landscape, "fill", 100, 100, 100, 100, false},
+		{"landscape", landscape, "inside", 100, 100, 100, 50, false},
+		{"landscape", landscape, "outside", 100, 100, 200, 100, false},
+		{"portrait", portrait, "cover", 100, 100, 100, 100, false},
+		{"portrait", portrait, "contain", 100, 100, 100, 100, true},
+		{"portrait", portrait, "fill", 100, 100, 100, 100, false},
+		{"portrait", portrait, "inside", 100, 100, 50, 100, false},
+		{"portrait", portrait, "outside", 100, 100, 100, 200, false},
+
+		// Every mode enlarges
+		{"landscape enlarged", landscape, "cover", 600, 600, 600, 600, false},
+		{"landscape enlarged", landscape, "contain", 600, 600, 600, 600, true},
+		{"landscape enlarged", landscape, "fill", 600, 600, 600, 600, false},
+		{"landscape enlarged", landscape, "inside", 600, 600, 600, 300, false},
+		{"landscape enlarged", landscape, "outside", 600, 600, 1200, 600, false},
+		{"portrait enlarged", portrait, "cover", 600, 600, 600, 600, false},
+		{"portrait enlarged", portrait, "contain", 600, 600, 600, 600, true},
+		{"portrait enlarged", portrait, "fill", 600, 600, 600, 600, false},
+		{"portrait enlarged", portrait, "inside", 600, 600, 300, 600, false},
+		{"portrait enlarged", portrait, "outside", 600, 600, 600, 1200, false},
+
+		// A single dimension keeps the aspect ratio
+		{"landscape width", landscape, "contain", 100, 0, 100, 50, false},
+		{"landscape width", landscape, "outside", 100, 0, 100, 50, false},
+		{"portrait height", portrait, "contain", 0, 100, 50, 100, false},
+		{"portrait height", portrait, "outside", 0, 100, 50, 100, false},
+	}
+
+	for _, c := range cases {
+		opts := NewOptions(testServerOptions(), "resize")
+		opts.Width, opts.Height, opts.Fit, opts.Type = c.width, c.height, c.fit, bimg.JPEG
+
+		plan, err := planImage(c.image, opts)
+		if err != nil {
+			t.Fatalf("%s %s: unexpected plan error: %s", c.name, c.fit, err)
+		}
+		if plan.Width != c.outWidth || plan.Height != c.outHeight {
+			t.Errorf("%s %s: expected the plan %dx%d, got %dx%d", c.name, c.fit, c.outWidth, c.outHeight, plan.Width, plan.Height)
+		}
+
+		buf, err := Resize(c.image, opts)
+		if err != nil {
+			t.Fatalf("%s %s: unexpected error: %s", c.name, c.fit, err)
+		}
+		img := decodeImage(t, buf)
+		size := img.Bounds().Size()
+		if size.X != c.outWidth || size.Y != c.outHeight {
+			t.Errorf("%s %s: expected %dx%d, got %dx%d", c.name, c.fit, c.outWidth, c.outHeight, size.X, size.Y)
+			continue
+		}
+
+		// The red image is centred, letterboxed with the white background
+		if !isRed(img.At(size.X/2, size.Y/2)) {
+			t.Errorf("%s %s: expected the image centred", c.name, c.fit)
+		}
+		corner := img.At(2, 2)
+		if isRed(corner) == c.padded {
+			t.Errorf("%s %s: expected padded %t, got the corner color %v", c.name, c.fit, c.padded, corner)
+		}
+	}
+}
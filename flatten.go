@@ -0,0 +1,76 @@
+package main
+
+/*
+#cgo pkg-config: vips
+#include <stdlib.h>
+#include <vips/vips.h>
+
+// flatten_black_buffer removes the alpha channel onto the libvips default
+// black background, and saves the image as PNG.
+static int
+flatten_black_buffer(void *buf, size_t len, void **out, size_t *out_len) {
+	VipsImage *in = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (in == NULL) {
+		return 1;
+	}
+
+	VipsImage *flat;
+	int err = vips_flatten(in, &flat, NULL);
+	if (err == 0) {
+		err = vips_image_write_to_buffer(flat, ".png", out, out_len, NULL);
+		g_object_unref(flat);
+	}
+
+	g_object_unref(in);
+	return err;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"gopkg.in/h2non/bimg.v1"
+	"net/url"
+	"unsafe"
+)
+
+// readFlattenParams reads the flatten param and its background color.
+func readFlattenParams(query url.Values, opts *Options) error {
+	var err error
+	if opts.Flatten, err = parseBoolParam(query, "flatten", false); err != nil {
+		return err
+	}
+	return readBackground(query, opts)
+}
+
+// flattenBackground returns the bimg background removing the alpha channel,
+// applied when requested or when the output type has no alpha. The default
+// background is white, unless the server defines another.
+func flattenBackground(kind bimg.ImageType, opts Options) bimg.Color {
+	if !opts.Flatten && hasAlpha(kind) {
+		return bimg.Color{}
+	}
+	background := opts.fill(white)
+	return bimg.Color{R: background.R, G: background.G, B: background.B}
+}
+
+// flattensBlack reports whether the image must be flattened onto black,
+// which bimg cannot do since it treats black as no background. Output
+// types without alpha are already flattened onto black by libvips.
+func flattensBlack(kind bimg.ImageType, opts Options) bool {
+	return opts.Flatten && hasAlpha(kind) && flattenBackground(kind, opts) == bimg.ColorBlack
+}
+
+func flattenBlack(image []byte) ([]byte, error) {
+	if len(image) == 0 {
+		return nil, errors.New("empty image")
+	}
+
+	var out unsafe.Pointer
+	var length C.size_t
+	if C.flatten_black_buffer(unsafe.Pointer(&image[0]), C.size_t(len(image)), &out, &length) != 0 {
+		return nil, vipsErrorf("cannot flatten image")
+	}
+	defer C.g_free(C.gpointer(out))
+	return C.GoBytes(out, C.int(length)), nil
+}
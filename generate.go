@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/h2non/bimg.v1"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/url"
+)
+
+// GenerateOptions defines the solid color or linear gradient of the
+// generate operation.
+type GenerateOptions struct {
+	From, To Color
+	Vertical bool
+}
+
+// readGenerateParams reads the generate operation color, or the gradient
+// from and to colors and direction. Colors default to the fill color.
+func readGenerateParams(query url.Values, opts *Options) error {
+	if opts.Operation != "generate" {
+		return nil
+	}
+	if opts.Width <= 0 || opts.Height <= 0 {
+		return NewError("generate operation requires both width and height", http.StatusBadRequest)
+	}
+
+	fill := opts.fill(white)
+	opts.Generate = GenerateOptions{From: fill, To: fill}
+	if value := query.Get("color"); value != "" {
+		parsed, err := parseColor(value)
+		if err != nil {
+			return err
+		}
+		opts.Generate.From, opts.Generate.To = parsed, parsed
+	}
+	for name, target := range map[string]*Color{"from": &opts.Generate.From, "to": &opts.Generate.To} {
+		if value := query.Get(name); value != "" {
+			parsed, err := parseColor(value)
+			if err != nil {
+				return err
+			}
+			*target = parsed
+		}
+	}
+
+	switch query.Get("direction") {
+	case "", "horizontal":
+	case "vertical":
+		opts.Generate.Vertical = true
+	default:
+		return NewError("unsupported gradient direction: must be horizontal or vertical", http.StatusBadRequest)
+	}
+	return nil
+}
+
+// defaultGenerateMegapixels caps the generated images when there is no
+// -max-image-megapixels limit, since no source image bounds their size.
+const defaultGenerateMegapixels = 25
+
+// generateImage returns a PNG image of the options size, filled with the
+// color or gradient, to be encoded as any source image. The size is checked
+// against the source image limits before allocating it.
+func generateImage(opts Options, o ServerOptions) ([]byte, error) {
+	if opts.Width <= 0 || opts.Height <= 0 {
+		return nil, NewError("generate operation requires both width and height", http.StatusBadRequest)
+	}
+	if opts.Width > bimg.MaxSize() || opts.Height > bimg.MaxSize() {
+		return nil, NewError(fmt.Sprintf("generated image exceeds the maximum of %d pixels", bimg.MaxSize()), http.StatusBadRequest)
+	}
+	limits := imageLimits(o)
+	if limits.Pixels == 0 {
+		limits.Pixels = defaultGenerateMegapixels
+	}
+	if err := limits.Check(opts.Width, opts.Height); err != nil {
+		return nil, err
+	}
+
+	// Only a line of the gradient is drawn, and then stretched to the size
+	g := opts.Generate
+	length := 1
+	if g.From != g.To {
+		length = opts.Width
+		if g.Vertical {
+			length = opts.Height
+		}
+	}
+	line := image.NewNRGBA(image.Rect(0, 0, length, 1))
+	if g.Vertical {
+		line = image.NewNRGBA(image.Rect(0, 0, 1, length))
+	}
+	for i := 0; i < length; i++ {
+		c := interpolateColor(g.From, g.To, i, length)
+		if g.Vertical {
+			line.SetNRGBA(0, i, c)
+		} else {
+			line.SetNRGBA(i, 0, c)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := png.Encode(&out, line); err != nil {
+		return nil, err
+	}
+	return bimg.Resize(out.Bytes(), bimg.Options{
+		Width:       opts.Width,
+		Height:      opts.Height,
+		Force:       true,
+		Enlarge:     true,
+		Type:        bimg.PNG,
+		Compression: 1,
+	})
+}
+
+func interpolateColor(from, to Color, i, length int) color.NRGBA {
+	if length < 2 {
+		return color.NRGBA{from.R, from.G, from.B, from.A}
+	}
+	t := float64(i) / float64(length-1)
+	mix := func(a, b uint8) uint8 {
+		return uint8(float64(a) + (float64(b)-float64(a))*t + 0.5)
+	}
+	return color.NRGBA{mix(from.R, to.R), mix(from.G, to.G), mix(from.B, to.B), mix(from.A, to.A)}
+}
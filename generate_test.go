@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestGenerateSize(t *testing.T) {
+	cases := []struct {
+		width, height int
+		code          int
+	}{
+		{0, 100, http.StatusBadRequest},
+		{100, 0, http.StatusBadRequest},
+		{-100000000, 1, http.StatusBadRequest},
+		{1, -1000000000, http.StatusBadRequest},
+		{-100000, -100000, http.StatusBadRequest},
+		{100000000, 1, http.StatusBadRequest},
+		{10000, 10000, http.StatusRequestEntityTooLarge},
+	}
+
+	query := url.Values{"from": {"ff0000"}, "to": {"0000ff"}}
+	for _, c := range cases {
+		opts := NewOptions(ServerOptions{}, "generate")
+		opts.Width, opts.Height = c.width, c.height
+		if c.width <= 0 || c.height <= 0 {
+			if err := readGenerateParams(query, &opts); err == nil || errorCode(err) != c.code {
+				t.Errorf("%dx%d: expected the params to fail with status %d, got %v", c.width, c.height, c.code, err)
+			}
+		}
+		if _, err := generateImage(opts, ServerOptions{}); err == nil || errorCode(err) != c.code {
+			t.Errorf("%dx%d: expected status %d, got %v", c.width, c.height, c.code, err)
+		}
+	}
+}
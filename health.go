@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"gopkg.in/h2non/bimg.v1"
+	"net/http"
+	"time"
+)
+
+var startTime = time.Now()
+
+type HealthStatus struct {
+	Status  string  `json:"status"`
+	Uptime  float64 `json:"uptime"`
+	Version string  `json:"version"`
+	Error   string  `json:"error,omitempty"`
+}
+
+func healthController(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, newHealthStatus("ok"))
+}
+
+func readinessController(w http.ResponseWriter, r *http.Request) {
+	status := newHealthStatus("ok")
+	code := http.StatusOK
+
+	if err := vipsCheck(); err != nil {
+		status.Status = "unavailable"
+		status.Error = err.Error()
+		code = http.StatusServiceUnavailable
+	} else if inMaintenance() {
+		status.Status = "maintenance"
+		code = http.StatusServiceUnavailable
+	}
+
+	writeHealth(w, code, status)
+}
+
+func newHealthStatus(status string) HealthStatus {
+	return HealthStatus{
+		Status:  status,
+		Uptime:  time.Since(startTime).Seconds(),
+		Version: Version,
+	}
+}
+
+// vipsCheck is replaced by the tests simulating a broken libvips runtime.
+var vipsCheck = checkVips
+
+// checkVips runs a trivial libvips operation to detect a broken runtime.
+func checkVips() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New("libvips is not available")
+		}
+	}()
+	_, err = bimg.Size(placeholder)
+	return err
+}
+
+func writeHealth(w http.ResponseWriter, code int, status HealthStatus) {
+	body, _ := json.Marshal(status)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(code)
+	w.Write(body)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthController(t *testing.T) {
+	w := httptest.NewRecorder()
+	healthController(w, httptest.NewRequest("GET", "/health", nil))
+
+	var status HealthStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || status.Status != "ok" || status.Version != Version {
+		t.Errorf("unexpected health status %d: %+v", w.Code, status)
+	}
+}
+
+func TestReadinessController(t *testing.T) {
+	defer func(check func() error) { vipsCheck = check }(vipsCheck)
+	defer setMaintenance(inMaintenance())
+
+	cases := []struct {
+		name        string
+		maintenance bool
+		vips        error
+		code        int
+		status      string
+	}{
+		{"ready", false, nil, http.StatusOK, "ok"},
+		{"maintenance", true, nil, http.StatusServiceUnavailable, "maintenance"},
+		{"libvips failure", false, errors.New("libvips is not available"), http.StatusServiceUnavailable, "unavailable"},
+		{"libvips failure in maintenance", true, errors.New("libvips is not available"), http.StatusServiceUnavailable, "unavailable"},
+	}
+
+	for _, c := range cases {
+		setMaintenance(c.maintenance)
+		vipsCheck = func() error { return c.vips }
+
+		w := httptest.NewRecorder()
+		readinessController(w, httptest.NewRequest("GET", "/health/ready", nil))
+
+		var status HealthStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if w.Code != c.code || status.Status != c.status {
+			t.Errorf("%s: expected %d %s, got %d %s", c.name, c.code, c.status, w.Code, status.Status)
+		}
+		if c.vips != nil && status.Error != c.vips.Error() {
+			t.Errorf("%s: expected the error %q, got %q", c.name, c.vips, status.Error)
+		}
+	}
+}
+
+func TestCheckVips(t *testing.T) {
+	if err := checkVips(); err != nil {
+		t.Errorf("expected libvips to be available: %s", err)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// privateNetworks lists the address ranges blocked by default to prevent
+// server-side request forgery to internal services.
+var privateNetworks = parseNetworks([]string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::1/128",
+	"::/128",
+	"fc00::/7",
+	"fe80::/10",
+})
+
+// HostPolicy restricts the remote hosts the URL source is allowed to fetch.
+// Hosts can be defined by name, wildcard sub-domain (*.example.com) or CIDR.
+type HostPolicy struct {
+	hosts    []string
+	networks []*net.IPNet
+	dialer   *net.Dialer
+}
+
+func NewHostPolicy(allowed []string) (*HostPolicy, error) {
+	policy := &HostPolicy{dialer: &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}}
+	for _, host := range allowed {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host == "" {
+			continue
+		}
+		if strings.Contains(host, "/") {
+			_, network, err := net.ParseCIDR(host)
+			if err != nil {
+				return nil, fmt.Errorf("invalid allowed host CIDR: %s", host)
+			}
+			policy.networks = append(policy.networks, network)
+			continue
+		}
+		policy.hosts = append(policy.hosts, host)
+	}
+	return policy, nil
+}
+
+// DialContext resolves the remote host and dials the first address allowed
+// by the policy. Since the checked address is the one dialed, DNS rebinding
+// cannot bypass the policy.
+func (p *HostPolicy) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	named := p.matchHost(host)
+	for _, addr := range addrs {
+		if p.allowIP(addr.IP, named) {
+			return p.dialer.DialContext(ctx, network, net.JoinHostPort(addr.IP.String(), port))
+		}
+	}
+	return nil, hostDeniedError{host}
+}
+
+func (p *HostPolicy) matchHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, allowed := range p.hosts {
+		if host == allowed || (strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowIP reports whether the resolved address can be dialed.
+// Private ranges are denied unless explicitly allowed by CIDR.
+func (p *HostPolicy) allowIP(ip net.IP, named bool) bool {
+	for _, network := range p.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	if isPrivateIP(ip) {
+		return false
+	}
+	return named || (len(p.hosts) == 0 && len(p.networks) == 0)
+}
+
+type hostDeniedError struct {
+	host string
+}
+
+func (e hostDeniedError) Error() string {
+	return fmt.Sprintf("remote host is not allowed: %s", e.host)
+}
+
+func isPrivateIP(ip net.IP) bool {
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseNetworks(cidrs []string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, networks[i], _ = net.ParseCIDR(cidr)
+	}
+	return networks
+}
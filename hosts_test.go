@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestHostPolicyAllowIP(t *testing.T) {
+	cases := []struct {
+		allowed  []string
+		host     string
+		ip       string
+		expected bool
+	}{
+		{nil, "example.com", "93.184.216.34", true},
+		{nil, "localhost", "127.0.0.1", false},
+		{nil, "internal", "10.1.2.3", false},
+		{nil, "metadata", "169.254.169.254", false},
+		{nil, "localhost", "::1", false},
+		{nil, "internal", "fd00::1", false},
+		{[]string{"example.com"}, "example.com", "93.184.216.34", true},
+		{[]string{"example.com"}, "EXAMPLE.com", "93.184.216.34", true},
+		{[]string{"example.com"}, "other.com", "93.184.216.34", false},
+		{[]string{"example.com"}, "example.com", "10.1.2.3", false},
+		{[]string{"*.example.com"}, "cdn.example.com", "93.184.216.34", true},
+		{[]string{"*.example.com"}, "example.com", "93.184.216.34", false},
+		{[]string{"*.example.com"}, "badexample.com", "93.184.216.34", false},
+		{[]string{"10.0.0.0/8"}, "internal", "10.1.2.3", true},
+		{[]string{"10.0.0.0/8"}, "internal", "192.168.1.1", false},
+		{[]string{"10.0.0.0/8"}, "example.com", "93.184.216.34", false},
+	}
+
+	for _, c := range cases {
+		policy, err := NewHostPolicy(c.allowed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed := policy.allowIP(net.ParseIP(c.ip), policy.matchHost(c.host)); allowed != c.expected {
+			t.Errorf("%v: expected %s (%s) allowed %t, got %t", c.allowed, c.host, c.ip, c.expected, allowed)
+		}
+	}
+}
+
+func TestNewHostPolicyInvalidCIDR(t *testing.T) {
+	if _, err := NewHostPolicy([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("expected an invalid CIDR error")
+	}
+}
+
+func TestHostPolicyDialDenied(t *testing.T) {
+	policy, _ := NewHostPolicy(nil)
+	_, err := policy.DialContext(context.Background(), "tcp", "127.0.0.1:80")
+	if _, ok := err.(hostDeniedError); !ok {
+		t.Errorf("expected a host denied error, got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/h2non/bimg.v1"
+	"net/http"
+)
+
+type ImageInfo struct {
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Type        string `json:"type"`
+	Space       string `json:"space"`
+	Channels    int    `json:"channels"`
+	HasAlpha    bool   `json:"hasAlpha"`
+	HasProfile  bool   `json:"hasProfile"`
+	Orientation int    `json:"orientation"`
+}
+
+// infoController replies with the source image metadata, read from
+// the image header without any pixel transformation.
+func infoController(o ServerOptions, sources []ImageSource) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		source := matchSource(sources, r)
+		image, err := source.GetImage(w, r, ps)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		metrics.AddBytesIn(len(image))
+
+		if err := validateImage(image, o); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		meta, err := bimg.Metadata(image)
+		if err != nil {
+			writeError(w, NewError("cannot read image metadata: "+err.Error(), http.StatusUnsupportedMediaType))
+			return
+		}
+
+		body, _ := json.Marshal(ImageInfo{
+			Width:       meta.Size.Width,
+			Height:      meta.Size.Height,
+			Type:        meta.Type,
+			Space:       meta.Space,
+			Channels:    meta.Channels,
+			HasAlpha:    meta.Alpha,
+			HasProfile:  meta.Profile,
+			Orientation: meta.Orientation,
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/h2non/bimg.v1"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxStoredJobs bounds the jobs kept in memory until their TTL expires
+	maxStoredJobs = 10000
+	// callbackRetries is the number of callback deliveries retried on failure
+	callbackRetries = 3
+)
+
+// JobRequest is the body of an async job: the pipeline operations applied
+// to the source image, and the URL the result is posted to.
+type JobRequest struct {
+	CallbackURL string          `json:"callback_url"`
+	Operations  []PipelineStage `json:"operations"`
+}
+
+// Job is the status of an async job, replied by GET /jobs/{id}
+// and posted to the callback URL on failure.
+type Job struct {
+	ID       string    `json:"id"`
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Type     string    `json:"type,omitempty"`
+	Size     int       `json:"size,omitempty"`
+	Callback string    `json:"callback,omitempty"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+
+	callbackURL string
+	request     *http.Request
+	image       []byte
+	stages      []PipelineStage
+}
+
+// JobRunner processes the async jobs in a bounded pool of workers, and keeps
+// their status in memory for the job TTL once finished.
+type JobRunner struct {
+	o          ServerOptions
+	watermarks *WatermarkStore
+	queue      *OpQueue
+	client     *http.Client
+	pending    chan *Job
+	ttl        time.Duration
+
+	mutex sync.Mutex
+	jobs  map[string]*Job
+}
+
+// NewJobRunner returns nil when -max-concurrent-jobs is zero, which disables the jobs.
+func NewJobRunner(o ServerOptions, watermarks *WatermarkStore, queue *OpQueue) (*JobRunner, error) {
+	if o.MaxConcurrentJobs <= 0 {
+		return nil, nil
+	}
+
+	// Callbacks are restricted by the URL source host policy
+	policy, err := NewHostPolicy(o.URLAllowHosts)
+	if err != nil {
+		return nil, err
+	}
+	runner := &JobRunner{
+		o:          o,
+		watermarks: watermarks,
+		queue:      queue,
+		client: &http.Client{
+			Timeout:   time.Duration(o.URLSourceTimeout) * time.Second,
+			Transport: &http.Transport{DialContext: policy.DialContext},
+		},
+		pending: make(chan *Job, o.MaxQueuedJobs),
+		ttl:     time.Duration(o.JobTTL) * time.Second,
+		jobs:    map[string]*Job{},
+	}
+	for i := 0; i < o.MaxConcurrentJobs; i++ {
+		go runner.work()
+	}
+	go runner.cleanup()
+	return runner, nil
+}
+
+// Submit queues the job, replying 503 when the queue or the store is full.
+func (j *JobRunner) Submit(job *Job) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if len(j.jobs) >= maxStoredJobs {
+		return NewError("too many jobs", http.StatusServiceUnavailable)
+	}
+
+	select {
+	case j.pending <- job:
+		j.jobs[job.ID] = job
+		return nil
+	default:
+		return NewError("job queue is full", http.StatusServiceUnavailable)
+	}
+}
+
+// Get returns a copy of the job status.
+func (j *JobRunner) Get(id string) (Job, bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	job, ok := j.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+func (j *JobRunner) update(job *Job, fn func(job *Job)) Job {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	fn(job)
+	job.Updated = time.Now()
+	return *job
+}
+
+func (j *JobRunner) work() {
+	for job := range j.pending {
+		j.update(job, func(job *Job) { job.Status = "running" })
+		image, err := j.process(job)
+
+		status := j.update(job, func(job *Job) {
+			job.Status = "done"
+			job.image, job.stages = nil, nil
+			if err != nil {
+				job.Status, job.Error = "failed", err.Error()
+				return
+			}
+			job.Type, job.Size = bimg.ImageTypeName(bimg.DetermineImageType(image)), len(image)
+		})
+
+		delivery := "delivered"
+		if err := j.deliver(status, image); err != nil {
+			debug("job %s callback error: %s", job.ID, err)
+			delivery = "failed: " + err.Error()
+		}
+		j.update(job, func(job *Job) { job.Callback = delivery })
+	}
+}
+
+// process applies the job operations as a pipeline, bounded by the
+// -processing-timeout and sharing the -max-concurrent-ops slots.
+func (j *JobRunner) process(job *Job) ([]byte, error) {
+	processing, cancel, err := newProcessing(job.request, j.o)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	release, err := j.queue.Acquire(processing.ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer processing.Release(release)
+
+	image := job.image
+	for i, stage := range job.stages {
+		if image, _, err = runStage(processing, j.o, job.request, j.watermarks, image, stage); err != nil {
+			return nil, fmt.Errorf("pipeline stage %d (%s) failed: %s", i, stage.Operation, translateError(job.ID, err))
+		}
+	}
+	return image, nil
+}
+
+// deliver posts the result image to the callback URL, or the job status
+// when failed, retrying on connection and server errors.
+func (j *JobRunner) deliver(job Job, image []byte) error {
+	body, contentType := image, GetImageMimeType(bimg.DetermineImageType(image))
+	if job.Status != "done" {
+		body, _ = json.Marshal(job)
+		contentType = "application/json"
+	}
+
+	backoff := retryBackoff
+	var err error
+	for attempt := 0; attempt <= callbackRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		req, _ := http.NewRequest("POST", job.callbackURL, bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("User-Agent", "resizr "+Version)
+		req.Header.Set("X-Job-ID", job.ID)
+		req.Header.Set("X-Job-Status", job.Status)
+		res, reqErr := j.client.Do(req)
+		if reqErr != nil {
+			err = reqErr
+			continue
+		}
+		res.Body.Close()
+		if res.StatusCode < 300 {
+			return nil
+		}
+		err = fmt.Errorf("callback replied status %d", res.StatusCode)
+		if res.StatusCode < 500 {
+			return err
+		}
+	}
+	return err
+}
+
+func (j *JobRunner) cleanup() {
+	ticker := time.NewTicker(time.Minute)
+	for now := range ticker.C {
+		j.evict(now)
+	}
+}
+
+// evict drops the finished jobs older than the TTL.
+func (j *JobRunner) evict(now time.Time) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	for id, job := range j.jobs {
+		if job.Callback != "" && now.Sub(job.Updated) > j.ttl {
+			delete(j.jobs, id)
+		}
+	}
+}
+
+// jobsController submits an async job processing the source image defined
+// in the query string, which is fetched before replying 202 with the job ID.
+func jobsController(o ServerOptions, sources []ImageSource, runner *JobRunner) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		var spec JobRequest
+		body := http.MaxBytesReader(w, r.Body, maxPipelineBodySize(o))
+		if err := json.NewDecoder(body).Decode(&spec); err != nil {
+			writeError(w, jsonBodyError("job", err))
+			return
+		}
+		if len(spec.Operations) == 0 {
+			badRequest(w, "job requires at least one operation")
+			return
+		}
+		if len(spec.Operations) > o.MaxPipelineOps {
+			badRequest(w, fmt.Sprintf("job exceeds the maximum of %d operations", o.MaxPipelineOps))
+			return
+		}
+		callback, err := url.Parse(spec.CallbackURL)
+		if err != nil || (callback.Scheme != "http" && callback.Scheme != "https") || callback.Host == "" {
+			badRequest(w, "job requires an http(s) callback_url")
+			return
+		}
+		for _, stage := range spec.Operations {
+			if !isOperation(stage.Operation) {
+				badRequest(w, fmt.Sprintf("unsupported operation: %s", stage.Operation))
+				return
+			}
+			if !operationAllowed(o, stage.Operation) {
+				writeError(w, operationForbidden(stage.Operation))
+				return
+			}
+		}
+
+		source := matchSource(sources, r)
+		image, err := source.GetImage(w, r, ps)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		metrics.AddBytesIn(len(image))
+
+		if err := validateImage(image, o); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		now := time.Now()
+		job := &Job{
+			ID:          newRequestID(),
+			Status:      "queued",
+			Created:     now,
+			Updated:     now,
+			callbackURL: callback.String(),
+			request:     r.WithContext(context.Background()),
+			image:       image,
+			stages:      spec.Operations,
+		}
+		if err := runner.Submit(job); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		reply, _ := json.Marshal(Job{ID: job.ID, Status: job.Status, Created: job.Created, Updated: job.Updated})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(reply)
+	}
+}
+
+// jobStatusController replies the status of the job in the /jobs/{id} path.
+func jobStatusController(runner *JobRunner) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		job, ok := runner.Get(strings.TrimPrefix(r.URL.Path, "/jobs/"))
+		if !ok {
+			writeError(w, NewError("job not found", http.StatusNotFound))
+			return
+		}
+		body, _ := json.Marshal(job)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
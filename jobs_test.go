@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"gopkg.in/h2non/bimg.v1"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type jobCallback struct {
+	status string
+	body   []byte
+}
+
+func TestJobLifecycle(t *testing.T) {
+	upstream := newImageServer(t, 400, 300)
+	callbacks := make(chan jobCallback, 2)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		callbacks <- jobCallback{r.Header.Get("X-Job-Status"), body}
+	}))
+	defer callback.Close()
+
+	handler, err := NewServerMux(testServerOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		operations string
+		status     string
+	}{
+		{`[{"operation":"resize","params":{"width":100}}]`, "done"},
+		{`[{"operation":"resize","params":{"width":100}},{"operation":"crop","params":{"width":-1}}]`, "failed"},
+	}
+
+	for _, c := range cases {
+		body := `{"callback_url":"` + callback.URL + `","operations":` + c.operations + `}`
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/jobs?url="+url.QueryEscape(upstream.URL+"/image.jpg"), strings.NewReader(body)))
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("%s: expected status %d, got %d: %s", c.status, http.StatusAccepted, w.Code, w.Header().Get("Error"))
+		}
+		var job Job
+		json.Unmarshal(w.Body.Bytes(), &job)
+		if job.ID == "" || job.Status != "queued" {
+			t.Fatalf("%s: unexpected submitted job %+v", c.status, job)
+		}
+
+		select {
+		case delivered := <-callbacks:
+			if delivered.status != c.status {
+				t.Errorf("%s: expected the %s callback, got %s", c.status, c.status, delivered.status)
+			}
+			if c.status == "done" && bimg.DetermineImageType(delivered.body) != bimg.JPEG {
+				t.Errorf("%s: expected the result image to be posted", c.status)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: expected the callback to be posted", c.status)
+		}
+
+		// The delivery is recorded right after the callback replies
+		deadline := time.Now().Add(time.Second)
+		for job.Callback == "" && time.Now().Before(deadline) {
+			w = httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/jobs/"+job.ID, nil))
+			json.Unmarshal(w.Body.Bytes(), &job)
+			time.Sleep(10 * time.Millisecond)
+		}
+		if job.Status != c.status || job.Callback != "delivered" {
+			t.Errorf("%s: unexpected job status %+v", c.status, job)
+		}
+		if c.status == "done" && (job.Type != "jpeg" || job.Size == 0) {
+			t.Errorf("%s: expected the result type and size, got %+v", c.status, job)
+		}
+		if c.status == "failed" && job.Error == "" {
+			t.Errorf("%s: expected the job error", c.status)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/jobs/missing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for an unknown job, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestJobRunnerEvict(t *testing.T) {
+	now := time.Now()
+	runner := &JobRunner{ttl: time.Hour, jobs: map[string]*Job{
+		"expired":  {Status: "done", Callback: "delivered", Updated: now.Add(-2 * time.Hour)},
+		"failed":   {Status: "failed", Callback: "failed: callback replied status 404", Updated: now.Add(-2 * time.Hour)},
+		"recent":   {Status: "done", Callback: "delivered", Updated: now.Add(-time.Minute)},
+		"running":  {Status: "running", Updated: now.Add(-2 * time.Hour)},
+		"queued":   {Status: "queued", Updated: now.Add(-2 * time.Hour)},
+		"finished": {Status: "done", Callback: "delivered", Updated: now},
+	}}
+	runner.evict(now)
+
+	for id, expected := range map[string]bool{"expired": false, "failed": false, "recent": true, "running": true, "queued": true, "finished": true} {
+		if _, ok := runner.Get(id); ok != expected {
+			t.Errorf("%s: expected kept %t, got %t", id, expected, ok)
+		}
+	}
+}
+
+func TestJobRunnerSubmitFull(t *testing.T) {
+	runner := &JobRunner{pending: make(chan *Job, 1), jobs: map[string]*Job{}}
+	if err := runner.Submit(&Job{ID: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Submit(&Job{ID: "second"}); err == nil || errorCode(err) != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d on a full queue, got %v", http.StatusServiceUnavailable, err)
+	}
+	if _, ok := runner.Get("second"); ok {
+		t.Error("expected the rejected job not to be stored")
+	}
+}
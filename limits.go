@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/h2non/bimg.v1"
+	"net/http"
+)
+
+// validateImage checks the source image can be decoded and does not exceed
+// the configured limits. Dimensions are read from the image header only,
+// so oversized images are rejected before allocating the pixel buffers.
+func validateImage(buf []byte, o ServerOptions) error {
+	if o.MaxBodySize > 0 && int64(len(buf)) > o.MaxBodySize {
+		return NewError(fmt.Sprintf("image exceeds the maximum size of %d bytes", o.MaxBodySize), http.StatusRequestEntityTooLarge)
+	}
+
+	if err := checkImageType(buf); err != nil {
+		return err
+	}
+	return checkDimensions(buf, o)
+}
+
+// checkDimensions checks the image does not exceed the dimension limits.
+func checkDimensions(buf []byte, o ServerOptions) error {
+	if o.MaxImageWidth == 0 && o.MaxImageHeight == 0 && o.MaxImagePixels == 0 {
+		return nil
+	}
+
+	size, err := bimg.Size(buf)
+	if err != nil {
+		return NewError("cannot read image dimensions: "+err.Error(), http.StatusUnsupportedMediaType)
+	}
+	return checkSize(size.Width, size.Height, o)
+}
+
+// checkSize checks the dimensions do not exceed the limits.
+func checkSize(width, height int, o ServerOptions) error {
+	return imageLimits(o).Check(width, height)
+}
+
+// ImageLimits are the image dimension limits, carried along the operation
+// options to bound the images rendered while processing. Zero is unbounded.
+type ImageLimits struct {
+	Width, Height int
+	Pixels        float64
+}
+
+func imageLimits(o ServerOptions) ImageLimits {
+	return ImageLimits{Width: o.MaxImageWidth, Height: o.MaxImageHeight, Pixels: o.MaxImagePixels}
+}
+
+// Check checks the dimensions do not exceed the limits.
+func (l ImageLimits) Check(width, height int) error {
+	if l.Width > 0 && width > l.Width {
+		return NewError(fmt.Sprintf("image width exceeds the maximum of %d pixels", l.Width), http.StatusRequestEntityTooLarge)
+	}
+	if l.Height > 0 && height > l.Height {
+		return NewError(fmt.Sprintf("image height exceeds the maximum of %d pixels", l.Height), http.StatusRequestEntityTooLarge)
+	}
+	if l.Pixels > 0 && float64(width)*float64(height) > l.Pixels*1e6 {
+		return NewError(fmt.Sprintf("image exceeds the maximum of %g megapixels", l.Pixels), http.StatusRequestEntityTooLarge)
+	}
+	return nil
+}
+
+// defaultPipelineBodySize bounds the JSON bodies of the pipeline, batch
+// and job requests when there is no -max-body-size limit.
+const defaultPipelineBodySize = 1 << 20
+
+// maxPipelineBodySize returns the JSON body limit of the pipeline, batch
+// and job requests, which is the -max-body-size of every request body.
+func maxPipelineBodySize(o ServerOptions) int64 {
+	if o.MaxBodySize > 0 {
+		return o.MaxBodySize
+	}
+	return defaultPipelineBodySize
+}
+
+// withBodyLimit caps the request bodies to the max body size, so clients
+// cannot stream unbounded bodies within the read timeout. Bodies declaring
+// a larger length are rejected before being read.
+func withBodyLimit(max int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > max {
+			writeError(w, NewError("request body is too large", http.StatusRequestEntityTooLarge))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Listener is a server listener address, defined by the -listen flag as
+// host:port for plain HTTP, tls://host:port for HTTPS or unix:///path.
+type Listener struct {
+	Network string
+	Address string
+	TLS     bool
+}
+
+func (l Listener) String() string {
+	switch {
+	case l.Network == "unix":
+		return "socket " + l.Address
+	case l.TLS:
+		return "tls://" + l.Address
+	}
+	return l.Address
+}
+
+// parseListener parses a -listen flag value.
+func parseListener(value string) (Listener, error) {
+	l := Listener{Network: "tcp", Address: value}
+	switch {
+	case strings.HasPrefix(value, "unix://"):
+		l.Network, l.Address = "unix", strings.TrimPrefix(value, "unix://")
+		if l.Address == "" {
+			return l, fmt.Errorf("invalid listen address: %s", value)
+		}
+		return l, nil
+	case strings.HasPrefix(value, "tls://"):
+		l.Address, l.TLS = strings.TrimPrefix(value, "tls://"), true
+	case strings.HasPrefix(value, "http://"):
+		l.Address = strings.TrimPrefix(value, "http://")
+	}
+	if _, port, err := net.SplitHostPort(l.Address); err != nil || port == "" {
+		return l, fmt.Errorf("invalid listen address: %s", value)
+	}
+	return l, nil
+}
+
+// serverListeners returns the -listen addresses, or the single listener
+// defined by the address, port, socket and TLS flags otherwise.
+func serverListeners(o ServerOptions) ([]Listener, error) {
+	if len(o.Listen) == 0 {
+		if socket := socketPath(o); socket != "" {
+			return []Listener{{Network: "unix", Address: socket, TLS: o.CertFile != "" && o.KeyFile != ""}}, nil
+		}
+		return []Listener{{
+			Network: "tcp",
+			Address: o.Address + ":" + strconv.Itoa(o.Port),
+			TLS:     o.CertFile != "" && o.KeyFile != "",
+		}}, nil
+	}
+
+	var listeners []Listener
+	for _, value := range o.Listen {
+		l, err := parseListener(value)
+		if err != nil {
+			return nil, err
+		}
+		if l.TLS && (o.CertFile == "" || o.KeyFile == "") {
+			return nil, fmt.Errorf("TLS listener %s requires -certfile and -keyfile", value)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// listen binds all the listeners, so the server fails to start when any
+// address is unavailable.
+func listen(listeners []Listener, o ServerOptions) ([]net.Listener, error) {
+	var bound []net.Listener
+	for _, l := range listeners {
+		var listener net.Listener
+		var err error
+		if l.Network == "unix" {
+			// The socket file is removed when the listener is closed on shutdown
+			listener, err = listenSocket(l.Address, o.SocketMode)
+		} else {
+			listener, err = net.Listen("tcp", l.Address)
+		}
+		if err != nil {
+			for _, listener := range bound {
+				listener.Close()
+			}
+			return nil, err
+		}
+		bound = append(bound, listener)
+	}
+
+	if o.MaxConnections > 0 {
+		// The limit is shared by all the listeners
+		active := new(int64)
+		for i, listener := range bound {
+			bound[i] = &limitListener{Listener: listener, max: int64(o.MaxConnections), active: active}
+		}
+	}
+	return bound, nil
+}
+
+// limitListener closes the accepted connections past the -max-connections
+// limit, instead of queueing them in the listen backlog.
+type limitListener struct {
+	net.Listener
+	max    int64
+	active *int64
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if atomic.AddInt64(l.active, 1) > l.max {
+			atomic.AddInt64(l.active, -1)
+			conn.Close()
+			continue
+		}
+		return &limitConn{Conn: conn, active: l.active}, nil
+	}
+}
+
+// limitConn releases its slot once closed.
+type limitConn struct {
+	net.Conn
+	active *int64
+	once   sync.Once
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { atomic.AddInt64(c.active, -1) })
+	return err
+}
+
+// serve serves the listener with the shared server, which drains the
+// connections of every listener on shutdown.
+func serve(s *http.Server, l Listener, listener net.Listener, o ServerOptions) error {
+	if l.TLS {
+		return s.ServeTLS(listener, o.CertFile, o.KeyFile)
+	}
+	return s.Serve(listener)
+}
+
+// disableHTTP2 prevents the HTTP/2 negotiation via ALPN on the TLS listeners.
+func disableHTTP2(s *http.Server) {
+	s.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log levels, sorted by verbosity.
+const (
+	LevelDebug = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevels = map[string]int{
+	"debug": LevelDebug,
+	"info":  LevelInfo,
+	"warn":  LevelWarn,
+	"error": LevelError,
+}
+
+// scrubbedParams are the query params never written to the logs.
+var scrubbedParams = []string{"sign", "key"}
+
+// AccessEntry describes a served request.
+type AccessEntry struct {
+	Time      time.Time `json:"time"`
+	Level     string    `json:"level"`
+	RequestID string    `json:"request_id"`
+	Method    string    `json:"method"`
+	Proto     string    `json:"proto"`
+	Path      string    `json:"path"`
+	Operation string    `json:"operation,omitempty"`
+	Source    string    `json:"source,omitempty"`
+	Status    int       `json:"status"`
+	BytesOut  int       `json:"bytes_out"`
+	Duration  float64   `json:"duration_ms"`
+	RemoteIP  string    `json:"remote_ip"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Logger writes the request access logs.
+type Logger interface {
+	Access(entry AccessEntry)
+}
+
+// NewLogger returns the logger for the given format, writing the lines to w,
+// or to stdout when nil. The text format is only written to stdout in debug mode.
+func NewLogger(format, level string, w io.Writer) (Logger, error) {
+	min, ok := logLevels[level]
+	if !ok {
+		return nil, fmt.Errorf("invalid log level: %s", level)
+	}
+
+	switch format {
+	case "json":
+		if w == nil {
+			w = os.Stdout
+		}
+		return &JSONLogger{w: w, level: min}, nil
+	case "text":
+		return textLogger{w: w, level: min}, nil
+	default:
+		return nil, fmt.Errorf("invalid log format: %s", format)
+	}
+}
+
+// JSONLogger writes one JSON document per line.
+type JSONLogger struct {
+	mutex sync.Mutex
+	w     io.Writer
+	level int
+}
+
+func (l *JSONLogger) Access(entry AccessEntry) {
+	if logLevels[entry.Level] < l.level {
+		return
+	}
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.w.Write(append(buf, '\n'))
+}
+
+type textLogger struct {
+	w     io.Writer
+	level int
+}
+
+func (l textLogger) Access(e AccessEntry) {
+	if logLevels[e.Level] < l.level {
+		return
+	}
+	if l.w != nil {
+		fmt.Fprintf(l.w, "%s %s %s %s %s %d %dB %.2fms %s\n", e.Time.Format(time.RFC3339), e.RequestID, e.RemoteIP, e.Method, e.Path, e.Status, e.BytesOut, e.Duration, e.Error)
+		return
+	}
+	debug("%s %s %s %s %d %dB %.2fms %s", e.RequestID, e.RemoteIP, e.Method, e.Path, e.Status, e.BytesOut, e.Duration, e.Error)
+}
+
+type logContextKey struct{}
+
+// logSource records the image source type in the request access log entry.
+func logSource(r *http.Request, source string) {
+	if entry, ok := r.Context().Value(logContextKey{}).(*AccessEntry); ok {
+		entry.Source = source
+	}
+}
+
+// logOperation records the operation in the access log entry of the
+// requests whose path is rewritten after the entry is created, as the
+// prefix of the -base-path ones is stripped.
+func logOperation(r *http.Request, operation string) {
+	if entry, ok := r.Context().Value(logContextKey{}).(*AccessEntry); ok {
+		entry.Operation = operation
+	}
+}
+
+// accessLog wraps the handler writing an access log entry per request.
+func accessLog(logger Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entry := &AccessEntry{
+			Time:      time.Now().UTC(),
+			RequestID: RequestID(r),
+			Method:    r.Method,
+			Proto:     r.Proto,
+			Path:      scrubPath(r),
+			Operation: requestOperation(r),
+			RemoteIP:  remoteIP(r),
+		}
+
+		writer := &metricsWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(writer, r.WithContext(context.WithValue(r.Context(), logContextKey{}, entry)))
+
+		entry.Status = writer.status
+		entry.BytesOut = writer.written
+		entry.Duration = float64(time.Since(entry.Time)) / float64(time.Millisecond)
+		entry.Error = w.Header().Get("Error")
+		entry.Level = statusLevel(writer.status)
+		logger.Access(*entry)
+	})
+}
+
+func statusLevel(status int) string {
+	switch {
+	case status >= 500:
+		return "error"
+	case status >= 400:
+		return "warn"
+	default:
+		return "info"
+	}
+}
+
+// scrubPath returns the request path and query without the secret params,
+// nor the credentials of the image URLs embedded in the path or the params,
+// such as the url, watermarkimage or onerror ones.
+func scrubPath(r *http.Request) string {
+	path := r.URL.Path
+	if scheme := strings.Index(path, "://"); scheme > 0 {
+		start := strings.LastIndex(path[:scheme], "/") + 1
+		path = path[:start] + scrubURL(path[start:])
+	}
+
+	query := r.URL.Query()
+	for name, values := range query {
+		for i, value := range values {
+			values[i] = scrubURL(value)
+		}
+		query[name] = values
+	}
+	for _, name := range scrubbedParams {
+		if _, ok := query[name]; ok {
+			query.Set(name, "REDACTED")
+		}
+	}
+	if len(query) == 0 {
+		return path
+	}
+	return path + "?" + query.Encode()
+}
+
+// scrubURL returns the value without the userinfo, if it is a URL.
+func scrubURL(value string) string {
+	scheme := strings.Index(value, "://")
+	if scheme < 0 {
+		return value
+	}
+	if u, err := url.Parse(value); err == nil {
+		if u.User == nil {
+			return value
+		}
+		u.User = nil
+		return u.String()
+	}
+
+	// Unparsed URLs may still hold credentials in their authority
+	authority := value[scheme+3:]
+	if end := strings.IndexAny(authority, "/?#"); end >= 0 {
+		authority = authority[:end]
+	}
+	if at := strings.LastIndex(authority, "@"); at >= 0 {
+		return value[:scheme+3] + value[scheme+3+at+1:]
+	}
+	return value
+}
+
+func requestOperation(r *http.Request) string {
+	name := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+	if isOperation(name) || name == "info" || name == "pipeline" || name == "batch" || name == "jobs" {
+		return name
+	}
+	return ""
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
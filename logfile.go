@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// LogFile is an append only log file, which is reopened on SIGHUP so
+// external tools like logrotate can move it without restarting the server.
+type LogFile struct {
+	path string
+
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func OpenLogFile(path string) (*LogFile, error) {
+	l := &LogFile{path: path}
+	if err := l.Reopen(); err != nil {
+		return nil, err
+	}
+	go l.reopenOnHangup()
+	return l, nil
+}
+
+func (l *LogFile) Write(buf []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.file.Write(buf)
+}
+
+// Reopen opens the file at its path, creating it if missing, and closes
+// the previous one, which may have been rotated.
+func (l *LogFile) Reopen() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	previous := l.file
+	l.file = file
+	l.mutex.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
+}
+
+func (l *LogFile) reopenOnHangup() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		// Keep writing to the previous file on error
+		if err := l.Reopen(); err != nil {
+			debug("cannot reopen access log: %s", err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+)
+
+// maintenanceRetryAfter is the Retry-After seconds replied in maintenance mode.
+const maintenanceRetryAfter = 60
+
+var maintenance int32
+
+func inMaintenance() bool {
+	return atomic.LoadInt32(&maintenance) == 1
+}
+
+func setMaintenance(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	if atomic.SwapInt32(&maintenance, value) != value {
+		debug("maintenance mode: %t", enabled)
+	}
+}
+
+// toggleMaintenance flips the maintenance mode on every SIGUSR1.
+func toggleMaintenance() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	for range signals {
+		setMaintenance(!inMaintenance())
+	}
+}
+
+// withMaintenance replies 503 to the operation requests in maintenance mode,
+// so the node drains while its health checks keep being served.
+func withMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if inMaintenance() && requestOperation(r) != "" {
+			w.Header().Set("Retry-After", strconv.Itoa(maintenanceRetryAfter))
+			writeError(w, NewError("server in maintenance mode", http.StatusServiceUnavailable))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maintenanceController sets the maintenance mode defined by the enabled
+// query param, or flips it, and replies the current mode.
+func maintenanceController(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	enabled, err := parseBoolParam(r.URL.Query(), "enabled", !inMaintenance())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	setMaintenance(enabled)
+
+	body, _ := json.Marshal(map[string]bool{"maintenance": enabled})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	defer setMaintenance(false)
+
+	admin := validateAdminKey("secret", "header", maintenanceController)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		admin(w, r, nil)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := withMaintenance(mux)
+
+	do := func(method, path, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		code   int
+		body   string
+	}{
+		{"operation served", "GET", "/resize/300x/image.jpg", "", http.StatusOK, ""},
+		{"toggle without key", "POST", "/admin/maintenance", "", http.StatusForbidden, ""},
+		{"toggle with wrong key", "POST", "/admin/maintenance", "wrong", http.StatusForbidden, ""},
+		{"key in query not accepted", "POST", "/admin/maintenance?key=secret", "", http.StatusForbidden, ""},
+		{"toggle on", "POST", "/admin/maintenance", "secret", http.StatusOK, `{"maintenance":true}`},
+		{"operation in maintenance", "GET", "/resize/300x/image.jpg", "", http.StatusServiceUnavailable, ""},
+		{"health in maintenance", "GET", "/health", "", http.StatusOK, ""},
+		{"invalid enabled", "POST", "/admin/maintenance?enabled=maybe", "secret", http.StatusBadRequest, ""},
+		{"enable again", "POST", "/admin/maintenance?enabled=true", "secret", http.StatusOK, `{"maintenance":true}`},
+		{"toggle off", "POST", "/admin/maintenance", "secret", http.StatusOK, `{"maintenance":false}`},
+		{"operation served again", "GET", "/resize/300x/image.jpg", "", http.StatusOK, ""},
+		{"disable", "POST", "/admin/maintenance?enabled=false", "secret", http.StatusOK, `{"maintenance":false}`},
+	}
+
+	for _, c := range cases {
+		w := do(c.method, c.path, c.key)
+		if w.Code != c.code {
+			t.Fatalf("%s: expected status %d, got %d", c.name, c.code, w.Code)
+		}
+		if c.body != "" && w.Body.String() != c.body {
+			t.Errorf("%s: expected body %s, got %s", c.name, c.body, w.Body.String())
+		}
+		retryAfter := w.Header().Get("Retry-After")
+		if c.code == http.StatusServiceUnavailable && retryAfter != strconv.Itoa(maintenanceRetryAfter) {
+			t.Errorf("%s: expected Retry-After %d, got %q", c.name, maintenanceRetryAfter, retryAfter)
+		}
+		if c.code != http.StatusServiceUnavailable && retryAfter != "" {
+			t.Errorf("%s: unexpected Retry-After %q", c.name, retryAfter)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var durationBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var metrics = NewMetrics()
+
+type requestLabels struct {
+	Operation string
+	Code      int
+}
+
+type histogram struct {
+	Buckets []uint64
+	Sum     float64
+	Count   uint64
+}
+
+type Metrics struct {
+	Requests  uint64
+	BytesIn   uint64
+	BytesOut  uint64
+	InFlight  int64
+	Throttled uint64
+
+	mutex     sync.Mutex
+	requests  map[requestLabels]uint64
+	durations map[string]*histogram
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:  make(map[requestLabels]uint64),
+		durations: make(map[string]*histogram),
+	}
+}
+
+func (m *Metrics) Observe(operation string, code int, duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.requests[requestLabels{operation, code}]++
+	atomic.AddUint64(&m.Requests, 1)
+
+	h, ok := m.durations[operation]
+	if !ok {
+		h = &histogram{Buckets: make([]uint64, len(durationBuckets))}
+		m.durations[operation] = h
+	}
+
+	seconds := duration.Seconds()
+	for i, le := range durationBuckets {
+		if seconds <= le {
+			h.Buckets[i]++
+		}
+	}
+	h.Sum += seconds
+	h.Count++
+}
+
+func (m *Metrics) AddBytesIn(n int) {
+	atomic.AddUint64(&m.BytesIn, uint64(n))
+}
+
+func (m *Metrics) AddBytesOut(n int) {
+	atomic.AddUint64(&m.BytesOut, uint64(n))
+}
+
+func (m *Metrics) IncThrottled() {
+	atomic.AddUint64(&m.Throttled, 1)
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(buf *bytes.Buffer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	buf.WriteString("# HELP resizr_requests_total Total image requests by operation and status code.\n")
+	buf.WriteString("# TYPE resizr_requests_total counter\n")
+	labels := make([]requestLabels, 0, len(m.requests))
+	for l := range m.requests {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].Operation != labels[j].Operation {
+			return labels[i].Operation < labels[j].Operation
+		}
+		return labels[i].Code < labels[j].Code
+	})
+	for _, l := range labels {
+		fmt.Fprintf(buf, "resizr_requests_total{operation=%q,code=\"%d\"} %d\n", l.Operation, l.Code, m.requests[l])
+	}
+
+	buf.WriteString("# HELP resizr_processing_duration_seconds Image request processing duration.\n")
+	buf.WriteString("# TYPE resizr_processing_duration_seconds histogram\n")
+	operations := make([]string, 0, len(m.durations))
+	for op := range m.durations {
+		operations = append(operations, op)
+	}
+	sort.Strings(operations)
+	for _, op := range operations {
+		h := m.durations[op]
+		for i, le := range durationBuckets {
+			fmt.Fprintf(buf, "resizr_processing_duration_seconds_bucket{operation=%q,le=\"%s\"} %d\n", op, strconv.FormatFloat(le, 'g', -1, 64), h.Buckets[i])
+		}
+		fmt.Fprintf(buf, "resizr_processing_duration_seconds_bucket{operation=%q,le=\"+Inf\"} %d\n", op, h.Count)
+		fmt.Fprintf(buf, "resizr_processing_duration_seconds_sum{operation=%q} %g\n", op, h.Sum)
+		fmt.Fprintf(buf, "resizr_processing_duration_seconds_count{operation=%q} %d\n", op, h.Count)
+	}
+
+	writeMetric(buf, "resizr_bytes_in_total", "counter", "Total source image bytes read.", atomic.LoadUint64(&m.BytesIn))
+	writeMetric(buf, "resizr_bytes_out_total", "counter", "Total response bytes written.", atomic.LoadUint64(&m.BytesOut))
+	writeMetric(buf, "resizr_requests_in_flight", "gauge", "Image requests currently being processed.", atomic.LoadInt64(&m.InFlight))
+	writeMetric(buf, "resizr_throttle_rejections_total", "counter", "Total requests rejected by the throttle.", atomic.LoadUint64(&m.Throttled))
+}
+
+func writeMetric(buf *bytes.Buffer, name, kind, help string, value interface{}) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
+
+func metricsController(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	metrics.WriteTo(&buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+// instrument wraps an image operation handler recording its metrics,
+// labeled by the requested operation.
+func instrument(next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		operation := ps.ByName("operation")
+		if !isOperation(operation) {
+			operation = "unknown"
+		}
+		instrumentAs(operation, next)(w, r, ps)
+	}
+}
+
+// instrumentAs wraps a handler recording its metrics with a fixed operation label.
+func instrumentAs(operation string, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		atomic.AddInt64(&metrics.InFlight, 1)
+		defer atomic.AddInt64(&metrics.InFlight, -1)
+
+		start := time.Now()
+		writer := &metricsWriter{ResponseWriter: w, status: http.StatusOK}
+		next(writer, r, ps)
+
+		metrics.AddBytesOut(writer.written)
+		metrics.Observe(operation, writer.status, time.Since(start))
+	}
+}
+
+type metricsWriter struct {
+	http.ResponseWriter
+	status  int
+	written int
+}
+
+func (w *metricsWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *metricsWriter) Write(buf []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(buf)
+	w.written += n
+	return n, err
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsScrape(t *testing.T) {
+	defer func(m *Metrics) { metrics = m }(metrics)
+	metrics = NewMetrics()
+
+	ok := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Write([]byte("image"))
+	}
+	fail := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		writeError(w, NewError("invalid width", http.StatusBadRequest))
+	}
+
+	calls := []struct {
+		handler   httprouter.Handle
+		operation string
+	}{
+		{instrument(ok), "resize"},
+		{instrument(ok), "resize"},
+		{instrument(fail), "resize"},
+		{instrument(ok), "nope"},
+		{instrumentAs("info", ok), ""},
+	}
+	for _, c := range calls {
+		r := httptest.NewRequest("GET", "/", nil)
+		c.handler(httptest.NewRecorder(), r, httprouter.Params{{Key: "operation", Value: c.operation}})
+	}
+	metrics.AddBytesIn(1024)
+	metrics.IncThrottled()
+
+	w := httptest.NewRecorder()
+	metricsController(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("expected a text/plain content type, got %q", ct)
+	}
+
+	body := w.Body.String()
+	for _, line := range []string{
+		`resizr_requests_total{operation="info",code="200"} 1`,
+		`resizr_requests_total{operation="resize",code="200"} 2`,
+		`resizr_requests_total{operation="resize",code="400"} 1`,
+		`resizr_requests_total{operation="unknown",code="200"} 1`,
+		`resizr_processing_duration_seconds_bucket{operation="resize",le="+Inf"} 3`,
+		`resizr_processing_duration_seconds_count{operation="resize"} 3`,
+		`resizr_processing_duration_seconds_count{operation="info"} 1`,
+		"resizr_bytes_in_total 1024",
+		"resizr_requests_in_flight 0",
+		"resizr_throttle_rejections_total 1",
+		"# TYPE resizr_requests_total counter",
+		"# TYPE resizr_processing_duration_seconds histogram",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected the scrape to contain %q, got:\n%s", line, body)
+		}
+	}
+	if !strings.Contains(body, "resizr_bytes_out_total ") || strings.Contains(body, "resizr_bytes_out_total 0\n") {
+		t.Errorf("expected the written bytes to be counted, got:\n%s", body)
+	}
+}
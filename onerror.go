@@ -0,0 +1,39 @@
+package main
+
+import "net/http"
+
+// fallbackUsedHeader reports the onerror fallback image was served.
+const fallbackUsedHeader = "X-Fallback-Used"
+
+// loadFallback returns the onerror fallback image, else the server default
+// fallback: the bundled "placeholder", or an image URL or S3 key cached as
+// the watermarks are. The source error is returned when no fallback is
+// defined, or when the fallback fails too.
+func loadFallback(r *http.Request, o ServerOptions, watermarks *WatermarkStore, opts Options, cause error) ([]byte, error) {
+	location := opts.OnError
+	if location == "" {
+		location = o.DefaultFallbackImage
+	}
+	if location == "" || opts.Operation == "generate" {
+		return nil, cause
+	}
+
+	image := placeholder
+	if location == "placeholder" {
+		if len(o.Placeholder) > 1 {
+			image = o.Placeholder
+		}
+	} else {
+		var err error
+		if image, err = watermarks.get(r, location); err != nil {
+			debug("cannot load fallback image %s: %s", location, err)
+			return nil, cause
+		}
+	}
+	if err := validateImage(image, o); err != nil {
+		debug("invalid fallback image %s: %s", location, err)
+		return nil, cause
+	}
+	debug("serving fallback image %s: %s", location, cause)
+	return image, nil
+}
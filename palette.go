@@ -0,0 +1,106 @@
+package main
+
+/*
+#cgo pkg-config: vips
+#include <stdlib.h>
+#include <vips/vips.h>
+
+// png_save_buffer encodes the image as a PNG, with any compression level,
+// and as an 8-bit palette PNG quantized with libimagequant if palette is
+// set. colours is still accepted by libvips >= 8.12, which replaced it
+// with bitdepth.
+static int
+png_save_buffer(void *buf, size_t len, int compression, int interlace, int strip, int palette, int colours, double dither, void **out, size_t *out_len) {
+	VipsImage *in = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (in == NULL) {
+		return 1;
+	}
+
+	int err = palette
+		? vips_pngsave_buffer(in, out, out_len,
+			"compression", compression,
+			"interlace", interlace,
+			"strip", strip,
+			"palette", TRUE,
+			"colours", colours,
+			"dither", dither,
+			NULL)
+		: vips_pngsave_buffer(in, out, out_len,
+			"compression", compression,
+			"interlace", interlace,
+			"strip", strip,
+			NULL);
+
+	g_object_unref(in);
+	return err;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"unsafe"
+)
+
+// PaletteOptions defines the PNG color quantization, by default to the
+// libvips 256 colors with full dithering.
+type PaletteOptions struct {
+	Enabled bool
+	Colors  int
+	Dither  float64
+}
+
+// readPaletteParams reads the palette, colors and dither params,
+// which only apply to the PNG output images.
+func readPaletteParams(query url.Values, opts *Options) error {
+	var err error
+	opts.Palette = PaletteOptions{Colors: 256, Dither: 1}
+	if opts.Palette.Enabled, err = parseBoolParam(query, "palette", false); err != nil {
+		return err
+	}
+	if query.Get("colors") != "" {
+		if opts.Palette.Colors, err = parseIntParam(query, "colors", 2, 256); err != nil {
+			return err
+		}
+	}
+	if query.Get("dither") != "" {
+		if opts.Palette.Dither, err = parseFloatParam(query, "dither", 0, 1); err != nil {
+			return err
+		}
+	}
+
+	if !opts.Palette.Enabled && (query.Get("colors") != "" || query.Get("dither") != "") {
+		return NewError("colors and dither params require palette=true", http.StatusBadRequest)
+	}
+	return nil
+}
+
+// savePNG encodes a losslessly processed image as a PNG, with the palette
+// or a compression level 0, which bimg does not support.
+func savePNG(image []byte, compression int, interlace, strip bool, o PaletteOptions) ([]byte, error) {
+	if len(image) == 0 {
+		return nil, errors.New("empty image")
+	}
+
+	cInterlace, cStrip, cPalette := C.int(0), C.int(0), C.int(0)
+	if interlace {
+		cInterlace = 1
+	}
+	if strip {
+		cStrip = 1
+	}
+	if o.Enabled {
+		cPalette = 1
+	}
+
+	var out unsafe.Pointer
+	var length C.size_t
+	if C.png_save_buffer(unsafe.Pointer(&image[0]), C.size_t(len(image)), C.int(compression), cInterlace, cStrip,
+		cPalette, C.int(o.Colors), C.double(o.Dither), &out, &length) != 0 {
+		return nil, vipsErrorf("cannot encode PNG image")
+	}
+	defer C.g_free(C.gpointer(out))
+	return C.GoBytes(out, C.int(length)), nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/h2non/bimg.v1"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// readParams reads the optional query string image params into opts.
+func readParams(query url.Values, opts *Options) error {
+	var err error
+
+	if name := query.Get("type"); name != "" {
+		if opts.Type, err = parseImageType(name); err != nil {
+			return err
+		}
+	}
+	switch format := query.Get("format"); format {
+	case "":
+	case "auto":
+		opts.KeepFormat = true
+	default:
+		return NewError(fmt.Sprintf("unsupported format: %s", format), http.StatusBadRequest)
+	}
+	if opts.DPR, err = parseDPR(query); err != nil {
+		return err
+	}
+	if query.Get("quality") == "auto" {
+		opts.AutoQuality = true
+	} else if opts.Quality, err = parseIntParam(query, "quality", 0, 100); err != nil {
+		return err
+	} else {
+		// Checked by the output type, since PNG takes a compression level
+		opts.QualityParam = query.Get("quality") != ""
+	}
+	if opts.Speed, err = parseIntParam(query, "speed", 0, 8); err != nil {
+		return err
+	}
+	// The embed operation supports the positional gravities
+	if name := query.Get("gravity"); name != "" && opts.Operation != "embed" {
+		if opts.Gravity, err = parseGravity(name); err != nil {
+			return err
+		}
+		if cornerGravities[name] {
+			opts.CropCorner = name
+		}
+	}
+	for _, name := range []string{"interlace", "progressive"} {
+		if opts.Interlace, err = parseBoolParam(query, name, opts.Interlace); err != nil {
+			return err
+		}
+	}
+	if opts.StripMetadata, err = parseBoolParam(query, "strip", opts.StripMetadata); err != nil {
+		return err
+	}
+	if opts.DryRun, err = parseBoolParam(query, "dryrun", false); err != nil {
+		return err
+	}
+	if opts.Debug, err = parseBoolParam(query, "debug", false); err != nil {
+		return err
+	}
+	opts.OnError = query.Get("onerror")
+	if expr := query.Get("if"); expr != "" {
+		if opts.Condition, err = parseCondition(expr); err != nil {
+			return err
+		}
+	}
+	switch encoding := query.Get("encoding"); encoding {
+	case "":
+	case "base64":
+		opts.DataURI = true
+	default:
+		return NewError(fmt.Sprintf("unsupported encoding: %s", encoding), http.StatusBadRequest)
+	}
+	if opts.ForceEncode, err = parseBoolParam(query, "force", false); err != nil {
+		return err
+	}
+	if opts.Enlarge, err = parseBoolParam(query, "enlarge", opts.Enlarge); err != nil {
+		return err
+	}
+	if err := readDocumentParams(query, opts); err != nil {
+		return err
+	}
+	if err := readWebPParams(query, opts); err != nil {
+		return err
+	}
+	if err := readTIFFParams(query, opts); err != nil {
+		return err
+	}
+	if err := readPaletteParams(query, opts); err != nil {
+		return err
+	}
+	if err := readSubsampleParams(query, opts); err != nil {
+		return err
+	}
+	if err := readFlattenParams(query, opts); err != nil {
+		return err
+	}
+	if err := readFilterParams(query, opts); err != nil {
+		return err
+	}
+	if err := readColorParams(query, opts); err != nil {
+		return err
+	}
+	if err := readRotateParams(query, opts); err != nil {
+		return err
+	}
+	if err := readFitParams(query, opts); err != nil {
+		return err
+	}
+	if err := readEmbedParams(query, opts); err != nil {
+		return err
+	}
+	if err := readThumbnailParams(query, opts); err != nil {
+		return err
+	}
+	if err := readGenerateParams(query, opts); err != nil {
+		return err
+	}
+	if err := readDispositionParams(query, opts); err != nil {
+		return err
+	}
+	if err := readExtractParams(query, opts); err != nil {
+		return err
+	}
+	if err := readTrimParams(query, opts); err != nil {
+		return err
+	}
+	if err := readPlaceholderParams(query, opts); err != nil {
+		return err
+	}
+	return readWatermarkParams(query, opts)
+}
+
+func parseDPR(query url.Values) (float64, error) {
+	value := query.Get("dpr")
+	if value == "" {
+		return 0, nil
+	}
+	dpr, err := strconv.ParseFloat(value, 64)
+	if err != nil || dpr <= 0 || math.IsNaN(dpr) || math.IsInf(dpr, 0) {
+		return 0, NewError("invalid dpr param: must be a positive number", http.StatusBadRequest)
+	}
+	return dpr, nil
+}
+
+// applyDPR scales the requested dimensions by the device pixel ratio,
+// bounded by the server max ratio. The scaled dimensions are then clamped
+// to the -max-output-width and -max-output-height limits by clampOutput.
+func applyDPR(opts *Options, o ServerOptions) {
+	if opts.DPR == 0 {
+		return
+	}
+
+	dpr := opts.DPR
+	if o.MaxDPR > 0 && dpr > o.MaxDPR {
+		dpr = o.MaxDPR
+	}
+	opts.Width = int(math.Round(float64(opts.Width) * dpr))
+	opts.Height = int(math.Round(float64(opts.Height) * dpr))
+}
+
+func readWatermarkParams(query url.Values, opts *Options) error {
+	var err error
+
+	opts.Watermark.URL = query.Get("watermarkimage")
+	if opts.Watermark.URL == "" {
+		return nil
+	}
+
+	opacity, err := parseFloatParam(query, "opacity", 0, 1)
+	if err != nil {
+		return err
+	}
+	opts.Watermark.Opacity = float32(opacity)
+
+	if opts.Watermark.Left, err = parseIntParam(query, "wmleft", 0, bimg.MaxSize()); err != nil {
+		return err
+	}
+	if opts.Watermark.Top, err = parseIntParam(query, "wmtop", 0, bimg.MaxSize()); err != nil {
+		return err
+	}
+	if opts.Watermark.Scale, err = parseFloatParam(query, "wmscale", 0, 1); err != nil {
+		return err
+	}
+	if name := query.Get("wmgravity"); name != "" {
+		if opts.Watermark.Gravity, err = parseWatermarkGravity(name); err != nil {
+			return err
+		}
+	}
+	if opts.Watermark.Tile, err = parseBoolParam(query, "tile", false); err != nil {
+		return err
+	}
+	opts.Watermark.Spacing = defaultTileSpacing
+	if query.Get("spacing") != "" {
+		if opts.Watermark.Spacing, err = parseIntParam(query, "spacing", 0, bimg.MaxSize()); err != nil {
+			return err
+		}
+	}
+	if opts.Watermark.Angle, err = parseFloatParam(query, "wmangle", -360, 360); err != nil {
+		return err
+	}
+	return nil
+}
+
+func parseGravity(name string) (bimg.Gravity, error) {
+	switch name {
+	case "centre", "center":
+		return bimg.GravityCentre, nil
+	case "smart":
+		return bimg.GravitySmart, nil
+	case "north":
+		return bimg.GravityNorth, nil
+	case "south":
+		return bimg.GravitySouth, nil
+	case "east":
+		return bimg.GravityEast, nil
+	case "west":
+		return bimg.GravityWest, nil
+	}
+	if cornerGravities[name] {
+		return bimg.GravityCentre, nil
+	}
+	return bimg.GravityCentre, NewError(fmt.Sprintf("unsupported gravity: %s", name), http.StatusBadRequest)
+}
+
+// parseImageType returns the known image type. The encoder availability
+// is checked by resolveOutputType, which may apply a format fallback.
+func parseImageType(name string) (bimg.ImageType, error) {
+	if code, ok := lookupImageType(name); ok {
+		return code, nil
+	}
+	return bimg.UNKNOWN, NewError(fmt.Sprintf("unsupported output image type: %s", name), http.StatusBadRequest)
+}
+
+func parseIntParam(query url.Values, name string, min, max int) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	num, err := strconv.Atoi(value)
+	if err != nil || num < min || num > max {
+		return 0, NewError(fmt.Sprintf("invalid %s param: must be between %d and %d", name, min, max), http.StatusBadRequest)
+	}
+	return num, nil
+}
+
+// parseBoolParam returns the boolean param value, or the given default if not present.
+func parseBoolParam(query url.Values, name string, value bool) (bool, error) {
+	if query.Get(name) == "" {
+		return value, nil
+	}
+	value, err := strconv.ParseBool(query.Get(name))
+	if err != nil {
+		return false, NewError(fmt.Sprintf("invalid %s param: must be a boolean", name), http.StatusBadRequest)
+	}
+	return value, nil
+}
+
+func parseFloatParam(query url.Values, name string, min, max float64) (float64, error) {
+	value := query.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	num, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(num) || num < min || num > max {
+		return 0, NewError(fmt.Sprintf("invalid %s param: must be between %g and %g", name, min, max), http.StatusBadRequest)
+	}
+	return num, nil
+}
+
+// supportedOutputTypes returns the image types libvips is able to encode.
+func supportedOutputTypes() []string {
+	types := []string{}
+	for _, code := range []bimg.ImageType{bimg.JPEG, bimg.PNG, bimg.WEBP, bimg.AVIF, bimg.GIF, bimg.TIFF} {
+		if bimg.IsTypeSupportedSave(code) {
+			types = append(types, bimg.ImageTypeName(code))
+		}
+	}
+	return types
+}
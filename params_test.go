@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestParseNumberParams(t *testing.T) {
+	cases := []struct {
+		query    string
+		parse    func(url.Values) (float64, error)
+		expected float64
+		code     int
+	}{
+		{"", intParam("speed", 0, 8), 0, 0},
+		{"speed=4", intParam("speed", 0, 8), 4, 0},
+		{"speed=9", intParam("speed", 0, 8), 0, http.StatusBadRequest},
+		{"speed=-1", intParam("speed", 0, 8), 0, http.StatusBadRequest},
+		{"speed=fast", intParam("speed", 0, 8), 0, http.StatusBadRequest},
+		{"opacity=0.5", floatParam("opacity", 0, 1), 0.5, 0},
+		{"opacity=1.5", floatParam("opacity", 0, 1), 0, http.StatusBadRequest},
+		{"opacity=NaN", floatParam("opacity", 0, 1), 0, http.StatusBadRequest},
+		{"wmscale=nan", floatParam("wmscale", 0, 1), 0, http.StatusBadRequest},
+		{"wmangle=NaN", floatParam("wmangle", -360, 360), 0, http.StatusBadRequest},
+		{"", parseDPR, 0, 0},
+		{"dpr=2", parseDPR, 2, 0},
+		{"dpr=1.5", parseDPR, 1.5, 0},
+		{"dpr=0", parseDPR, 0, http.StatusBadRequest},
+		{"dpr=-2", parseDPR, 0, http.StatusBadRequest},
+		{"dpr=Inf", parseDPR, 0, http.StatusBadRequest},
+		{"dpr=NaN", parseDPR, 0, http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		query, _ := url.ParseQuery(c.query)
+		value, err := c.parse(query)
+		if c.code != 0 {
+			if err == nil || errorCode(err) != c.code {
+				t.Errorf("%q: expected status %d, got %v", c.query, c.code, err)
+			}
+			continue
+		}
+		if err != nil || value != c.expected {
+			t.Errorf("%q: expected %g, got %g (%v)", c.query, c.expected, value, err)
+		}
+	}
+}
+
+func TestParseBoolParam(t *testing.T) {
+	cases := []struct {
+		query    string
+		value    bool
+		expected bool
+		code     int
+	}{
+		{"", false, false, 0},
+		{"", true, true, 0},
+		{"enlarge=true", false, true, 0},
+		{"enlarge=1", false, true, 0},
+		{"enlarge=false", true, false, 0},
+		{"enlarge=yes", false, false, http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		query, _ := url.ParseQuery(c.query)
+		value, err := parseBoolParam(query, "enlarge", c.value)
+		if c.code != 0 {
+			if err == nil || errorCode(err) != c.code {
+				t.Errorf("%q: expected status %d, got %v", c.query, c.code, err)
+			}
+			continue
+		}
+		if err != nil || value != c.expected {
+			t.Errorf("%q: expected %t, got %t (%v)", c.query, c.expected, value, err)
+		}
+	}
+}
+
+func intParam(name string, min, max int) func(url.Values) (float64, error) {
+	return func(query url.Values) (float64, error) {
+		value, err := parseIntParam(query, name, min, max)
+		return float64(value), err
+	}
+}
+
+func floatParam(name string, min, max float64) func(url.Values) (float64, error) {
+	return func(query url.Values) (float64, error) {
+		return parseFloatParam(query, name, min, max)
+	}
+}
+
+func TestReadParamsErrors(t *testing.T) {
+	cases := []string{
+		"type=xyz",
+		"format=png",
+		"quality=101",
+		"speed=9",
+		"strip=maybe",
+		"encoding=hex",
+		"if=depth>8",
+		"disposition=download&filename=photo.jpg",
+	}
+
+	for _, query := range cases {
+		values, _ := url.ParseQuery(query)
+		opts := NewOptions(ServerOptions{}, "resize")
+		if err := readParams(values, &opts); err == nil || errorCode(err) != http.StatusBadRequest {
+			t.Errorf("%q: expected status %d, got %v", query, http.StatusBadRequest, err)
+		}
+	}
+}
+
+func TestApplyDPR(t *testing.T) {
+	o := ServerOptions{MaxDPR: 3, MaxOutputWidth: 2000, MaxOutputHeight: 1500}
+	cases := []struct {
+		width, height   int
+		dpr             float64
+		expectedWidth   int
+		expectedHeight  int
+		expectedClamped bool
+	}{
+		{300, 200, 0, 300, 200, false},
+		{300, 200, 2, 600, 400, false},
+		{300, 200, 5, 900, 600, false},
+		{300, 0, 1.5, 450, 0, false},
+		{800, 400, 3, 2000, 1000, true},
+		{400, 800, 2, 750, 1500, true},
+		{1000, 500, 0.5, 500, 250, false},
+	}
+
+	for _, c := range cases {
+		opts := Options{Width: c.width, Height: c.height, DPR: c.dpr}
+		applyDPR(&opts, o)
+		clamped := clampOutput(&opts, o)
+		if opts.Width != c.expectedWidth || opts.Height != c.expectedHeight || clamped != c.expectedClamped {
+			t.Errorf("%dx%d@%g: expected %dx%d (clamped %t), got %dx%d (clamped %t)", c.width, c.height, c.dpr,
+				c.expectedWidth, c.expectedHeight, c.expectedClamped, opts.Width, opts.Height, clamped)
+		}
+	}
+
+	// A NaN ratio would make the scaled dimensions undefined
+	for _, dpr := range []string{"NaN", "nan", "-Inf"} {
+		if _, err := parseDPR(url.Values{"dpr": {dpr}}); err == nil || errorCode(err) != http.StatusBadRequest {
+			t.Errorf("%s: expected the dpr to be rejected, got %v", dpr, err)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import "gopkg.in/h2non/bimg.v1"
+
+// unchanged reports whether the operation would output an image with the
+// same dimensions and type of the source, with no pixel transformation nor
+// encoding option, so the source bytes can be replied as is.
+// Images are always encoded again by default, since the encoding options
+// and the metadata stripping defaults apply to every output image.
+func unchanged(image []byte, opts Options) bool {
+	if opts.Operation != "resize" && opts.Operation != "crop" {
+		return false
+	}
+	if len(opts.Watermark.Image) > 0 || opts.Flatten || opts.QualityParam || opts.Compression != 0 || opts.Speed > 0 {
+		return false
+	}
+	if opts.WebP.Lossless || opts.WebP.custom() || opts.TIFF.custom() || opts.Palette.Enabled || opts.Subsample != "" || opts.Color.enabled() || opts.StripMetadata || opts.Interlace || opts.AutoQuality {
+		return false
+	}
+	if isDocument(image) {
+		return false
+	}
+
+	meta, err := bimg.Metadata(image)
+	if err != nil {
+		return false
+	}
+	if meta.Orientation > 1 && !opts.NoAutoRotate {
+		return false
+	}
+	if opts.ConvertSRGB && meta.Space != "srgb" {
+		return false
+	}
+	if opts.StripProfile && meta.Profile {
+		return false
+	}
+
+	plan, err := planImage(image, opts)
+	if err != nil {
+		return false
+	}
+	return plan.Width == meta.Size.Width && plan.Height == meta.Size.Height && plan.Type == bimg.ImageTypeName(bimg.DetermineImageType(image))
+}
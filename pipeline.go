@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/h2non/bimg.v1"
+	"net/http"
+	"net/url"
+)
+
+// PipelineStage defines a single image operation of a pipeline.
+// Params support the same values as the image operation query params,
+// plus width and height.
+type PipelineStage struct {
+	Operation string                 `json:"operation"`
+	Params    map[string]interface{} `json:"params"`
+}
+
+func pipelineController(o ServerOptions, sources []ImageSource, watermarks *WatermarkStore, queue *OpQueue) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		var stages []PipelineStage
+		body := http.MaxBytesReader(w, r.Body, maxPipelineBodySize(o))
+		if err := json.NewDecoder(body).Decode(&stages); err != nil {
+			writeError(w, jsonBodyError("pipeline", err))
+			return
+		}
+		if len(stages) == 0 {
+			badRequest(w, "pipeline requires at least one operation")
+			return
+		}
+		if len(stages) > o.MaxPipelineOps {
+			badRequest(w, fmt.Sprintf("pipeline exceeds the maximum of %d operations", o.MaxPipelineOps))
+			return
+		}
+
+		source := matchSource(sources, r)
+		image, err := source.GetImage(w, r, ps)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		metrics.AddBytesIn(len(image))
+
+		if err := validateImage(image, o); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		processing, cancel, err := newProcessing(r, o)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer cancel()
+		release, err := queue.Acquire(processing.ctx)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer processing.Release(release)
+
+		for i, stage := range stages {
+			var fallback string
+			image, fallback, err = runStage(processing, o, r, watermarks, image, stage)
+			if err != nil {
+				err = publicError(w, err)
+				writeError(w, NewError(fmt.Sprintf("pipeline stage %d (%s) failed: %s", i, stage.Operation, err), errorCode(err)))
+				return
+			}
+			if fallback != "" {
+				w.Header().Add(fallbackHeader, fallback)
+			}
+		}
+
+		writeImageStatus(w, image, http.StatusOK)
+	}
+}
+
+// runStage processes the stage bounded by the processing context.
+func runStage(processing *Processing, o ServerOptions, r *http.Request, watermarks *WatermarkStore, image []byte, stage PipelineStage) ([]byte, string, error) {
+	// The fallback is sent along, since abandoned stages complete in background
+	fallbacks := make(chan string, 1)
+	out, err := processing.Run(func() ([]byte, error) {
+		// The stage is bounded by the processing deadline
+		out, fallback, err := processStage(o, r.WithContext(processing.ctx), watermarks, image, stage)
+		fallbacks <- fallback
+		return out, err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return out, <-fallbacks, nil
+}
+
+// processStage applies a single operation to the image, returning the
+// followed output format fallback, if any.
+func processStage(o ServerOptions, r *http.Request, watermarks *WatermarkStore, image []byte, stage PipelineStage) ([]byte, string, error) {
+	if !isOperation(stage.Operation) || stage.Operation == "generate" {
+		return nil, "", NewError(fmt.Sprintf("unsupported operation: %s", stage.Operation), http.StatusBadRequest)
+	}
+	if !operationAllowed(o, stage.Operation) {
+		return nil, "", operationForbidden(stage.Operation)
+	}
+
+	params := url.Values{}
+	for key, value := range stage.Params {
+		params.Set(key, fmt.Sprint(value))
+	}
+
+	opts := NewOptions(o, stage.Operation)
+	var err error
+	if opts.Width, err = parseIntParam(params, "width", 0, bimg.MaxSize()); err != nil {
+		return nil, "", err
+	}
+	if opts.Height, err = parseIntParam(params, "height", 0, bimg.MaxSize()); err != nil {
+		return nil, "", err
+	}
+	if err := readParams(params, &opts); err != nil {
+		return nil, "", err
+	}
+	applyDPR(&opts, o)
+	clampOutput(&opts, o)
+	if !isDocument(image) {
+		if _, err := clampEnlarge(image, &opts); err != nil {
+			return nil, "", err
+		}
+	}
+	fallback, err := resolveOutputType(o, &opts)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := watermarks.Resolve(r, &opts); err != nil {
+		return nil, "", err
+	}
+
+	if deadline, ok := r.Context().Deadline(); ok {
+		opts.Deadline = deadline
+	}
+	image, err = Resize(image, opts)
+	return image, fallback, err
+}
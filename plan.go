@@ -0,0 +1,133 @@
+package main
+
+import (
+	"gopkg.in/h2non/bimg.v1"
+	"math"
+)
+
+// Plan is the dry run reply, with the output image dimensions and type.
+type Plan struct {
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Type   string `json:"type"`
+}
+
+// planImage computes the output of Resize from the image header only,
+// following the same bimg geometry rules, with no pixel processing.
+// The placeholder operation without a type replies JSON, with no size.
+func planImage(image []byte, opts Options) (Plan, error) {
+	meta, err := bimg.Metadata(image)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	kind := opts.Type
+	if kind == bimg.UNKNOWN {
+		kind = bimg.DetermineImageType(image)
+	}
+	plan := Plan{Type: bimg.ImageTypeName(kind)}
+
+	if (opts.Operation == "resize" || opts.Operation == "crop") && isAnimated(image) && animatedType(image, opts) != bimg.UNKNOWN {
+		// Frames are fitted with no auto rotation nor crop
+		plan.Width, plan.Height = fitSize(meta.Size.Width, meta.Size.Height, opts.Width, opts.Height, false, false)
+		return plan, nil
+	}
+
+	width, height := orientedSize(meta, opts.NoAutoRotate)
+	crop := opts.Operation == "crop" || opts.Operation == "resize"
+
+	switch opts.Operation {
+	case "placeholder":
+		if opts.Type == bimg.UNKNOWN {
+			return Plan{Type: "json"}, nil
+		}
+		plan.Width, plan.Height = opts.Width, opts.Height
+		if plan.Width == 0 {
+			plan.Width = 1
+		}
+		if plan.Height == 0 {
+			plan.Height = plan.Width
+		}
+	case "embed":
+		plan.Width, plan.Height = opts.Width, opts.Height
+	case "thumbnail":
+		plan.Width, plan.Height = thumbnailSize(width, height, opts)
+	case "extract", "trim":
+		_, _, width, height, err = extractArea(meta, opts.NoAutoRotate, opts.Region)
+		if err != nil {
+			return Plan{}, err
+		}
+		plan.Width, plan.Height = fitSize(width, height, opts.Width, opts.Height, false, opts.Force)
+	case "rotate":
+		// bimg rotates right angles before resizing
+		if math.Mod(opts.Angle, 180) == 90 {
+			width, height = height, width
+		}
+		plan.Width, plan.Height = fitSize(width, height, opts.Width, opts.Height, false, opts.Force)
+		if math.Mod(opts.Angle, 90) != 0 {
+			plan.Width, plan.Height = rotatedSize(plan.Width, plan.Height, opts.Angle)
+		}
+	default:
+		if opts.contains() {
+			plan.Width, plan.Height = opts.Width, opts.Height
+			break
+		}
+		w, h, crop, force := fitGeometry(opts.Fit, width, height, opts.Width, opts.Height, crop, opts.Force)
+		plan.Width, plan.Height = fitSize(width, height, w, h, crop, force)
+	}
+	return plan, nil
+}
+
+// fitSize mirrors the bimg size calculation, always enlarging. Cropping keeps
+// the input size on an undefined dimension, as bimg does.
+func fitSize(inWidth, inHeight, width, height int, crop, force bool) (int, int) {
+	xfactor := float64(inWidth) / float64(width)
+	yfactor := float64(inHeight) / float64(height)
+	factor := 1.0
+
+	switch {
+	case width > 0 && height > 0:
+		if crop {
+			factor = math.Min(xfactor, yfactor)
+		} else {
+			factor = math.Max(xfactor, yfactor)
+		}
+	case width > 0:
+		if crop {
+			height = inHeight
+		} else {
+			factor = xfactor
+			height = int(math.Round(float64(inHeight) / factor))
+		}
+	case height > 0:
+		if crop {
+			width = inWidth
+		} else {
+			factor = yfactor
+			width = int(math.Round(float64(inWidth) / factor))
+		}
+	default:
+		return inWidth, inHeight
+	}
+
+	if force {
+		return width, height
+	}
+
+	outWidth := int(math.Round(float64(inWidth) / factor))
+	outHeight := int(math.Round(float64(inHeight) / factor))
+	if crop {
+		outWidth = int(math.Min(float64(outWidth), float64(width)))
+		outHeight = int(math.Min(float64(outHeight), float64(height)))
+	}
+	return outWidth, outHeight
+}
+
+// rotatedSize returns the bounding box of the image rotated by any angle.
+func rotatedSize(width, height int, angle float64) (int, int) {
+	rad := angle * math.Pi / 180
+	sin, cos := math.Abs(math.Sin(rad)), math.Abs(math.Cos(rad))
+	w := float64(width)*cos + float64(height)*sin
+	h := float64(width)*sin + float64(height)*cos
+	return int(math.Ceil(w - 1e-6)), int(math.Ceil(h - 1e-6))
+}
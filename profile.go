@@ -0,0 +1,59 @@
+package main
+
+import (
+	"gopkg.in/h2non/bimg.v1"
+	"sync"
+)
+
+// Built-in libvips ICC profile names, available since libvips 8.10.
+const (
+	srgbProfile = "srgb"
+	cmykProfile = "cmyk"
+)
+
+var builtinProfiles = bimg.VipsMajorVersion > 8 || (bimg.VipsMajorVersion == 8 && bimg.VipsMinorVersion >= 10)
+
+var builtinProfilesWarning sync.Once
+
+// convertSRGB transforms the output image to sRGB using its embedded ICC
+// profile, embedding the sRGB profile in the output. CMYK images with no
+// embedded profile are transformed with the default CMYK profile, since the
+// plain colourspace conversion may output inverted colors.
+// Older libvips versions, with no built-in profiles, only convert the
+// colourspace to sRGB, dropping the source profile, which no longer applies.
+func convertSRGB(image []byte, params *bimg.Options) {
+	if !builtinProfiles {
+		builtinProfilesWarning.Do(func() {
+			debug("warning: sRGB profile conversion requires libvips >= 8.10, converting the colourspace only")
+		})
+		params.Interpretation = bimg.InterpretationSRGB
+		params.NoProfile = true
+		return
+	}
+
+	params.OutputICC = srgbProfile
+	if meta, err := bimg.Metadata(image); err == nil && meta.Space == "cmyk" {
+		params.InputICC = cmykProfile
+	}
+
+	// The profile is required for the transform, so it is stripped later
+	params.NoProfile = false
+}
+
+// normalizeOrientation rotates the image based on its EXIF orientation and
+// resets the orientation tag, so viewers do not rotate it again when the
+// output metadata is preserved. It costs an extra encoding pass, only done
+// for images with a non default orientation.
+func normalizeOrientation(image []byte) ([]byte, error) {
+	meta, err := bimg.Metadata(image)
+	if err != nil || meta.Orientation <= 1 {
+		return image, nil
+	}
+	return bimg.NewImage(image).AutoRotate()
+}
+
+// stripProfile removes the ICC profile of an image already transformed to sRGB.
+func stripProfile(image []byte, params bimg.Options) ([]byte, error) {
+	params.NoProfile = true
+	return encode(image, params)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/h2non/bimg.v1"
+	"net/http"
+)
+
+// QualityDefaults are the output quality defaults per image type,
+// applied when the request defines no quality.
+type QualityDefaults struct {
+	JPEG           int `yaml:"jpeg"`
+	WEBP           int `yaml:"webp"`
+	AVIF           int `yaml:"avif"`
+	PNGCompression int `yaml:"pngCompression"`
+}
+
+// quality returns the default quality of the image type, or zero to use the bimg default.
+func (d QualityDefaults) quality(kind bimg.ImageType) int {
+	switch kind {
+	case bimg.JPEG, bimg.TIFF:
+		// TIFF images only use the quality with the JPEG compression
+		return d.JPEG
+	case bimg.WEBP:
+		return d.WEBP
+	case bimg.AVIF:
+		return d.AVIF
+	}
+	return 0
+}
+
+// pngCompressionNone is the Compression of the PNG level 0, since bimg
+// replaces a zero compression with its default. These images are encoded
+// by savePNG instead.
+const pngCompressionNone = -1
+
+// pngCompression returns the Compression of the PNG compression level.
+func pngCompression(level int) int {
+	if level == 0 {
+		return pngCompressionNone
+	}
+	return level
+}
+
+// pngLevel returns the PNG compression level of the Compression.
+func pngLevel(compression int) int {
+	if compression == pngCompressionNone {
+		return 0
+	}
+	return compression
+}
+
+// applyQuality checks the quality param against the output type, else
+// applies its default. The PNG images take the quality param as their
+// compression level, between 0 and 9.
+func applyQuality(kind bimg.ImageType, opts *Options) error {
+	if opts.QualityParam {
+		opts.QualityParam = false
+		if kind == bimg.PNG {
+			if opts.Quality > 9 {
+				return NewError("invalid quality param: must be between 0 and 9 for PNG images", http.StatusBadRequest)
+			}
+			opts.Quality, opts.Compression = 0, pngCompression(opts.Quality)
+		} else if opts.Quality == 0 {
+			return NewError("invalid quality param: must be between 1 and 100", http.StatusBadRequest)
+		}
+	}
+	if opts.Quality == 0 {
+		opts.Quality = opts.Defaults.quality(kind)
+	}
+	if opts.Compression == 0 && kind == bimg.PNG {
+		opts.Compression = pngCompression(opts.Defaults.PNGCompression)
+	}
+	return nil
+}
+
+// Validate checks the defaults are within the encoders ranges.
+func (d QualityDefaults) Validate() error {
+	for name, value := range map[string]int{"jpeg": d.JPEG, "webp": d.WEBP, "avif": d.AVIF} {
+		if value < 1 || value > 100 {
+			return fmt.Errorf("invalid %s quality: must be between 1 and 100", name)
+		}
+	}
+	if d.PNGCompression < 0 || d.PNGCompression > 9 {
+		return fmt.Errorf("invalid png compression: must be between 0 and 9")
+	}
+	return nil
+}
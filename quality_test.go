@@ -0,0 +1,75 @@
+package main
+
+import (
+	"gopkg.in/h2non/bimg.v1"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestApplyQuality(t *testing.T) {
+	defaults := QualityDefaults{JPEG: 82, WEBP: 80, AVIF: 50, PNGCompression: 6}
+	cases := []struct {
+		query       string
+		kind        bimg.ImageType
+		quality     int
+		compression int
+		code        int
+	}{
+		{"", bimg.JPEG, 82, 0, 0},
+		{"", bimg.WEBP, 80, 0, 0},
+		{"", bimg.AVIF, 50, 0, 0},
+		{"", bimg.PNG, 0, 6, 0},
+		{"quality=90", bimg.JPEG, 90, 0, 0},
+		{"quality=90", bimg.WEBP, 90, 0, 0},
+		{"quality=3", bimg.PNG, 0, 3, 0},
+		{"quality=0", bimg.PNG, 0, pngCompressionNone, 0},
+		{"quality=9", bimg.PNG, 0, 9, 0},
+		{"quality=10", bimg.PNG, 0, 0, http.StatusBadRequest},
+		{"quality=0", bimg.JPEG, 0, 0, http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		query, _ := url.ParseQuery(c.query)
+		opts := NewOptions(ServerOptions{Quality: defaults}, "resize")
+		if err := readParams(query, &opts); err != nil {
+			t.Fatalf("%s: unexpected error: %s", c.query, err)
+		}
+
+		err := applyQuality(c.kind, &opts)
+		if c.code != 0 {
+			if err == nil || errorCode(err) != c.code {
+				t.Errorf("%s (%s): expected status %d, got %v", c.query, bimg.ImageTypeName(c.kind), c.code, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s (%s): unexpected error: %s", c.query, bimg.ImageTypeName(c.kind), err)
+			continue
+		}
+		if opts.Quality != c.quality || opts.Compression != c.compression {
+			t.Errorf("%s (%s): expected quality %d and compression %d, got %d and %d",
+				c.query, bimg.ImageTypeName(c.kind), c.quality, c.compression, opts.Quality, opts.Compression)
+		}
+	}
+}
+
+func TestQualityDefaultsValidate(t *testing.T) {
+	cases := []struct {
+		defaults QualityDefaults
+		valid    bool
+	}{
+		{QualityDefaults{JPEG: 82, WEBP: 80, AVIF: 50, PNGCompression: 6}, true},
+		{QualityDefaults{JPEG: 1, WEBP: 100, AVIF: 100, PNGCompression: 0}, true},
+		{QualityDefaults{JPEG: 0, WEBP: 80, AVIF: 50, PNGCompression: 6}, false},
+		{QualityDefaults{JPEG: 82, WEBP: 101, AVIF: 50, PNGCompression: 6}, false},
+		{QualityDefaults{JPEG: 82, WEBP: 80, AVIF: 50, PNGCompression: 10}, false},
+		{QualityDefaults{JPEG: 82, WEBP: 80, AVIF: 50, PNGCompression: -1}, false},
+	}
+
+	for _, c := range cases {
+		if err := c.defaults.Validate(); (err == nil) != c.valid {
+			t.Errorf("%+v: expected valid %t, got %v", c.defaults, c.valid, err)
+		}
+	}
+}
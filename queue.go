@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"golang.org/x/sync/semaphore"
+	"net/http"
+	"time"
+)
+
+// OpQueue limits the number of simultaneous image processing operations.
+// Requests over the limit wait for a free slot up to the queue timeout.
+type OpQueue struct {
+	sem     *semaphore.Weighted
+	timeout time.Duration
+}
+
+// NewOpQueue returns nil when max is zero, which disables the limit.
+func NewOpQueue(max int, timeout time.Duration) *OpQueue {
+	if max <= 0 {
+		return nil
+	}
+	return &OpQueue{sem: semaphore.NewWeighted(int64(max)), timeout: timeout}
+}
+
+// Acquire blocks until a processing slot is available and returns
+// the function to release it.
+func (q *OpQueue) Acquire(ctx context.Context) (func(), error) {
+	if q == nil {
+		return func() {}, nil
+	}
+
+	if q.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.timeout)
+		defer cancel()
+	}
+
+	if err := q.sem.Acquire(ctx, 1); err != nil {
+		metrics.IncThrottled()
+		return nil, NewError("timeout waiting for a processing slot", http.StatusServiceUnavailable)
+	}
+	return func() { q.sem.Release(1) }, nil
+}
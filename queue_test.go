@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOpQueueDisabled(t *testing.T) {
+	if NewOpQueue(0, time.Second) != nil {
+		t.Fatal("expected no queue without a limit")
+	}
+	var queue *OpQueue
+	release, err := queue.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestOpQueueWaitOrder(t *testing.T) {
+	queue := NewOpQueue(1, time.Second)
+	release, err := queue.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The waiters are served in their arrival order as the slot is released
+	order := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			release, err := queue.Acquire(context.Background())
+			if err != nil {
+				t.Errorf("waiter %d: %s", i, err)
+				order <- -1
+				return
+			}
+			order <- i
+			release()
+		}(i)
+		time.Sleep(10 * time.Millisecond)
+	}
+	release()
+
+	for expected := 0; expected < 3; expected++ {
+		if i := <-order; i != expected {
+			t.Errorf("expected waiter %d to acquire the slot, got %d", expected, i)
+		}
+	}
+}
+
+func TestOpQueueTimeout(t *testing.T) {
+	queue := NewOpQueue(1, 20*time.Millisecond)
+	release, err := queue.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	throttled := atomic.LoadUint64(&metrics.Throttled)
+	start := time.Now()
+	if _, err := queue.Acquire(context.Background()); err == nil || errorCode(err) != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d on a full queue, got %v", http.StatusServiceUnavailable, err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond || elapsed > time.Second {
+		t.Errorf("expected to wait for the queue timeout, waited %s", elapsed)
+	}
+	if atomic.LoadUint64(&metrics.Throttled) != throttled+1 {
+		t.Error("expected the rejection to be counted")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"golang.org/x/time/rate"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IPLimiter throttles requests with a token bucket per client IP, filled
+// with limit tokens per window.
+type IPLimiter struct {
+	mutex      sync.Mutex
+	limit      int
+	window     time.Duration
+	trustProxy bool
+	clients    map[string]*ipClient
+	stop       chan struct{}
+}
+
+type ipClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewIPLimiter returns nil if limit is zero or negative.
+func NewIPLimiter(limit int, window time.Duration, trustProxy bool) *IPLimiter {
+	if limit <= 0 || window <= 0 {
+		return nil
+	}
+	l := &IPLimiter{
+		limit:      limit,
+		window:     window,
+		trustProxy: trustProxy,
+		clients:    map[string]*ipClient{},
+		stop:       make(chan struct{}),
+	}
+	go l.cleanup()
+	return l
+}
+
+// Allow reports whether the client may proceed, or the time to wait.
+func (l *IPLimiter) Allow(ip string) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	client, ok := l.clients[ip]
+	if !ok {
+		every := l.window / time.Duration(l.limit)
+		client = &ipClient{limiter: rate.NewLimiter(rate.Every(every), l.limit)}
+		l.clients[ip] = client
+	}
+	client.lastSeen = time.Now()
+
+	reservation := client.limiter.Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		return false, delay
+	}
+	return true, 0
+}
+
+// evict drops the clients idle for a whole window, whose buckets
+// would be full again anyway.
+func (l *IPLimiter) evict(now time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for ip, client := range l.clients {
+		if now.Sub(client.lastSeen) >= l.window {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+func (l *IPLimiter) cleanup() {
+	ticker := time.NewTicker(l.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			l.evict(now)
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// Stop ends the eviction of the idle clients.
+func (l *IPLimiter) Stop() {
+	close(l.stop)
+}
+
+// clientIP returns the request client IP. Proxy headers are only
+// honored when the proxy is trusted, since clients can forge them.
+func (l *IPLimiter) clientIP(r *http.Request) string {
+	if l.trustProxy {
+		if forwarded := lastHop(r.Header.Get("X-Forwarded-For")); forwarded != "" {
+			return forwarded
+		}
+		if real := r.Header.Get("X-Real-IP"); real != "" {
+			return strings.TrimSpace(real)
+		}
+	}
+	return remoteIP(r)
+}
+
+// lastHop returns the rightmost entry of a comma separated proxy header.
+// It is the one appended by the trusted proxy: any entry before it was
+// sent by the client and can be forged.
+func lastHop(header string) string {
+	hops := strings.Split(header, ",")
+	return strings.TrimSpace(hops[len(hops)-1])
+}
+
+// unthrottled reports whether the request is never rate limited: health
+// checks, stats, the landing page and the favicon.
+func unthrottled(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/health") || r.URL.Path == "/" || r.URL.Path == "/favicon.ico" || r.URL.Path == "/stats"
+}
+
+// withIPLimit replies 429 to the clients over the limit.
+func withIPLimit(l *IPLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unthrottled(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if ok, delay := l.Allow(l.clientIP(r)); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			writeError(w, NewError("rate limit exceeded", http.StatusTooManyRequests))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withThrottle replies 429 to the requests over the -concurrency limit per
+// second of every client, allowing bursts of up to -burst requests.
+func withThrottle(concurrency, burst int, next http.Handler) http.Handler {
+	if burst < 1 {
+		burst = concurrency
+	}
+	limiter := rate.NewLimiter(rate.Limit(concurrency), burst)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unthrottled(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reservation := limiter.Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			reservation.Cancel()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			writeError(w, NewError("throttle limit exceeded", http.StatusTooManyRequests))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
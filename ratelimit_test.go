@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIPLimiterClientIP(t *testing.T) {
+	cases := []struct {
+		name       string
+		trustProxy bool
+		forwarded  string
+		realIP     string
+		expected   string
+	}{
+		{"remote address", false, "", "", "10.0.0.1"},
+		{"untrusted proxy headers", false, "1.2.3.4", "5.6.7.8", "10.0.0.1"},
+		{"single hop", true, "1.2.3.4", "", "1.2.3.4"},
+		{"spoofed leftmost hop", true, "6.6.6.6, 1.2.3.4", "", "1.2.3.4"},
+		{"spoofed hops without spaces", true, "6.6.6.6,7.7.7.7,1.2.3.4", "", "1.2.3.4"},
+		{"real ip", true, "", "5.6.7.8", "5.6.7.8"},
+	}
+
+	for _, c := range cases {
+		l := NewIPLimiter(1, time.Minute, c.trustProxy)
+		r := httptest.NewRequest("GET", "/resize", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		if c.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if c.realIP != "" {
+			r.Header.Set("X-Real-IP", c.realIP)
+		}
+		if ip := l.clientIP(r); ip != c.expected {
+			t.Errorf("%s: expected client IP %q, got %q", c.name, c.expected, ip)
+		}
+		l.Stop()
+	}
+}
+
+func TestIPLimiterSpoofedForwardedFor(t *testing.T) {
+	l := NewIPLimiter(1, time.Minute, true)
+	defer l.Stop()
+	handler := withIPLimit(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i, spoofed := range []string{"6.6.6.1", "6.6.6.2", "6.6.6.3"} {
+		r := httptest.NewRequest("GET", "/resize", nil)
+		r.Header.Set("X-Forwarded-For", spoofed+", 1.2.3.4")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		expected := http.StatusOK
+		if i > 0 {
+			expected = http.StatusTooManyRequests
+		}
+		if w.Code != expected {
+			t.Errorf("request %d: expected status %d, got %d", i, expected, w.Code)
+		}
+		if i > 0 && w.Header().Get("Retry-After") == "" {
+			t.Errorf("request %d: missing Retry-After header", i)
+		}
+	}
+}
+
+func TestIPLimiterEvict(t *testing.T) {
+	l := NewIPLimiter(1, time.Minute, false)
+	defer l.Stop()
+
+	if ok, _ := l.Allow("1.2.3.4"); !ok {
+		t.Fatal("expected the first request to be allowed")
+	}
+	if ok, _ := l.Allow("1.2.3.4"); ok {
+		t.Fatal("expected the second request to be throttled")
+	}
+	l.evict(time.Now().Add(time.Minute))
+	if ok, _ := l.Allow("1.2.3.4"); !ok {
+		t.Error("expected the request to be allowed after the eviction")
+	}
+}
+
+func TestIPLimiterAllow(t *testing.T) {
+	l := NewIPLimiter(2, time.Minute, false)
+	defer l.Stop()
+
+	cases := []struct {
+		ip       string
+		expected bool
+	}{
+		{"1.2.3.4", true},
+		{"1.2.3.4", true},
+		{"1.2.3.4", false},
+		{"5.6.7.8", true},
+		{"5.6.7.8", true},
+		{"1.2.3.4", false},
+		{"5.6.7.8", false},
+	}
+
+	for i, c := range cases {
+		ok, delay := l.Allow(c.ip)
+		if ok != c.expected {
+			t.Errorf("request %d from %s: expected allowed %t, got %t", i, c.ip, c.expected, ok)
+		}
+		if !ok && (delay <= 0 || delay > 30*time.Second) {
+			t.Errorf("request %d from %s: unexpected delay %s", i, c.ip, delay)
+		}
+	}
+}
+
+func TestWithThrottle(t *testing.T) {
+	handler := withThrottle(1, 2, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	cases := []struct {
+		path     string
+		expected int
+	}{
+		{"/resize/300x/image.jpg", http.StatusOK},
+		{"/info", http.StatusOK},
+		{"/resize/300x/image.jpg", http.StatusTooManyRequests},
+		{"/health", http.StatusOK},
+		{"/health/ready", http.StatusOK},
+		{"/stats", http.StatusOK},
+		{"/crop/300x/image.jpg", http.StatusTooManyRequests},
+	}
+
+	for i, c := range cases {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", c.path, nil))
+		if w.Code != c.expected {
+			t.Errorf("request %d to %s: expected status %d, got %d", i, c.path, c.expected, w.Code)
+		}
+		if c.expected == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "1" {
+			t.Errorf("request %d to %s: expected Retry-After 1, got %q", i, c.path, w.Header().Get("Retry-After"))
+		}
+	}
+}
@@ -2,13 +2,117 @@ package main
 
 import (
 	"errors"
-	"gopkg.in/h2non/bimg.v0"
+	"gopkg.in/h2non/bimg.v1"
+	"sync"
+	"time"
 )
 
+// libvips smart crop is available since 8.5
+var smartCropSupported = bimg.VipsMajorVersion > 8 || (bimg.VipsMajorVersion == 8 && bimg.VipsMinorVersion >= 5)
+
+var smartCropWarning sync.Once
+
+var operations = map[string]bool{
+	"crop":        true,
+	"resize":      true,
+	"watermark":   true,
+	"blur":        true,
+	"sharpen":     true,
+	"rotate":      true,
+	"embed":       true,
+	"extract":     true,
+	"trim":        true,
+	"placeholder": true,
+	"thumbnail":   true,
+	"generate":    true,
+	"convert":     true,
+}
+
+func isOperation(name string) bool {
+	return operations[name]
+}
+
 type Options struct {
-	Width, Height int
-	Force         bool
-	Operation     string
+	Width, Height     int
+	DPR               float64
+	Quality           int
+	QualityParam      bool
+	AutoQuality       bool
+	AutoQualityTarget float64
+	Deadline          time.Time
+	Compression       int
+	Defaults          QualityDefaults
+	Speed             int
+	WebP              WebPOptions
+	TIFF              TIFFOptions
+	Palette           PaletteOptions
+	Subsample         string
+	Force             bool
+	ForceEncode       bool
+	Enlarge           bool
+	Fit               string
+	NoAutoRotate      bool
+	Interlace         bool
+	StripProfile      bool
+	StripMetadata     bool
+	ConvertSRGB       bool
+	Operation         string
+	DryRun            bool
+	Debug             bool
+	OnError           string
+	Condition         *Condition
+	DataURI           bool
+	Filename          string
+	Disposition       string
+	Page              int
+	DPI               float64
+	Flatten           bool
+	Type              bimg.ImageType
+	KeepFormat        bool
+	Gravity           bimg.Gravity
+	CropCorner        string
+	EmbedGravity      string
+	ThumbnailCrop     string
+	ThumbnailSize     string
+	Watermark         WatermarkOptions
+	Generate          GenerateOptions
+	Blur              bimg.GaussianBlur
+	Sharpen           bimg.Sharpen
+	Color             ColorFilter
+	Angle             float64
+	Region            Region
+	Threshold         float64
+	BlurHash          bool
+	Background        *Color
+	AutoBackground    bool
+	DefaultBackground *Color
+	MaxFrames         int
+	Limits            ImageLimits
+}
+
+// NewOptions returns the image operation options with the server defaults.
+func NewOptions(o ServerOptions, operation string) Options {
+	opts := Options{
+		Operation:         operation,
+		NoAutoRotate:      !o.AutoRotate,
+		Interlace:         o.Interlace,
+		StripProfile:      o.StripProfile,
+		StripMetadata:     o.StripMetadata,
+		ConvertSRGB:       o.ConvertSRGB,
+		Enlarge:           !o.NoEnlarge,
+		MaxFrames:         o.MaxAnimationFrames,
+		Limits:            imageLimits(o),
+		AutoQualityTarget: o.AutoQualityTarget,
+		Defaults:          o.Quality,
+		WebP:              WebPOptions{NearLossless: -1, Effort: -1},
+	}
+	if o.DefaultBackground != "" {
+		// Validated by NewServerMux
+		if color, err := parseColor(o.DefaultBackground); err == nil {
+			opts.DefaultBackground = &color
+		}
+	}
+	return opts
 }
 
 func Resize(image []byte, opts Options) (buf []byte, err error) {
@@ -26,15 +130,231 @@ func Resize(image []byte, opts Options) (buf []byte, err error) {
 		}
 	}()
 
+	if opts.Operation == "convert" {
+		// Only encodes the image, keeping its dimensions
+		opts.Width, opts.Height, opts.Force, opts.Fit, opts.CropCorner = 0, 0, false, "", ""
+	}
+
+	// Pipeline and batch stages render documents here
+	if image, err = renderDocument(image, opts); err != nil {
+		return nil, err
+	}
+	if opts.AutoBackground {
+		background, err := edgeColor(image, opts.NoAutoRotate)
+		if err != nil {
+			return nil, err
+		}
+		opts.Background, opts.AutoBackground = &background, false
+	}
+
+	if (opts.Operation == "resize" || opts.Operation == "crop") && isAnimated(image) {
+		if kind := animatedType(image, opts); kind != bimg.UNKNOWN {
+			return resizeAnimated(image, kind, opts)
+		}
+	}
+
+	if opts.Gravity == bimg.GravitySmart && !smartCropSupported {
+		smartCropWarning.Do(func() {
+			debug("warning: smart crop requires libvips >= 8.5, using centre gravity")
+		})
+		opts.Gravity = bimg.GravityCentre
+	}
+
+	kind := opts.Type
+	if kind == bimg.UNKNOWN {
+		kind = bimg.DetermineImageType(image)
+	}
+	if err := applyQuality(kind, &opts); err != nil {
+		return nil, err
+	}
+
+	if err := checkSubsample(kind, opts); err != nil {
+		return nil, err
+	}
+	if opts.AutoQuality && autoQualityTypes[kind] {
+		return autoQuality(image, kind, opts)
+	}
+
+	// Process losslessly first, then encode with the options bimg lacks
+	if save := customEncoder(kind, opts); save != nil {
+		opts.Flatten = opts.Flatten && !flattensBlack(kind, opts)
+		opts.Type, opts.Compression = bimg.PNG, 1
+		opts.WebP, opts.TIFF, opts.Palette = WebPOptions{NearLossless: -1, Effort: -1}, TIFFOptions{}, PaletteOptions{}
+		opts.Color, opts.Subsample = ColorFilter{}, ""
+		if image, err = Resize(image, opts); err != nil {
+			return nil, err
+		}
+		return save(image)
+	}
+
 	params := bimg.Options{
-		Enlarge: true,
-		Width:   opts.Width,
-		Height:  opts.Height,
-		Force:   opts.Force,
-		Crop:    opts.Operation == "crop" || opts.Operation == "resize",
+		Enlarge:       true,
+		Width:         opts.Width,
+		Height:        opts.Height,
+		Force:         opts.Force,
+		Crop:          opts.Operation == "crop" || opts.Operation == "resize",
+		Type:          opts.Type,
+		Quality:       opts.Quality,
+		Compression:   opts.Compression,
+		Speed:         opts.Speed,
+		Gravity:       opts.Gravity,
+		NoAutoRotate:  opts.NoAutoRotate,
+		GaussianBlur:  opts.Blur,
+		Sharpen:       opts.Sharpen,
+		Interlace:     opts.Interlace,
+		NoProfile:     opts.StripProfile,
+		StripMetadata: opts.StripMetadata,
+		Lossless:      opts.WebP.Lossless && kind == bimg.WEBP,
+		Background:    flattenBackground(kind, opts),
 	}
 
-	return bimg.Resize(image, params)
+	if !opts.StripMetadata && !opts.NoAutoRotate {
+		if image, err = normalizeOrientation(image); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.ConvertSRGB {
+		convertSRGB(image, &params)
+	}
+
+	if opts.Fit != "" {
+		meta, err := bimg.Metadata(image)
+		if err != nil {
+			return nil, err
+		}
+		width, height := orientedSize(meta, opts.NoAutoRotate)
+		params.Width, params.Height, params.Crop, params.Force = fitGeometry(opts.Fit, width, height, opts.Width, opts.Height, params.Crop, params.Force)
+	}
+
+	final := params
+	if final.Type == bimg.UNKNOWN {
+		final.Type = bimg.DetermineImageType(image)
+	}
+
+	operation := opts.Operation
+	if opts.contains() {
+		operation = "embed"
+	}
+
+	// With a watermark, the operation is processed losslessly first, since
+	// the watermark requires the output size, and the watermark composited last
+	watermarked := len(opts.Watermark.Image) > 0
+	if watermarked {
+		params.Type = bimg.PNG
+	}
+	switch operation {
+	case "rotate":
+		image, err = rotate(image, params, opts)
+	case "embed":
+		image, err = embed(image, params, opts)
+	case "extract":
+		image, err = extract(image, params, opts.Region)
+	case "trim":
+		image, err = trim(image, params, opts)
+	case "placeholder":
+		image, err = solidPlaceholder(image, params)
+	case "thumbnail":
+		image, err = thumbnail(image, params, opts)
+	default:
+		image, err = resizeImage(image, params, opts)
+	}
+	if err == nil && opts.ConvertSRGB && opts.StripProfile {
+		if watermarked {
+			image, err = stripProfile(image, params)
+		} else {
+			image, err = stripProfile(image, final)
+		}
+	}
+	if err != nil || !watermarked {
+		return image, err
+	}
+	return applyWatermark(image, final, opts.Watermark)
+}
+
+// encode saves an already processed image with the output params.
+func encode(image []byte, params bimg.Options) ([]byte, error) {
+	return bimg.Resize(image, bimg.Options{
+		Type:          params.Type,
+		Quality:       params.Quality,
+		Compression:   params.Compression,
+		Speed:         params.Speed,
+		Interlace:     params.Interlace,
+		NoProfile:     params.NoProfile,
+		StripMetadata: params.StripMetadata,
+		Lossless:      params.Lossless,
+		NoAutoRotate:  true,
+	})
+}
+
+// customEncoder returns the libvips encoder for the output options
+// not supported by bimg, or nil.
+func customEncoder(kind bimg.ImageType, opts Options) func([]byte) ([]byte, error) {
+	var save func([]byte) ([]byte, error)
+	switch {
+	case kind == bimg.WEBP && opts.WebP.custom():
+		save = func(image []byte) ([]byte, error) {
+			return saveWebP(image, opts.Quality, opts.WebP)
+		}
+	case kind == bimg.TIFF && opts.TIFF.custom():
+		save = func(image []byte) ([]byte, error) {
+			return saveTIFF(image, opts.Quality, opts.TIFF)
+		}
+	case kind == bimg.PNG && (opts.Palette.Enabled || opts.Compression == pngCompressionNone):
+		// The options are reset to process losslessly
+		compression, palette := pngLevel(opts.Compression), opts.Palette
+		save = func(image []byte) ([]byte, error) {
+			return savePNG(image, compression, opts.Interlace, opts.StripMetadata, palette)
+		}
+	case (kind == bimg.JPEG || kind == bimg.AVIF) && opts.Subsample != "":
+		save = func(image []byte) ([]byte, error) {
+			return saveSubsampled(image, kind, opts.Quality, opts.Speed, opts.Interlace, opts.StripMetadata, opts.Subsample)
+		}
+	}
+
+	if flattensBlack(kind, opts) {
+		next := save
+		if next == nil {
+			next = defaultEncoder(kind, opts)
+		}
+		save = func(image []byte) ([]byte, error) {
+			image, err := flattenBlack(image)
+			if err != nil {
+				return nil, err
+			}
+			return next(image)
+		}
+	}
+	if opts.Color.enabled() {
+		next, filter := save, opts.Color
+		if next == nil {
+			next = defaultEncoder(kind, opts)
+		}
+		save = func(image []byte) ([]byte, error) {
+			image, err := colorFilter(image, filter)
+			if err != nil {
+				return nil, err
+			}
+			return next(image)
+		}
+	}
+	return save
+}
+
+// defaultEncoder returns the bimg encoder for the output options.
+func defaultEncoder(kind bimg.ImageType, opts Options) func([]byte) ([]byte, error) {
+	return func(image []byte) ([]byte, error) {
+		return encode(image, bimg.Options{
+			Type:          kind,
+			Quality:       opts.Quality,
+			Compression:   opts.Compression,
+			Speed:         opts.Speed,
+			Interlace:     opts.Interlace,
+			NoProfile:     opts.StripProfile,
+			StripMetadata: opts.StripMetadata,
+			Lossless:      opts.WebP.Lossless && kind == bimg.WEBP,
+		})
+	}
 }
 
 func GetImageMimeType(code bimg.ImageType) string {
@@ -44,5 +364,17 @@ func GetImageMimeType(code bimg.ImageType) string {
 	if code == bimg.WEBP {
 		return "image/webp"
 	}
+	if code == bimg.AVIF {
+		return "image/avif"
+	}
+	if code == bimg.HEIF {
+		return "image/heif"
+	}
+	if code == bimg.GIF {
+		return "image/gif"
+	}
+	if code == bimg.TIFF {
+		return "image/tiff"
+	}
 	return "image/jpeg"
 }
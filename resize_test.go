@@ -0,0 +1,183 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"gopkg.in/h2non/bimg.v1"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+	"testing"
+)
+
+var (
+	fixtureRed   = color.RGBA{255, 0, 0, 255}
+	fixtureWhite = color.RGBA{255, 255, 255, 255}
+)
+
+// isRed reports whether the pixel is close to red, allowing for the
+// JPEG artifacts.
+func isRed(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r>>8 > 200 && g>>8 < 80 && b>>8 < 80
+}
+
+// orientedFixture returns a JPEG tagged with the EXIF orientation whose
+// upright image is width x height, red in its top left quadrant and white
+// elsewhere. The stored pixels are transformed by the inverse orientation.
+func orientedFixture(t *testing.T, orientation, width, height int) []byte {
+	storedWidth, storedHeight := width, height
+	if orientation >= 5 {
+		storedWidth, storedHeight = height, width
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, storedWidth, storedHeight))
+	for sy := 0; sy < storedHeight; sy++ {
+		for sx := 0; sx < storedWidth; sx++ {
+			x, y := uprightPoint(orientation, sx, sy, storedWidth, storedHeight)
+			if x < width/2 && y < height/2 {
+				img.Set(sx, sy, fixtureRed)
+			} else {
+				img.Set(sx, sy, fixtureWhite)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	return withOrientation(buf.Bytes(), orientation)
+}
+
+// uprightPoint returns the upright coordinates of a stored pixel, as defined
+// by the EXIF orientation.
+func uprightPoint(orientation, x, y, width, height int) (int, int) {
+	switch orientation {
+	case 2:
+		return width - 1 - x, y
+	case 3:
+		return width - 1 - x, height - 1 - y
+	case 4:
+		return x, height - 1 - y
+	case 5:
+		return y, x
+	case 6:
+		return height - 1 - y, x
+	case 7:
+		return height - 1 - y, width - 1 - x
+	case 8:
+		return y, width - 1 - x
+	}
+	return x, y
+}
+
+// withOrientation inserts an EXIF segment with the orientation tag after
+// the JPEG start of image marker.
+func withOrientation(buf []byte, orientation int) []byte {
+	var exif bytes.Buffer
+	exif.WriteString("Exif\x00\x00")
+	exif.WriteString("MM\x00\x2a\x00\x00\x00\x08")
+	binary.Write(&exif, binary.BigEndian, []uint16{1, 0x0112, 3})
+	binary.Write(&exif, binary.BigEndian, uint32(1))
+	binary.Write(&exif, binary.BigEndian, []uint16{uint16(orientation), 0})
+	binary.Write(&exif, binary.BigEndian, uint32(0))
+
+	var out bytes.Buffer
+	out.Write(buf[:2])
+	out.Write([]byte{0xff, 0xe1})
+	binary.Write(&out, binary.BigEndian, uint16(exif.Len()+2))
+	out.Write(exif.Bytes())
+	out.Write(buf[2:])
+	return out.Bytes()
+}
+
+func decodeImage(t *testing.T, buf []byte) image.Image {
+	img, _, err := image.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatalf("cannot decode the output image: %s", err)
+	}
+	return img
+}
+
+func TestResizeAutoRotate(t *testing.T) {
+	const width, height = 40, 20
+
+	for orientation := 1; orientation <= 8; orientation++ {
+		fixture := orientedFixture(t, orientation, width, height)
+		meta, err := bimg.Metadata(fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.Orientation != orientation {
+			t.Fatalf("orientation %d: the fixture has orientation %d", orientation, meta.Orientation)
+		}
+
+		for _, strip := range []bool{true, false} {
+			opts := NewOptions(testServerOptions(), "convert")
+			opts.Type, opts.StripMetadata = bimg.PNG, strip
+			buf, err := Resize(fixture, opts)
+			if err != nil {
+				t.Fatalf("orientation %d: unexpected error: %s", orientation, err)
+			}
+
+			img := decodeImage(t, buf)
+			if size := img.Bounds().Size(); size.X != width || size.Y != height {
+				t.Errorf("orientation %d (strip %t): expected %dx%d, got %dx%d", orientation, strip, width, height, size.X, size.Y)
+				continue
+			}
+			if !isRed(img.At(width/4, height/4)) || isRed(img.At(width*3/4, height/4)) ||
+				isRed(img.At(width/4, height*3/4)) || isRed(img.At(width*3/4, height*3/4)) {
+				t.Errorf("orientation %d (strip %t): expected the red quadrant top left", orientation, strip)
+			}
+		}
+	}
+}
+
+func TestResizeNoAutoRotate(t *testing.T) {
+	for orientation := 1; orientation <= 8; orientation++ {
+		opts := NewOptions(testServerOptions(), "convert")
+		opts.Type, opts.NoAutoRotate = bimg.PNG, true
+		buf, err := Resize(orientedFixture(t, orientation, 40, 20), opts)
+		if err != nil {
+			t.Fatalf("orientation %d: unexpected error: %s", orientation, err)
+		}
+
+		width, height := 40, 20
+		if orientation >= 5 {
+			width, height = 20, 40
+		}
+		if size := decodeImage(t, buf).Bounds().Size(); size.X != width || size.Y != height {
+			t.Errorf("orientation %d: expected the stored size %dx%d, got %dx%d", orientation, width, height, size.X, size.Y)
+		}
+	}
+}
+
+func TestExtractAutoRotate(t *testing.T) {
+	region := Region{
+		Width:  Coordinate{Value: 50, Percent: true, Defined: true},
+		Height: Coordinate{Value: 50, Percent: true, Defined: true},
+	}
+
+	for orientation := 1; orientation <= 8; orientation++ {
+		opts := NewOptions(testServerOptions(), "extract")
+		opts.Type, opts.Region = bimg.PNG, region
+		buf, err := Resize(orientedFixture(t, orientation, 40, 20), opts)
+		if err != nil {
+			t.Fatalf("orientation %d: unexpected error: %s", orientation, err)
+		}
+
+		img := decodeImage(t, buf)
+		if size := img.Bounds().Size(); size.X != 20 || size.Y != 10 {
+			t.Errorf("orientation %d: expected 20x10, got %dx%d", orientation, size.X, size.Y)
+			continue
+		}
+		if !isRed(img.At(4, 4)) || !isRed(img.At(15, 5)) {
+			t.Errorf("orientation %d: expected the upright top left quadrant to be extracted", orientation)
+		}
+	}
+}
@@ -9,30 +9,129 @@ import (
 	"runtime"
 	d "runtime/debug"
 	"strconv"
+	"strings"
 	"time"
 )
 
 var debug = Debug("resizr")
 
 var (
-	aAddr         = flag.String("a", "", "bind address")
-	aPort         = flag.Int("p", 9000, "port to listen")
-	aVers         = flag.Bool("v", false, "Show version")
-	aVersl        = flag.Bool("version", false, "Show version")
-	aHelp         = flag.Bool("h", false, "Show help")
-	aHelpl        = flag.Bool("help", false, "Show help")
-	aCors         = flag.Bool("cors", false, "Enable CORS support")
-	aGzip         = flag.Bool("gzip", false, "Enable gzip compression")
-	aPlaceholder  = flag.String("placeholder", "", "Image path to placeholder")
-	aKey          = flag.String("key", "", "Define API key for authorization")
-	aCertFile     = flag.String("certfile", "", "TLS certificate file path")
-	aKeyFile      = flag.String("keyfile", "", "TLS private key file path")
-	aReadTimeout  = flag.Int("http-read-timeout", 30, "HTTP read timeout in seconds")
-	aWriteTimeout = flag.Int("http-write-timeout", 30, "HTTP write timeout in seconds")
-	aConcurrency  = flag.Int("concurrency", 0, "Throttle concurrency limit per second")
-	aBurst        = flag.Int("burst", 100, "Throttle burst max cache size")
-	aMRelease     = flag.Int("mrelease", 30, "OS memory release inverval in seconds")
-	aCpus         = flag.Int("cpus", runtime.GOMAXPROCS(-1), "Number of cpu cores to use")
+	aAddr           = flag.String("a", "", "bind address")
+	aPort           = flag.Int("p", 9000, "port to listen")
+	aVers           = flag.Bool("v", false, "Show version")
+	aVersl          = flag.Bool("version", false, "Show version")
+	aHelp           = flag.Bool("h", false, "Show help")
+	aHelpl          = flag.Bool("help", false, "Show help")
+	aCors           = flag.Bool("cors", false, "Enable CORS support")
+	aCorsOrigins    = flag.String("cors-origins", "", "Comma separated list of allowed CORS origins, or *")
+	aAllowOps       = flag.String("allow-operations", "", "Comma separated list of the enabled operations")
+	aGzip           = flag.Bool("gzip", false, "Enable gzip compression")
+	aBrotli         = flag.Bool("brotli", false, "Enable brotli compression")
+	aPlaceholder    = flag.String("placeholder", "", "Image path to placeholder")
+	aKey            = flag.String("key", "", "Define API key for authorization")
+	aKeyPath        = flag.String("key-file", "", "File containing the API key for authorization")
+	aKeys           = flag.String("keys", "", "API keys file path or comma separated list of key[:rate[:burst]]")
+	aKeyLoc         = flag.String("key-location", "both", "API key location: header, query or both")
+	aAdminKey       = flag.String("admin-key", "", "Admin key required by the /admin endpoints")
+	aSignKey        = flag.String("url-signature-key", "", "HMAC secret key to verify signed URLs")
+	aCertFile       = flag.String("certfile", "", "TLS certificate file path")
+	aSocket         = flag.String("socket", "", "Unix domain socket path to bind instead of TCP")
+	aSocketMode     = flag.String("socket-mode", "0660", "Unix domain socket file permissions")
+	aKeyFile        = flag.String("keyfile", "", "TLS private key file path")
+	aHTTP2          = flag.Bool("http2", true, "Enable HTTP/2 on the TLS listener")
+	aReadTimeout    = flag.Int("http-read-timeout", 30, "HTTP read timeout in seconds")
+	aWriteTimeout   = flag.Int("http-write-timeout", 30, "HTTP write timeout in seconds")
+	aIdleTimeout    = flag.Int("http-idle-timeout", 60, "HTTP keep-alive idle timeout in seconds")
+	aMaxConns       = flag.Int("max-connections", 0, "Maximum concurrent connections, refused past the limit. Zero means no limit")
+	aShutdown       = flag.Int("shutdown-timeout", 30, "Graceful shutdown timeout in seconds")
+	aConcurrency    = flag.Int("concurrency", 0, "Throttle concurrency limit per second")
+	aBurst          = flag.Int("burst", 100, "Throttle burst max cache size")
+	aMaxOps         = flag.Int("max-concurrent-ops", 0, "Max number of simultaneous image processing operations")
+	aQueueTimeout   = flag.Int("queue-timeout", 30, "Max seconds to wait for a processing slot")
+	aIPRateLimit    = flag.Int("ip-rate-limit", 0, "Max requests per client IP within the rate window")
+	aIPRateWindow   = flag.Int("ip-rate-window", 60, "Client IP rate limit window in seconds")
+	aTrustProxy     = flag.Bool("trust-proxy", false, "Read the client IP from X-Forwarded-For or X-Real-IP")
+	aProcTimeout    = flag.Int("processing-timeout", 0, "Max seconds to process the images of a request")
+	aMaxProcTimeout = flag.Int("max-processing-timeout", 0, "Max seconds of the timeout param")
+	aVipsCacheMax   = flag.Int("vips-cache-max", -1, "Max number of libvips cached operations")
+	aVipsCacheMem   = flag.Int("vips-cache-max-mem", -1, "Max libvips operation cache memory in bytes")
+	aVipsThreads    = flag.Int("vips-concurrency", -1, "libvips worker threads per image")
+	aVipsMaxFiles   = flag.Int("vips-max-files", -1, "Max files kept open by the libvips operation cache")
+	aMRelease       = flag.Int("mrelease", 30, "OS memory release inverval in seconds")
+	aURLTimeout     = flag.Int("url-source-timeout", 30, "URL source fetch timeout in seconds")
+	aURLRetries     = flag.Int("url-source-retries", 2, "URL source fetch retries on 5xx and connection errors")
+	aURLRedirects   = flag.Int("url-source-max-redirects", 10, "URL source max redirects to follow")
+	aURLMaxBytes    = flag.Int64("url-source-max-bytes", 0, "URL source max download size in bytes")
+	aURLAllowTypes  = flag.String("url-source-allow-types", "application/octet-stream", "Comma separated media types allowed from the URL source besides images")
+	aURLUserAgent   = flag.String("url-source-user-agent", "resizr/"+Version, "User-Agent header of the URL source requests")
+	aURLHeaders     = repeatedFlag{}
+	aListen         = repeatedFlag{}
+	aAllowHosts     = flag.String("url-allow-hosts", "", "Comma separated hostnames or CIDRs allowed by the URL source")
+	aS3Source       = flag.Bool("enable-s3-source", false, "Enable S3 bucket image source")
+	aS3Bucket       = flag.String("s3-bucket", "", "S3 bucket to read images from")
+	aS3Region       = flag.String("s3-region", "", "S3 bucket region")
+	aS3Timeout      = flag.Int("s3-timeout", 30, "S3 request timeout in seconds")
+	aS3Retries      = flag.Int("s3-retries", 3, "S3 request max retries")
+	aGCSSource      = flag.Bool("enable-gcs-source", false, "Enable Google Cloud Storage image source")
+	aGCSBucket      = flag.String("gcs-bucket", "", "GCS bucket to read images from")
+	aGCSEndpoint    = flag.String("gcs-endpoint", "", "GCS API endpoint override")
+	aAzureSource    = flag.Bool("enable-azure-source", false, "Enable Azure Blob Storage image source")
+	aAzureAccount   = flag.String("azure-account", "", "Azure storage account to read images from")
+	aAzureCont      = flag.String("azure-container", "", "Azure Blob Storage container to read images from")
+	aAzureTimeout   = flag.Int("azure-timeout", 30, "Azure request timeout in seconds")
+	aMaxBodySize    = flag.Int64("max-body-size", 10<<20, "Max source image size in bytes")
+	aMaxHeaderBytes = flag.Int("max-header-bytes", 1<<20, "Max request header size in bytes")
+	aMaxWidth       = flag.Int("max-image-width", 0, "Max source image width in pixels")
+	aMaxHeight      = flag.Int("max-image-height", 0, "Max source image height in pixels")
+	aMaxPixels      = flag.Float64("max-image-megapixels", 0, "Max source image megapixels")
+	aMaxDPR         = flag.Float64("max-dpr", 3, "Max device pixel ratio of the dpr param")
+	aMaxOutWidth    = flag.Int("max-output-width", 0, "Max output image width in pixels")
+	aMaxOutHeight   = flag.Int("max-output-height", 0, "Max output image height in pixels")
+	aNoEnlarge      = flag.Bool("no-enlarge", false, "Do not upscale images beyond their size unless enlarge=true")
+	aMaxFrames      = flag.Int("max-animation-frames", 100, "Max number of frames processed of animated images")
+	aAutoRotate     = flag.Bool("auto-rotate", true, "Auto rotate images based on EXIF orientation")
+	aInterlace      = flag.Bool("interlace", false, "Output progressive JPEG and interlaced PNG images by default")
+	aStripProfile   = flag.Bool("strip-profile", false, "Remove the ICC color profile from output images")
+	aConvertSRGB    = flag.Bool("convert-srgb", false, "Convert output images to sRGB using their ICC color profile")
+	aStripMeta      = flag.Bool("strip-metadata", true, "Remove EXIF, IPTC and XMP metadata from output images")
+	aJPEGQuality    = flag.Int("jpeg-quality", 82, "Default JPEG output quality")
+	aWEBPQuality    = flag.Int("webp-quality", 80, "Default WebP output quality")
+	aAVIFQuality    = flag.Int("avif-quality", 50, "Default AVIF output quality")
+	aPNGCompress    = flag.Int("png-compression", 6, "Default PNG compression level between 0 and 9")
+	aQualityTarget  = flag.Float64("auto-quality-target", 0.98, "SSIM the quality=auto encodings must meet, between 0 and 1")
+	aAutoFormat     = flag.Bool("auto-format", false, "Select the output image type from the Accept header")
+	aDefaultFormat  = flag.String("default-format", "auto", "Default output image type, or auto to keep the source image type")
+	aPassthrough    = flag.Bool("passthrough-unchanged", false, "Reply the source image as is when the operation leaves it unchanged")
+	aFallback       = flag.String("format-fallback", "", "Comma separated output type fallbacks for missing encoders, such as avif=webp,webp=jpeg")
+	aBackground     = flag.String("default-background", "", "Default fill color of the operations, as r,g,b[,a] or hex")
+	aCacheDir       = flag.String("cache-dir", "", "Directory to cache processed images on disk")
+	aCacheMaxSize   = flag.Int64("cache-max-size", 1<<30, "Disk cache max size in bytes")
+	aCacheTTL       = flag.Int("cache-ttl", 86400, "Disk cache entries TTL in seconds")
+	aWatermarkTTL   = flag.Int("watermark-cache-ttl", 300, "Watermark image cache TTL in seconds")
+	aWatermarkSize  = flag.Int64("watermark-cache-max-size", 64<<20, "Watermark image cache max size in bytes")
+	aFallbackImage  = flag.String("default-fallback-image", "", "Image URL, S3 key or placeholder served when the source image fails")
+	aFallbackStatus = flag.Int("fallback-status", 200, "Status code of the fallback image responses")
+	aPipelineOps    = flag.Int("max-pipeline-ops", 10, "Max number of operations per pipeline")
+	aLogFormat      = flag.String("log-format", "text", "Access log format: text or json")
+	aLogLevel       = flag.String("log-level", "info", "Access log level: debug, info, warn or error")
+	aAccessLog      = flag.String("access-log", "", "Access log file path, reopened on SIGHUP. Defaults to stdout")
+	aBatchMax       = flag.Int("max-batch-variants", 10, "Max number of variants per batch")
+	aJobs           = flag.Int("max-concurrent-jobs", 2, "Max number of async jobs processed simultaneously, 0 disables /jobs")
+	aQueuedJobs     = flag.Int("max-queued-jobs", 100, "Max number of async jobs waiting to be processed")
+	aJobTTL         = flag.Int("job-ttl", 3600, "Seconds the finished async jobs status is kept")
+	aPublicVers     = flag.Bool("public-versions", false, "Expose /versions without authorization")
+	aNoIndex        = flag.Bool("no-index", false, "Disable the / landing page")
+	aNoDebug        = flag.Bool("no-debug", false, "Disable the debug param replying the parsed params")
+	aNoCoalesce     = flag.Bool("no-source-coalescing", false, "Fetch every request source image, even while the same image is being fetched")
+	aSizeHeaders    = flag.Bool("expose-size-headers", true, "Reply the X-Bytes-In and X-Bytes-Out headers")
+	aMaintenance    = flag.Bool("maintenance", false, "Start in maintenance mode, replying 503 to the operations")
+	aMetrics        = flag.Bool("metrics", false, "Expose Prometheus metrics on /metrics")
+	aMetricsPort    = flag.Int("metrics-port", 0, "Bind metrics on a separate port")
+	aBasePath       = flag.String("base-path", "", "Route prefix all the endpoints are served under")
+	aRootHealth     = flag.Bool("root-health", false, "Serve the health and metrics endpoints at the root path with -base-path")
+	aCpus           = flag.Int("cpus", runtime.GOMAXPROCS(-1), "Number of cpu cores to use")
+	aConfig         = flag.String("config", "", "YAML or JSON config file path")
+	aDumpConfig     = flag.Bool("dump-config", false, "Print the effective config and exit")
 )
 
 const usage = `resizr %s
@@ -47,23 +146,134 @@ Options:
   -h, -help                 output help
   -v, -version              output version
   -placeholder <path>       placeholder image to use on error
-  -cors                     Enable CORS support [default: false]
-  -gzip                     Enable gzip compression [default: false]
+  -cors                     Enable CORS support for any origin [default: false]
+  -cors-origins <origins>   Comma separated list of allowed CORS origins, or * [default: none]
+  -allow-operations <list>  Comma separated list of the enabled operations and pipeline, batch or jobs [default: all]
+  -gzip                     Enable gzip compression of JSON, SVG and text responses [default: false]
+  -brotli                   Enable brotli compression of JSON, SVG and text responses,
+                            preferred over gzip when accepted [default: false]
   -key <key>                Define API key for authorization
+  -key-file <path>          File containing the API key, taking precedence over API_KEY and -key
+  -keys <list>              API keys file path, or comma separated list of key[:rate[:burst]],
+                            rate limited to rate requests per second [default: unlimited]
+  -key-location <where>     API key location: header, query or both [default: both]
+  -admin-key <key>          Admin key required by the /admin endpoints, in the -key-location
+  -url-signature-key <key>  HMAC secret key required to verify signed request URLs
   -http-read-timeout <num>  HTTP read timeout in seconds [default: 30]
   -http-write-timeout <num> HTTP write timeout in seconds [default: 30]
+  -http-idle-timeout <num>  HTTP keep-alive idle timeout in seconds [default: 60]
+  -max-connections <num>    Maximum concurrent connections, refused past the limit [default: 0, no limit]
+  -shutdown-timeout <num>   Graceful shutdown timeout in seconds [default: 30]
+  -socket <path>            Unix domain socket path to bind instead of TCP. Also via -a unix:<path>
+  -socket-mode <mode>       Unix domain socket file permissions [default: 0660]
+  -listen <addr>            Address to listen, instead of -a and -p. Repeatable. Prefix tls:// to serve
+                            HTTPS with -certfile and -keyfile, or unix:// for a Unix domain socket
   -certfile <path>          TLS certificate file path
   -keyfile <path>           TLS private key file path
-  -concurreny <num>         Throttle concurrency limit per second [default: disabled]
+  -http2                    Enable HTTP/2 on the TLS listener [default: true]
+  -concurrency <num>        Throttle concurrency limit per second [default: disabled]
   -burst <num>              Throttle burst max cache size [default: 100]
+  -max-concurrent-ops <num> Max number of simultaneous image processing operations [default: unlimited]
+  -queue-timeout <num>      Max seconds to wait for a processing slot [default: 30]
+  -processing-timeout <num> Max seconds to process the images of a request [default: unlimited]
+  -max-processing-timeout <num> Max seconds of the timeout param [default: -processing-timeout]
+  -ip-rate-limit <num>      Max requests per client IP within the rate window [default: unlimited]
+  -ip-rate-window <num>     Client IP rate limit window in seconds [default: 60]
+  -trust-proxy              Read the client IP from X-Forwarded-For or X-Real-IP [default: false]
   -mrelease <num>           OS memory release inverval in seconds [default: 30]
+  -vips-cache-max <num>     Max number of libvips cached operations [default: 500]
+  -vips-cache-max-mem <bytes> Max libvips operation cache memory in bytes [default: 100MB]
+  -vips-concurrency <num>   libvips worker threads per image. 0 uses VIPS_CONCURRENCY
+                            or the number of cores [default: 1, or VIPS_CONCURRENCY]
+  -vips-max-files <num>     Max files kept open by the libvips operation cache [default: 100]
+  -url-allow-hosts <list>   Comma separated hostnames (*.example.com) or CIDRs allowed
+                            by the URL source. Private networks are denied unless
+                            allowed by CIDR [default: any public host]
+  -url-source-timeout <num> URL source fetch timeout in seconds [default: 30]
+  -url-source-retries <num> URL source fetch retries on 5xx and connection errors [default: 2]
+  -url-source-max-redirects <num> URL source max redirects to follow [default: 10]
+  -url-source-max-bytes <bytes> URL source max download size in bytes [default: -max-body-size]
+  -url-source-allow-types <list> Comma separated media types allowed from the URL source besides images
+                            and PDF, such as text/*, or * for any [default: application/octet-stream]
+  -url-source-user-agent <ua> User-Agent header of the URL source requests [default: resizr/<version>]
+  -url-source-header <header> Header added to the URL source requests, as "Name: value". Repeatable.
+                            Requires -url-allow-hosts
+  -no-source-coalescing     Fetch every request source image, even while the same image is being fetched [default: false]
+  -enable-s3-source         Enable S3 bucket image source [default: false]
+  -s3-bucket <name>         S3 bucket to read images from
+  -s3-region <region>       S3 bucket region [default: AWS environment]
+  -s3-timeout <num>         S3 request timeout in seconds [default: 30]
+  -s3-retries <num>         S3 request max retries [default: 3]
+  -enable-gcs-source        Enable Google Cloud Storage image source [default: false]
+  -gcs-bucket <name>        GCS bucket to read images from
+  -gcs-endpoint <url>       GCS API endpoint override, e.g: for emulators
+  -enable-azure-source      Enable Azure Blob Storage image source [default: false]
+  -azure-account <name>     Azure storage account to read images from
+  -azure-container <name>   Azure Blob Storage container to read images from
+  -azure-timeout <num>      Azure request timeout in seconds [default: 30]
+  -max-body-size <bytes>    Max source image size in bytes [default: 10485760]
+  -max-header-bytes <bytes> Max request header size in bytes [default: 1048576]
+  -max-image-width <num>    Max source image width in pixels [default: unlimited]
+  -max-image-height <num>   Max source image height in pixels [default: unlimited]
+  -max-image-megapixels <n> Max source image megapixels [default: unlimited]
+  -max-dpr <ratio>          Max device pixel ratio of the dpr param [default: 3]
+  -max-output-width <num>   Max output image width, clamping the requested size [default: unlimited]
+  -max-output-height <num>  Max output image height, clamping the requested size [default: unlimited]
+  -no-enlarge               Do not upscale images beyond their size, unless enlarge=true
+  -max-animation-frames <n> Max number of frames processed of animated images [default: 100]
+  -auto-rotate              Auto rotate images based on EXIF orientation [default: true]
+  -interlace                Output progressive JPEG and interlaced PNG by default [default: false]
+  -strip-profile            Remove the ICC color profile from output images [default: false]
+  -convert-srgb             Convert output images to sRGB using their ICC color profile [default: false]
+  -strip-metadata           Remove EXIF, IPTC and XMP metadata from output images [default: true]
+  -jpeg-quality <num>       Default JPEG output quality [default: 82]
+  -webp-quality <num>       Default WebP output quality [default: 80]
+  -avif-quality <num>       Default AVIF output quality [default: 50]
+  -png-compression <num>    Default PNG compression level between 0 and 9 [default: 6]
+  -auto-quality-target <num> SSIM the quality=auto encodings must meet, between 0 and 1 [default: 0.98]
+  -auto-format              Select the output image type from the Accept header [default: false]
+  -default-format <type>    Default output image type, or auto to keep the source image type [default: auto]
+  -passthrough-unchanged    Reply the source image as is when the operation leaves it unchanged [default: false]
+  -format-fallback <list>   Comma separated output type fallbacks for missing encoders, such as avif=webp,webp=jpeg
+  -default-background <color> Default color used to flatten, rotate or embed images, as r,g,b[,a] or hex
+                            [default: white, or transparent for output types with alpha]
+  -cache-dir <path>         Directory to cache processed images on disk [default: disabled]
+  -cache-max-size <bytes>   Disk cache max size in bytes [default: 1073741824]
+  -cache-ttl <num>          Disk cache entries TTL in seconds [default: 86400]
+  -watermark-cache-ttl <num> Watermark image cache TTL in seconds [default: 300]
+  -watermark-cache-max-size <bytes> Watermark image cache max size in bytes, evicting the least
+                            recently used images [default: 67108864]
+  -default-fallback-image <location> Image URL, S3 key or placeholder served, processed with the
+                            operation, when the source image fails [default: none]
+  -fallback-status <code>   Status code of the fallback image responses [default: 200]
+  -max-pipeline-ops <num>   Max number of operations per pipeline [default: 10]
+  -max-batch-variants <num> Max number of variants per batch [default: 10]
+  -max-concurrent-jobs <num> Max number of async jobs processed simultaneously, 0 disables /jobs [default: 2]
+  -max-queued-jobs <num>    Max number of async jobs waiting to be processed [default: 100]
+  -job-ttl <num>            Seconds the finished async jobs status is kept [default: 3600]
+  -log-format <format>      Access log format: text or json [default: text]
+  -log-level <level>        Access log level: debug, info, warn or error [default: info]
+  -access-log <path>        Access log file path, reopened on SIGHUP. - means stdout [default: stdout]
+  -public-versions          Expose /versions without authorization [default: false]
+  -no-index                 Disable the / landing page [default: false]
+  -no-debug                 Disable the debug param replying the parsed params [default: false]
+  -expose-size-headers      Reply the X-Bytes-In and X-Bytes-Out headers, disabled by =false [default: true]
+  -maintenance              Start in maintenance mode, replying 503 to the operations [default: false]
+  -metrics                  Expose Prometheus metrics on /metrics [default: false]
+  -metrics-port <port>      Bind metrics on a separate port [default: server port]
+  -base-path <path>         Route prefix all the endpoints are served under, such as /images [default: /]
+  -root-health              Serve the health and metrics endpoints at the root path with -base-path [default: false]
   -cpus <num>               Number of used cpu cores.
                             (default for current machine is %d cores)
+  -config <path>            YAML or JSON config file path
+  -dump-config              Print the effective config and exit
 `
 
 func main() {
 	var err error
 
+	flag.Var(&aListen, "listen", "Address to listen, repeatable: host:port, tls://host:port or unix:///path")
+	flag.Var(&aURLHeaders, "url-source-header", "Header added to the URL source requests, as Name: value")
 	flag.Usage = func() {
 		fmt.Fprint(os.Stderr, fmt.Sprintf(usage, Version, runtime.NumCPU()))
 	}
@@ -76,22 +286,146 @@ func main() {
 		showVersion()
 	}
 
+	// Load config file values for flags not passed explicitly
+	if *aConfig != "" {
+		if err = applyConfig(flag.CommandLine, *aConfig); err != nil {
+			exitWithError("%s\n", err)
+		}
+	}
+
 	// Only required in Go < 1.5
 	runtime.GOMAXPROCS(*aCpus)
 
 	port := getPort(*aPort)
 	opts := ServerOptions{
-		Port:             port,
-		Address:          *aAddr,
-		Gzip:             *aGzip,
-		CORS:             *aCors,
-		ApiKey:           *aKey,
-		Concurrency:      *aConcurrency,
-		Burst:            *aBurst,
-		CertFile:         *aCertFile,
-		KeyFile:          *aKeyFile,
-		HttpReadTimeout:  *aReadTimeout,
-		HttpWriteTimeout: *aWriteTimeout,
+		Port:                 port,
+		LogFormat:            *aLogFormat,
+		LogLevel:             *aLogLevel,
+		AccessLog:            *aAccessLog,
+		Address:              *aAddr,
+		Socket:               *aSocket,
+		SocketMode:           *aSocketMode,
+		Listen:               aListen,
+		Gzip:                 *aGzip,
+		Brotli:               *aBrotli,
+		CORS:                 *aCors,
+		CORSOrigins:          parseList(*aCorsOrigins),
+		AllowOperations:      parseList(*aAllowOps),
+		Concurrency:          *aConcurrency,
+		Burst:                *aBurst,
+		MaxConcurrentOps:     *aMaxOps,
+		QueueTimeout:         *aQueueTimeout,
+		ProcessingTimeout:    *aProcTimeout,
+		MaxProcessingTimeout: *aMaxProcTimeout,
+		IPRateLimit:          *aIPRateLimit,
+		IPRateWindow:         *aIPRateWindow,
+		TrustProxy:           *aTrustProxy,
+		CertFile:             *aCertFile,
+		HTTP2:                *aHTTP2,
+		KeyFile:              *aKeyFile,
+		KeyLocation:          *aKeyLoc,
+		AdminKey:             *aAdminKey,
+		HttpReadTimeout:      *aReadTimeout,
+		HttpWriteTimeout:     *aWriteTimeout,
+		HttpIdleTimeout:      *aIdleTimeout,
+		MaxConnections:       *aMaxConns,
+		ShutdownTimeout:      *aShutdown,
+		PublicVersions:       *aPublicVers,
+		NoIndex:              *aNoIndex,
+		NoDebug:              *aNoDebug,
+		NoSourceCoalescing:   *aNoCoalesce,
+		ExposeSizeHeaders:    *aSizeHeaders,
+		Maintenance:          *aMaintenance,
+		Metrics:              *aMetrics,
+		MetricsPort:          *aMetricsPort,
+		BasePath:             *aBasePath,
+		RootHealth:           *aRootHealth,
+		MaxPipelineOps:       *aPipelineOps,
+		MaxBatchVariants:     *aBatchMax,
+		MaxConcurrentJobs:    *aJobs,
+		MaxQueuedJobs:        *aQueuedJobs,
+		JobTTL:               *aJobTTL,
+		MaxBodySize:          *aMaxBodySize,
+		MaxHeaderBytes:       *aMaxHeaderBytes,
+		MaxImageWidth:        *aMaxWidth,
+		MaxImageHeight:       *aMaxHeight,
+		MaxImagePixels:       *aMaxPixels,
+		MaxDPR:               *aMaxDPR,
+		MaxOutputWidth:       *aMaxOutWidth,
+		MaxOutputHeight:      *aMaxOutHeight,
+		NoEnlarge:            *aNoEnlarge,
+		MaxAnimationFrames:   *aMaxFrames,
+		AutoRotate:           *aAutoRotate,
+		Interlace:            *aInterlace,
+		StripProfile:         *aStripProfile,
+		ConvertSRGB:          *aConvertSRGB,
+		StripMetadata:        *aStripMeta,
+		Quality: QualityDefaults{
+			JPEG:           *aJPEGQuality,
+			WEBP:           *aWEBPQuality,
+			AVIF:           *aAVIFQuality,
+			PNGCompression: *aPNGCompress,
+		},
+		AutoFormat:            *aAutoFormat,
+		AutoQualityTarget:     *aQualityTarget,
+		DefaultFormat:         *aDefaultFormat,
+		PassthroughUnchanged:  *aPassthrough,
+		FormatFallback:        parseList(*aFallback),
+		DefaultBackground:     *aBackground,
+		CacheDir:              *aCacheDir,
+		CacheMaxSize:          *aCacheMaxSize,
+		CacheTTL:              *aCacheTTL,
+		WatermarkCacheTTL:     *aWatermarkTTL,
+		WatermarkCacheMaxSize: *aWatermarkSize,
+		DefaultFallbackImage:  *aFallbackImage,
+		FallbackStatus:        *aFallbackStatus,
+		URLSignatureKey:       *aSignKey,
+		URLAllowHosts:         parseList(*aAllowHosts),
+		URLSourceTimeout:      *aURLTimeout,
+		URLSourceRetries:      *aURLRetries,
+		URLSourceMaxRedirects: *aURLRedirects,
+		URLSourceMaxBytes:     *aURLMaxBytes,
+		URLSourceAllowTypes:   parseList(*aURLAllowTypes),
+		URLSourceUserAgent:    *aURLUserAgent,
+		URLSourceHeaders:      aURLHeaders,
+		S3: S3Options{
+			Enabled: *aS3Source,
+			Bucket:  *aS3Bucket,
+			Region:  *aS3Region,
+			Timeout: *aS3Timeout,
+			Retries: *aS3Retries,
+		},
+		GCS: GCSOptions{
+			Enabled:  *aGCSSource,
+			Bucket:   *aGCSBucket,
+			Endpoint: *aGCSEndpoint,
+		},
+		Azure: AzureOptions{
+			Enabled:   *aAzureSource,
+			Account:   *aAzureAccount,
+			Container: *aAzureCont,
+			Timeout:   *aAzureTimeout,
+		},
+		Vips: VipsOptions{
+			CacheMax:    *aVipsCacheMax,
+			CacheMaxMem: *aVipsCacheMem,
+			Concurrency: *aVipsThreads,
+			MaxFiles:    *aVipsMaxFiles,
+		},
+	}
+
+	key, err := resolveAPIKey(*aKey, *aKeyPath)
+	if err != nil {
+		exitWithError("%s\n", err)
+	}
+	opts.APIKeys, err = parseAPIKeys(key, *aKeys)
+	if err != nil {
+		exitWithError("%s\n", err)
+	}
+
+	if *aDumpConfig {
+		dumpConfig(opts)
+		os.Exit(0)
 	}
 
 	// Load placeholder image
@@ -104,16 +438,21 @@ func main() {
 
 	// Create a memory release goroutine
 	if *aMRelease > 0 {
-		memoryRelease(*aMRelease)
+		stop := memoryRelease(*aMRelease)
+		defer stop()
 	}
 
-	debug("resizr server listening on port %d", port)
-
+	vips := configureVips(opts.Vips)
+	debug("libvips cache max %d operations, %d bytes, %d files, concurrency %d",
+		vips.CacheMax, vips.CacheMaxMem, vips.MaxFiles, vips.Concurrency)
+	debug("supported output formats: %s", strings.Join(supportedOutputTypes(), ", "))
 	// Start the server
 	err = Server(opts)
 	if err != nil {
 		exitWithError("cannot start the server: %s\n", err)
 	}
+
+	debug("resizr server stopped")
 }
 
 func getPort(port int) int {
@@ -126,6 +465,28 @@ func getPort(port int) int {
 	return port
 }
 
+// repeatedFlag collects the values of a flag passed several times.
+type repeatedFlag []string
+
+func (f *repeatedFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *repeatedFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func parseList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 func showUsage() {
 	flag.Usage()
 	os.Exit(1)
@@ -136,17 +497,27 @@ func showVersion() {
 	os.Exit(1)
 }
 
-func memoryRelease(interval int) {
+func memoryRelease(interval int) func() {
 	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	quit := make(chan struct{})
 	go func() {
-		for _ = range ticker.C {
-			debug("FreeOSMemory()")
-			d.FreeOSMemory()
+		for {
+			select {
+			case <-ticker.C:
+				debug("FreeOSMemory()")
+				d.FreeOSMemory()
+			case <-quit:
+				return
+			}
 		}
 	}()
+	return func() {
+		ticker.Stop()
+		close(quit)
+	}
 }
 
 func exitWithError(format string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, format, args)
+	fmt.Fprintf(os.Stderr, format, args...)
 	os.Exit(1)
 }
@@ -0,0 +1,130 @@
+package main
+
+/*
+#cgo pkg-config: vips
+#include <stdlib.h>
+#include <vips/vips.h>
+
+// rotate_buffer rotates the image by any angle, filling the exposed
+// corners with the background, and saves it as PNG.
+static int
+rotate_buffer(void *buf, size_t len, double angle, double *rgba, int alpha, void **out, size_t *out_len) {
+	VipsImage *in = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (in == NULL) {
+		return 1;
+	}
+
+	// Intermediate images are released with the input image
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(in), 2);
+	VipsImage *image = in;
+	if (alpha && !vips_image_hasalpha(image)) {
+		if (vips_addalpha(image, &t[0], NULL)) {
+			g_object_unref(in);
+			return 1;
+		}
+		image = t[0];
+	}
+
+	// The background requires a value per band
+	double values[4];
+	int bands = image->Bands;
+	if (bands > 4) {
+		bands = 4;
+	}
+	if (bands < 3) {
+		values[0] = rgba[0];
+		values[1] = rgba[3];
+	} else {
+		for (int i = 0; i < bands; i++) {
+			values[i] = rgba[i];
+		}
+	}
+
+	VipsArrayDouble *background = vips_array_double_new(values, bands);
+	int err = vips_rotate(image, &t[1], angle, "background", background, NULL);
+	vips_area_unref(VIPS_AREA(background));
+	if (err == 0) {
+		err = vips_image_write_to_buffer(t[1], ".png", out, out_len, NULL);
+	}
+
+	g_object_unref(in);
+	return err;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"gopkg.in/h2non/bimg.v1"
+	"math"
+	"net/url"
+	"unsafe"
+)
+
+// readRotateParams reads the rotate operation angle and background.
+// Negative angles are normalized, so -90 rotates by 270 degrees.
+func readRotateParams(query url.Values, opts *Options) error {
+	if opts.Operation != "rotate" {
+		return nil
+	}
+
+	angle, err := parseFloatParam(query, "angle", -360, 360)
+	if err != nil {
+		return err
+	}
+	opts.Angle = math.Mod(angle+360, 360)
+	return readBackground(query, opts)
+}
+
+// rotate rotates the processed image by the options angle. Right angles are
+// rotated by bimg, while any other angle grows the canvas to fit the rotated
+// image, with the corners filled by the background color: transparent for
+// output types with alpha, white otherwise.
+func rotate(image []byte, params bimg.Options, opts Options) ([]byte, error) {
+	if math.Mod(opts.Angle, 90) == 0 {
+		params.Rotate = bimg.Angle(opts.Angle)
+		return bimg.Resize(image, params)
+	}
+
+	final := params
+	if final.Type == bimg.UNKNOWN {
+		final.Type = bimg.DetermineImageType(image)
+	}
+
+	background := white
+	if hasAlpha(final.Type) && !opts.Flatten {
+		background = transparent
+	}
+	background = opts.fill(background)
+
+	params.Type = bimg.PNG
+	image, err := bimg.Resize(image, params)
+	if err != nil {
+		return nil, err
+	}
+	image, err = rotateAngle(image, opts.Angle, background, hasAlpha(final.Type))
+	if err != nil {
+		return nil, err
+	}
+	return encode(image, final)
+}
+
+func rotateAngle(image []byte, angle float64, background Color, alpha bool) ([]byte, error) {
+	if len(image) == 0 {
+		return nil, errors.New("empty image")
+	}
+
+	rgba := []C.double{C.double(background.R), C.double(background.G), C.double(background.B), C.double(background.A)}
+	withAlpha := C.int(0)
+	if alpha && background.A < 255 {
+		withAlpha = 1
+	}
+
+	var out unsafe.Pointer
+	var length C.size_t
+	if C.rotate_buffer(unsafe.Pointer(&image[0]), C.size_t(len(image)), C.double(angle), &rgba[0], withAlpha, &out, &length) != 0 {
+		return nil, vipsErrorf("cannot rotate image")
+	}
+	defer C.g_free(C.gpointer(out))
+	return C.GoBytes(out, C.int(length)), nil
+}
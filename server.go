@@ -1,66 +1,410 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"github.com/julienschmidt/httprouter"
-	"gopkg.in/h2non/bimg.v0"
+	"gopkg.in/h2non/bimg.v1"
+	"io"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
 type ServerOptions struct {
-	Port             int
-	Burst            int
-	Concurrency      int
-	HttpReadTimeout  int
-	HttpWriteTimeout int
-	CORS             bool
-	Gzip             bool
-	Address          string
-	ApiKey           string
-	CertFile         string
-	KeyFile          string
-	Placeholder      []byte
+	Port                  int                  `yaml:"port"`
+	Burst                 int                  `yaml:"burst"`
+	Concurrency           int                  `yaml:"concurrency"`
+	MaxConcurrentOps      int                  `yaml:"maxConcurrentOps"`
+	QueueTimeout          int                  `yaml:"queueTimeout"`
+	ProcessingTimeout     int                  `yaml:"processingTimeout"`
+	MaxProcessingTimeout  int                  `yaml:"maxProcessingTimeout"`
+	IPRateLimit           int                  `yaml:"ipRateLimit"`
+	IPRateWindow          int                  `yaml:"ipRateWindow"`
+	TrustProxy            bool                 `yaml:"trustProxy"`
+	HttpReadTimeout       int                  `yaml:"httpReadTimeout"`
+	HttpWriteTimeout      int                  `yaml:"httpWriteTimeout"`
+	HttpIdleTimeout       int                  `yaml:"httpIdleTimeout"`
+	MaxConnections        int                  `yaml:"maxConnections"`
+	ShutdownTimeout       int                  `yaml:"shutdownTimeout"`
+	HTTP2                 bool                 `yaml:"http2"`
+	MetricsPort           int                  `yaml:"metricsPort"`
+	BasePath              string               `yaml:"basePath"`
+	RootHealth            bool                 `yaml:"rootHealth"`
+	MaxPipelineOps        int                  `yaml:"maxPipelineOps"`
+	MaxBatchVariants      int                  `yaml:"maxBatchVariants"`
+	MaxConcurrentJobs     int                  `yaml:"maxConcurrentJobs"`
+	MaxQueuedJobs         int                  `yaml:"maxQueuedJobs"`
+	JobTTL                int                  `yaml:"jobTTL"`
+	MaxBodySize           int64                `yaml:"maxBodySize"`
+	MaxHeaderBytes        int                  `yaml:"maxHeaderBytes"`
+	MaxImageWidth         int                  `yaml:"maxImageWidth"`
+	MaxImageHeight        int                  `yaml:"maxImageHeight"`
+	MaxImagePixels        float64              `yaml:"maxImagePixels"`
+	MaxDPR                float64              `yaml:"maxDpr"`
+	MaxOutputWidth        int                  `yaml:"maxOutputWidth"`
+	MaxOutputHeight       int                  `yaml:"maxOutputHeight"`
+	NoEnlarge             bool                 `yaml:"noEnlarge"`
+	MaxAnimationFrames    int                  `yaml:"maxAnimationFrames"`
+	AutoRotate            bool                 `yaml:"autoRotate"`
+	Interlace             bool                 `yaml:"interlace"`
+	Quality               QualityDefaults      `yaml:"quality"`
+	AutoQualityTarget     float64              `yaml:"autoQualityTarget"`
+	StripProfile          bool                 `yaml:"stripProfile"`
+	ConvertSRGB           bool                 `yaml:"convertSrgb"`
+	StripMetadata         bool                 `yaml:"stripMetadata"`
+	AutoFormat            bool                 `yaml:"autoFormat"`
+	DefaultFormat         string               `yaml:"defaultFormat"`
+	PassthroughUnchanged  bool                 `yaml:"passthroughUnchanged"`
+	FormatFallback        []string             `yaml:"formatFallback"`
+	DefaultBackground     string               `yaml:"defaultBackground"`
+	CacheDir              string               `yaml:"cacheDir"`
+	CacheMaxSize          int64                `yaml:"cacheMaxSize"`
+	CacheTTL              int                  `yaml:"cacheTtl"`
+	WatermarkCacheTTL     int                  `yaml:"watermarkCacheTtl"`
+	WatermarkCacheMaxSize int64                `yaml:"watermarkCacheMaxSize"`
+	DefaultFallbackImage  string               `yaml:"defaultFallbackImage"`
+	FallbackStatus        int                  `yaml:"fallbackStatus"`
+	Metrics               bool                 `yaml:"metrics"`
+	PublicVersions        bool                 `yaml:"publicVersions"`
+	NoIndex               bool                 `yaml:"noIndex"`
+	NoDebug               bool                 `yaml:"noDebug"`
+	NoSourceCoalescing    bool                 `yaml:"noSourceCoalescing"`
+	ExposeSizeHeaders     bool                 `yaml:"exposeSizeHeaders"`
+	Maintenance           bool                 `yaml:"maintenance"`
+	CORS                  bool                 `yaml:"cors"`
+	CORSOrigins           []string             `yaml:"corsOrigins"`
+	AllowOperations       []string             `yaml:"allowOperations"`
+	Gzip                  bool                 `yaml:"gzip"`
+	Brotli                bool                 `yaml:"brotli"`
+	Address               string               `yaml:"address"`
+	Socket                string               `yaml:"socket"`
+	Listen                []string             `yaml:"listen"`
+	SocketMode            string               `yaml:"socketMode"`
+	LogFormat             string               `yaml:"logFormat"`
+	LogLevel              string               `yaml:"logLevel"`
+	AccessLog             string               `yaml:"accessLog"`
+	APIKeys               map[string]KeyConfig `yaml:"-"`
+	KeyLocation           string               `yaml:"keyLocation"`
+	AdminKey              string               `yaml:"-"`
+	CertFile              string               `yaml:"certFile"`
+	KeyFile               string               `yaml:"keyFile"`
+	Placeholder           []byte               `yaml:"-"`
+	Logger                Logger               `yaml:"-"`
+	URLSignatureKey       string               `yaml:"urlSignatureKey"`
+	URLAllowHosts         []string             `yaml:"urlAllowHosts"`
+	URLSourceTimeout      int                  `yaml:"urlSourceTimeout"`
+	URLSourceRetries      int                  `yaml:"urlSourceRetries"`
+	URLSourceMaxRedirects int                  `yaml:"urlSourceMaxRedirects"`
+	URLSourceMaxBytes     int64                `yaml:"urlSourceMaxBytes"`
+	URLSourceAllowTypes   []string             `yaml:"urlSourceAllowTypes"`
+	URLSourceUserAgent    string               `yaml:"urlSourceUserAgent"`
+	URLSourceHeaders      []string             `yaml:"urlSourceHeaders"`
+	S3                    S3Options            `yaml:"s3"`
+	GCS                   GCSOptions           `yaml:"gcs"`
+	Azure                 AzureOptions         `yaml:"azure"`
+	Vips                  VipsOptions          `yaml:"vips"`
 }
 
 func Server(o ServerOptions) error {
-	addr := o.Address + ":" + strconv.Itoa(o.Port)
-	handler := NewServerMux(o)
+	// Fail before listening, so the node never receives traffic
+	if err := vipsSelfTest(); err != nil {
+		return err
+	}
+	handler, err := NewServerMux(o)
+	if err != nil {
+		return err
+	}
+	listeners, err := serverListeners(o)
+	if err != nil {
+		return err
+	}
+	setMaintenance(o.Maintenance)
+	go toggleMaintenance()
 
 	server := &http.Server{
-		Addr:           addr,
 		Handler:        handler,
-		MaxHeaderBytes: 1 << 20,
+		MaxHeaderBytes: o.MaxHeaderBytes,
 		ReadTimeout:    time.Duration(o.HttpReadTimeout) * time.Second,
 		WriteTimeout:   time.Duration(o.HttpWriteTimeout) * time.Second,
+		IdleTimeout:    time.Duration(o.HttpIdleTimeout) * time.Second,
+	}
+	// HTTP/2 is negotiated via ALPN by default, unless disabled
+	if !o.HTTP2 {
+		disableHTTP2(server)
+	}
+
+	bound, err := listen(listeners, o)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go gracefulShutdown(server, o, done)
+
+	if o.Metrics && o.MetricsPort > 0 {
+		go serveMetrics(o)
+	}
+
+	errs := make(chan error, len(bound))
+	for i, listener := range bound {
+		debug("resizr server listening on %s", listeners[i])
+		go func(l Listener, listener net.Listener) {
+			errs <- serve(server, l, listener, o)
+		}(listeners[i], listener)
+	}
+
+	// A listener failure stops the others
+	err = <-errs
+	if err != http.ErrServerClosed {
+		server.Close()
+		return err
 	}
+	return <-done
+}
+
+func gracefulShutdown(s *http.Server, o ServerOptions, done chan<- error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
 
-	return listenAndServe(server, o)
+	sig := <-signals
+	debug("received %s, draining connections", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(o.ShutdownTimeout)*time.Second)
+	defer cancel()
+	done <- s.Shutdown(ctx)
 }
 
-func listenAndServe(s *http.Server, o ServerOptions) error {
-	if o.CertFile != "" && o.KeyFile != "" {
-		return s.ListenAndServeTLS(o.CertFile, o.KeyFile)
+func serveMetrics(o ServerOptions) {
+	addr := o.Address + ":" + strconv.Itoa(o.MetricsPort)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metricsController)
+
+	debug("metrics server listening on port %d", o.MetricsPort)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		debug("metrics server error: %s", err)
 	}
-	return s.ListenAndServe()
 }
 
-func NewServerMux(o ServerOptions) http.Handler {
-	mux := httprouter.New()
-	mux.GET("/", indexController)
-	mux.GET("/:operation/:size/*url", resizeController(o))
-	return mux
+func NewServerMux(o ServerOptions) (http.Handler, error) {
+	logger := o.Logger
+	if logger == nil {
+		var out io.Writer
+		if o.AccessLog != "" && o.AccessLog != "-" {
+			file, err := OpenLogFile(o.AccessLog)
+			if err != nil {
+				return nil, fmt.Errorf("cannot open access log: %s", err)
+			}
+			out = file
+		}
+		var err error
+		if logger, err = NewLogger(o.LogFormat, o.LogLevel, out); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := parseFormatFallback(o.FormatFallback); err != nil {
+		return nil, err
+	}
+	if err := o.Quality.Validate(); err != nil {
+		return nil, err
+	}
+	if o.DefaultBackground != "" {
+		if _, err := parseColor(o.DefaultBackground); err != nil {
+			return nil, fmt.Errorf("invalid default background: %s", err)
+		}
+	}
+
+	if err := validateAllowedOperations(o.AllowOperations); err != nil {
+		return nil, err
+	}
+	if o.AutoQualityTarget <= 0 || o.AutoQualityTarget > 1 {
+		return nil, fmt.Errorf("invalid auto quality target: must be between 0 and 1")
+	}
+	if o.FallbackStatus < 200 || o.FallbackStatus > 599 {
+		return nil, fmt.Errorf("invalid fallback status: %d", o.FallbackStatus)
+	}
+	if _, err := parseDefaultFormat(o.DefaultFormat); err != nil {
+		return nil, err
+	}
+	if !keyLocations[o.KeyLocation] {
+		return nil, fmt.Errorf("invalid key location: %s", o.KeyLocation)
+	}
+	base, err := normalizeBasePath(o.BasePath)
+	if err != nil {
+		return nil, err
+	}
+
+	sources, err := NewImageSources(o)
+	if err != nil {
+		return nil, err
+	}
+
+	// Image operations also accept the image in the request body
+	uploads := append([]ImageSource{BodySource{maxBodySize: o.MaxBodySize}}, sources...)
+
+	cache, err := newCache(o)
+	if err != nil {
+		return nil, err
+	}
+
+	queue := NewOpQueue(o.MaxConcurrentOps, time.Duration(o.QueueTimeout)*time.Second)
+	watermarks := NewWatermarkStore(o, sources)
+	operation := instrument(authorize(o, allowOperation(o, resizeController(o, uploads, watermarks, cache, queue))))
+
+	router := httprouter.New()
+	if !o.NoIndex {
+		router.GET("/", indexController)
+	}
+	router.GET("/:operation/:size/*url", operation)
+	router.POST("/:operation/:size/*url", operation)
+	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, NewError("not found", http.StatusNotFound))
+	})
+	router.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, NewError("method not allowed", http.StatusMethodNotAllowed))
+	})
+
+	// The convert operation has no size, so it is routed apart
+	convert := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "POST" {
+			writeError(w, NewError("method not allowed", http.StatusMethodNotAllowed))
+			return
+		}
+		operation(w, r, httprouter.Params{
+			{Key: "operation", Value: "convert"},
+			{Key: "size", Value: "0"},
+			{Key: "url", Value: strings.TrimPrefix(r.URL.Path, "/convert")},
+		})
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/favicon.ico", faviconController)
+	mux.HandleFunc("/health", healthController)
+	mux.HandleFunc("/health/ready", readinessController)
+	mux.Handle("/stats", allowMethod("GET", authorize(o, statsController)))
+	if o.AdminKey != "" {
+		// The maintenance mode drains the node, so it is only toggled by the admin key holders
+		mux.Handle("/admin/maintenance", allowMethod("POST", validateAdminKey(o.AdminKey, o.KeyLocation, maintenanceController)))
+	}
+	mux.Handle("/srcset", allowMethod("GET", authorize(o, srcsetController(o))))
+	mux.Handle("/info", allowMethod("GET", instrumentAs("info", authorize(o, infoController(o, sources)))))
+	if operationAllowed(o, "pipeline") {
+		mux.Handle("/pipeline", allowMethod("POST", instrumentAs("pipeline", authorize(o, pipelineController(o, sources, watermarks, queue)))))
+	}
+	versions := versionsController
+	if !o.PublicVersions {
+		versions = authorize(o, versions)
+	}
+	mux.Handle("/versions", allowMethod("GET", versions))
+	if operationAllowed(o, "batch") {
+		mux.Handle("/batch", allowMethod("POST", instrumentAs("batch", authorize(o, batchController(o, sources, watermarks, queue)))))
+	}
+	jobs, err := NewJobRunner(o, watermarks, queue)
+	if err != nil {
+		return nil, err
+	}
+	if jobs != nil && operationAllowed(o, "jobs") {
+		mux.Handle("/jobs", allowMethod("POST", instrumentAs("jobs", authorize(o, jobsController(o, sources, jobs)))))
+		mux.Handle("/jobs/", allowMethod("GET", authorize(o, jobStatusController(jobs))))
+	}
+	if o.Metrics && o.MetricsPort == 0 {
+		mux.HandleFunc("/metrics", metricsController)
+	}
+	handler := routePaths(mux, convert, router)
+	if o.MaxBodySize > 0 {
+		handler = withBodyLimit(o.MaxBodySize, handler)
+	}
+	handler = withMaintenance(handler)
+	if limiter := NewIPLimiter(o.IPRateLimit, time.Duration(o.IPRateWindow)*time.Second, o.TrustProxy); limiter != nil {
+		handler = withIPLimit(limiter, handler)
+	}
+	if o.Concurrency > 0 {
+		handler = withThrottle(o.Concurrency, o.Burst, handler)
+	}
+	if origins := corsOrigins(o); len(origins) > 0 {
+		handler = withCORS(origins, handler)
+	}
+	if o.Gzip || o.Brotli {
+		handler = withCompression(o.Gzip, o.Brotli, handler)
+	}
+	if base != "" {
+		handler = withBasePath(base, o.RootHealth, o.RootHealth && o.Metrics && o.MetricsPort == 0, handler)
+	}
+	return withRequestID(accessLog(logger, handler)), nil
 }
 
-func resizeController(o ServerOptions) func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		if r.Method != "GET" {
-			badRequest(w, "method not allowed")
+// corsOrigins returns the allowed CORS origins. The -cors flag alone allows any origin.
+func corsOrigins(o ServerOptions) []string {
+	if len(o.CORSOrigins) > 0 {
+		return o.CORSOrigins
+	}
+	if o.CORS {
+		return []string{"*"}
+	}
+	return nil
+}
+
+// authorize wraps the handler with the configured request authorization.
+func authorize(o ServerOptions, h httprouter.Handle) httprouter.Handle {
+	if o.URLSignatureKey != "" {
+		h = validateSignature(o.URLSignatureKey, h)
+	}
+	if len(o.APIKeys) > 0 {
+		h = validateKey(o.APIKeys, o.KeyLocation, h)
+	}
+	return h
+}
+
+// routePaths serves the operations, whose path may hold the image URL, with
+// no ServeMux path cleaning, which would redirect /resize/300x200/http://...
+// to a cleaned path with a broken URL. The other routes go to the ServeMux.
+func routePaths(mux *http.ServeMux, convert, router http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/convert/") {
+			convert.ServeHTTP(w, r)
+			return
+		}
+		if _, pattern := mux.Handler(r); pattern == "" {
+			router.ServeHTTP(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// allowMethod adapts a router handler to be served by the standard mux,
+// since httprouter cannot mix static routes with the operation wildcard.
+func allowMethod(method string, h httprouter.Handle) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			writeError(w, NewError("method not allowed", http.StatusMethodNotAllowed))
 			return
 		}
+		h(w, r, nil)
+	}
+}
+
+func newCache(o ServerOptions) (Cache, error) {
+	if o.CacheDir == "" {
+		return nil, nil
+	}
+	return NewDiskCache(o.CacheDir, o.CacheMaxSize, time.Duration(o.CacheTTL)*time.Second)
+}
 
+func resizeController(o ServerOptions, sources []ImageSource, watermarks *WatermarkStore, cache Cache, queue *OpQueue) httprouter.Handle {
+	// Validated by NewServerMux
+	defaultType, _ := parseDefaultFormat(o.DefaultFormat)
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		width, height, err := parseDimensions(ps.ByName("size"))
 		if err != nil {
 			badRequest(w, "invalid width or height path expression")
@@ -68,75 +412,363 @@ func resizeController(o ServerOptions) func(w http.ResponseWriter, r *http.Reque
 		}
 
 		debug("resize to %dx%d", width, height)
-		opts := Options{Width: width, Height: height, Operation: ps.ByName("operation")}
+		opts := NewOptions(o, ps.ByName("operation"))
+		opts.Width, opts.Height = width, height
+
+		if err := readParams(r.URL.Query(), &opts); err != nil {
+			failed(w, opts, o, err)
+			return
+		}
+		applyDPR(&opts, o)
+		clamped := clampOutput(&opts, o)
+		if r.URL.Query().Get("type") == "" && !opts.KeepFormat && opts.Operation != "placeholder" {
+			if o.AutoFormat {
+				// The output type depends on the client, not only on the URL
+				w.Header().Add("Vary", "Accept")
+				opts.Type = negotiateType(r.Header.Get("Accept"))
+			}
+			if opts.Type == bimg.UNKNOWN {
+				opts.Type = defaultType
+			}
+		}
+		fallback, err := resolveOutputType(o, &opts)
+		if err != nil {
+			failed(w, opts, o, err)
+			return
+		}
+		if fallback != "" {
+			w.Header().Set(fallbackHeader, fallback)
+		}
+		if err := watermarks.Resolve(r, &opts); err != nil {
+			failed(w, opts, o, err)
+			return
+		}
 
-		image, err := Fetch(ps.ByName("url")[1:])
+		processing, cancel, err := newProcessing(r, o)
 		if err != nil {
-			failed(w, opts, o, err.Error())
+			failed(w, opts, o, err)
 			return
 		}
+		defer cancel()
+		if deadline, ok := processing.ctx.Deadline(); ok {
+			opts.Deadline = deadline
+		}
+		// The processing slot is only taken by the first libvips stage, so
+		// the fetches and the cached replies do not wait for the queue
+		var release func()
+		process := func(stage func() ([]byte, error)) ([]byte, error) {
+			if release == nil {
+				var err error
+				if release, err = queue.Acquire(processing.ctx); err != nil {
+					return nil, err
+				}
+			}
+			return processing.Run(stage)
+		}
+		releaseSlot := func() {
+			if release != nil {
+				processing.Release(release)
+				release = nil
+			}
+		}
+		defer releaseSlot()
 
-		image, err = Resize(image, opts)
+		var image []byte
+		sourceName := "generate"
+		if opts.Operation == "generate" {
+			image, err = process(func() ([]byte, error) {
+				return generateImage(opts, o)
+			})
+		} else {
+			source := matchSource(sources, r)
+			sourceName = source.Name()
+			image, err = source.GetImage(w, r, ps)
+			metrics.AddBytesIn(len(image))
+		}
+		bytesIn := len(image)
+		if err == nil {
+			err = validateImage(image, o)
+		}
+		if err == nil && isDocument(image) {
+			document := image
+			image, err = process(func() ([]byte, error) {
+				return renderDocument(document, opts)
+			})
+		}
+		status := http.StatusOK
+		writeOutput := func(out []byte) {
+			if o.ExposeSizeHeaders {
+				setSizeHeaders(w, bytesIn, len(out))
+			}
+			if opts.DataURI {
+				writeDataURI(w, out, status)
+				return
+			}
+			writeImageStatus(w, out, status)
+		}
 		if err != nil {
-			failed(w, opts, o, err.Error())
+			if image, err = loadFallback(r, o, watermarks, opts, err); err != nil {
+				failed(w, opts, o, err)
+				return
+			}
+			status, bytesIn = o.FallbackStatus, len(image)
+			w.Header().Del("Last-Modified")
+			w.Header().Set(fallbackUsedHeader, "true")
+		}
+
+		if opts.Operation != "generate" {
+			enlarge, err := clampEnlarge(image, &opts)
+			if err != nil {
+				failed(w, opts, o, err)
+				return
+			}
+			clamped = clamped || enlarge
+		}
+		if clamped {
+			w.Header().Set(clampedHeader, "true")
+		}
+
+		skipped := false
+		if opts.Condition != nil && opts.Operation != "generate" {
+			matched, err := opts.Condition.Match(image, opts.NoAutoRotate)
+			if err != nil {
+				failed(w, opts, o, err)
+				return
+			}
+			skipped = !matched
+		}
+		if skipped {
+			w.Header().Set(skippedHeader, "true")
+		}
+
+		if opts.Operation == "trim" && !skipped {
+			// The results are only read once the stage succeeded, since the
+			// abandoned stages are still running
+			var region Region
+			var applied bool
+			source := image
+			_, err = process(func() ([]byte, error) {
+				var err error
+				region, applied, err = findTrim(source, opts)
+				return nil, err
+			})
+			if err != nil {
+				failed(w, opts, o, err)
+				return
+			}
+			opts.Region = region
+			w.Header().Set(trimHeader, strconv.FormatBool(applied))
+		}
+
+		if opts.Debug && !o.NoDebug {
+			body, _ := json.Marshal(debugParams(image, sourceName, opts, clamped))
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(body)
+			return
+		}
+
+		if opts.DryRun {
+			plan, err := planImage(image, opts)
+			if err != nil {
+				failed(w, opts, o, err)
+				return
+			}
+			body, _ := json.Marshal(plan)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(body)
 			return
 		}
 
-		mime := GetImageMimeType(bimg.DetermineImageType(image))
-		w.Header().Set("Content-Type", mime)
-		w.Write(image)
+		if opts.Operation == "placeholder" && opts.Type == bimg.UNKNOWN {
+			var reply ColorPlaceholder
+			source := image
+			_, err := process(func() ([]byte, error) {
+				var err error
+				reply, err = colorPlaceholder(source, opts)
+				return nil, err
+			})
+			releaseSlot()
+			if err != nil {
+				failed(w, opts, o, err)
+				return
+			}
+			body, _ := json.Marshal(reply)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(body)
+			return
+		}
+
+		key := cacheKey(r, opts, image)
+		etag := imageETag(key)
+		modified := sourceModified(w)
+		if notModified(r, etag, modified) {
+			writeNotModified(w, etag, modified)
+			return
+		}
+		setDisposition(w, opts)
+
+		if opts.DataURI && !skipped {
+			// Data URIs inflate the output by a third, so only bounded images are encoded
+			plan, err := planImage(image, opts)
+			if err == nil {
+				limits := imageLimits(o)
+				if limits.Pixels == 0 {
+					limits.Pixels = defaultDataURIMegapixels
+				}
+				err = limits.Check(plan.Width, plan.Height)
+			}
+			if err != nil {
+				failed(w, opts, o, err)
+				return
+			}
+		}
+
+		if skipped || (o.PassthroughUnchanged && !opts.ForceEncode && unchanged(image, opts)) {
+			w.Header().Set("ETag", etag)
+			writeOutput(image)
+			return
+		}
+
+		if cache != nil {
+			if cached, ok := cache.Get(key); ok {
+				debug("cache hit %s", key)
+				w.Header().Set("ETag", etag)
+				writeOutput(cached)
+				return
+			}
+		}
+
+		source := image
+		image, err = process(func() ([]byte, error) {
+			return Resize(source, opts)
+		})
+		releaseSlot()
+		if err != nil {
+			failed(w, opts, o, err)
+			return
+		}
+
+		if cache != nil {
+			if err := cache.Set(key, image); err != nil {
+				debug("cache error: %s", err)
+			}
+		}
+
+		w.Header().Set("ETag", etag)
+		writeOutput(image)
 	}
 }
 
+// defaultDataURIMegapixels caps the data URI outputs when there is no
+// -max-image-megapixels limit, since they are buffered and inflated.
+const defaultDataURIMegapixels = 4
+
+// writeDataURI replies the image as a base64 data URI, such as
+// data:image/png;base64,iVBORw0KGgo...
+func writeDataURI(w http.ResponseWriter, image []byte, code int) {
+	uri := "data:" + GetImageMimeType(bimg.DetermineImageType(image)) + ";base64," + base64.StdEncoding.EncodeToString(image)
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Content-Length", strconv.Itoa(len(uri)))
+	w.WriteHeader(code)
+	w.Write([]byte(uri))
+}
+
+// setSizeHeaders reports the source and output image bytes, and their ratio.
+func setSizeHeaders(w http.ResponseWriter, in, out int) {
+	w.Header().Set("X-Bytes-In", strconv.Itoa(in))
+	w.Header().Set("X-Bytes-Out", strconv.Itoa(out))
+	if out > 0 {
+		w.Header().Set("X-Compression-Ratio", strconv.FormatFloat(float64(in)/float64(out), 'f', 2, 64))
+	}
+}
+
+func writeImageStatus(w http.ResponseWriter, image []byte, code int) {
+	w.Header().Set("Content-Type", GetImageMimeType(bimg.DetermineImageType(image)))
+	w.Header().Set("Content-Length", strconv.Itoa(len(image)))
+	w.WriteHeader(code)
+	w.Write(image)
+}
+
+// Index is the landing page, describing the server and its endpoints.
+type Index struct {
+	Versions
+	Description string   `json:"description"`
+	Operations  []string `json:"operations"`
+	Endpoints   []string `json:"endpoints"`
+}
+
 func indexController(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	if r.URL.Path != "/" {
 		http.NotFound(w, r)
 		return
 	}
 
-	body, _ := json.Marshal(CurrentVersions)
+	names := []string{}
+	for name := range operations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	body, _ := json.Marshal(Index{
+		Versions:    CurrentVersions,
+		Description: "resizr image processing HTTP server",
+		Operations:  names,
+		Endpoints:   []string{"/info", "/pipeline", "/batch", "/srcset", "/versions", "/health", "/stats"},
+	})
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
 	w.Write(body)
 }
 
+// parseDimensions parses the {width}x{height} path size, where an empty
+// side, as the height of 300x, is 0.
 func parseDimensions(value string) (int, int, error) {
-	var width, height int
-
-	size := strings.Split(value, "x")
-	width, err := strconv.Atoi(size[0])
-	if err != nil {
-		return 0, 0, err
+	size := strings.SplitN(value, "x", 2)
+	width, err := parseDimension(size[0])
+	if err != nil || len(size) == 1 {
+		return width, 0, err
 	}
+	height, err := parseDimension(size[1])
+	return width, height, err
+}
 
-	if len(size) > 1 {
-		height, err = strconv.Atoi(size[1])
+func parseDimension(value string) (int, error) {
+	if value == "" {
+		return 0, nil
 	}
-
-	return width, height, err
+	size, err := strconv.Atoi(value)
+	if err == nil && size < 0 {
+		return 0, fmt.Errorf("negative dimension: %s", value)
+	}
+	return size, err
 }
 
-func failed(w http.ResponseWriter, opts Options, o ServerOptions, msg string) {
-	image := placeholder
-	if len(o.Placeholder) > 1 {
-		image = o.Placeholder
+// failed replies the operation error as a JSON envelope or, when the
+// -placeholder image is defined, with the placeholder resized to the
+// requested size, with the error in the Error header.
+func failed(w http.ResponseWriter, opts Options, o ServerOptions, cause error) {
+	if len(o.Placeholder) <= 1 {
+		writeError(w, cause)
+		return
 	}
 
+	cause = publicError(w, cause)
 	opts.Force = true
-	image, err := Resize(image, opts)
+	opts.Type = bimg.UNKNOWN
+	opts.Watermark = WatermarkOptions{}
+	image, err := Resize(o.Placeholder, opts)
 	if err != nil {
-		badRequest(w, err.Error())
+		writeError(w, cause)
 		return
 	}
 
-	w.Header().Set("Content-Type", GetImageMimeType(bimg.DetermineImageType(image)))
-	w.Header().Set("Error", msg)
-	w.WriteHeader(http.StatusBadRequest)
-	w.Write(image)
+	w.Header().Del("Last-Modified")
+	w.Header().Del("Content-Disposition")
+	w.Header().Set("Error", cause.Error())
+	setRetryAfter(w, errorCode(cause))
+	writeImageStatus(w, image, errorCode(cause))
 }
 
 func badRequest(w http.ResponseWriter, msg string) {
-	w.Header().Set("Content-Type", "image/jpeg")
-	w.Header().Set("Error", msg)
-	w.WriteHeader(http.StatusBadRequest)
-	w.Write(placeholder)
+	writeError(w, NewError(msg, http.StatusBadRequest))
 }
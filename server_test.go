@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testServerOptions returns the flag defaults, with the URL source allowed
+// to fetch the test servers on the loopback interface.
+func testServerOptions() ServerOptions {
+	return ServerOptions{
+		Logger:                &recordLogger{},
+		KeyLocation:           "both",
+		Burst:                 100,
+		QueueTimeout:          30,
+		IPRateWindow:          60,
+		HTTP2:                 true,
+		ExposeSizeHeaders:     true,
+		MaxPipelineOps:        10,
+		MaxBatchVariants:      10,
+		MaxConcurrentJobs:     2,
+		MaxQueuedJobs:         100,
+		JobTTL:                3600,
+		MaxBodySize:           10 << 20,
+		MaxHeaderBytes:        1 << 20,
+		MaxDPR:                3,
+		MaxAnimationFrames:    100,
+		AutoRotate:            true,
+		StripMetadata:         true,
+		Quality:               QualityDefaults{JPEG: 82, WEBP: 80, AVIF: 50, PNGCompression: 6},
+		AutoQualityTarget:     0.98,
+		DefaultFormat:         "auto",
+		WatermarkCacheTTL:     300,
+		WatermarkCacheMaxSize: 64 << 20,
+		FallbackStatus:        200,
+		URLAllowHosts:         []string{"127.0.0.0/8"},
+		URLSourceTimeout:      30,
+		URLSourceMaxRedirects: 10,
+		URLSourceAllowTypes:   []string{"application/octet-stream"},
+		URLSourceUserAgent:    "resizr/" + Version,
+		Vips:                  VipsOptions{CacheMax: -1, CacheMaxMem: -1, Concurrency: -1, MaxFiles: -1},
+	}
+}
+
+// newImageServer serves a JPEG image of the given size on every path.
+func newImageServer(t *testing.T, width, height int) *httptest.Server {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(buf.Bytes())
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestServerMuxURLPath(t *testing.T) {
+	upstream := newImageServer(t, 400, 300)
+	handler, err := NewServerMux(testServerOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/resize/200x150/" + upstream.URL + "/image.jpg", "/convert/" + upstream.URL + "/image.jpg?type=png"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected status %d, got %d: %s", path, http.StatusOK, w.Code, w.Header().Get("Error"))
+		}
+	}
+}
+
+func TestRoutePaths(t *testing.T) {
+	route := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Route", name)
+			w.Header().Set("Path", r.URL.Path)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/health", route("health"))
+	mux.Handle("/health/ready", route("ready"))
+	mux.Handle("/jobs/", route("jobs"))
+	handler := routePaths(mux, route("convert"), route("router"))
+
+	cases := []struct {
+		path  string
+		route string
+	}{
+		{"/resize/300x200/http://server.com/image.jpg", "router"},
+		{"/resize/300x200/https://server.com//images/image.jpg", "router"},
+		{"/crop/300x200/image.jpg", "router"},
+		{"/convert/http://server.com/image.jpg", "convert"},
+		{"/health", "health"},
+		{"/health/ready", "ready"},
+		{"/jobs/8f14e45f", "jobs"},
+		{"/", "router"},
+		{"/missing", "router"},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", c.path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected status %d, got %d", c.path, http.StatusOK, w.Code)
+		}
+		if route := w.Header().Get("Route"); route != c.route {
+			t.Errorf("%s: expected the %s route, got %q", c.path, c.route, route)
+		}
+		if path := w.Header().Get("Path"); path != c.path {
+			t.Errorf("%s: expected the path to be kept, got %s", c.path, path)
+		}
+	}
+}
+
+func TestParseDimensions(t *testing.T) {
+	cases := []struct {
+		size          string
+		width, height int
+		fails         bool
+	}{
+		{"300x200", 300, 200, false},
+		{"300x", 300, 0, false},
+		{"x200", 0, 200, false},
+		{"300", 300, 0, false},
+		{"0", 0, 0, false},
+		{"-100000000x1", 0, 0, true},
+		{"300x-200", 0, 0, true},
+		{"300x200x100", 0, 0, true},
+		{"widex200", 0, 0, true},
+	}
+
+	for _, c := range cases {
+		width, height, err := parseDimensions(c.size)
+		if c.fails {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %dx%d", c.size, width, height)
+			}
+			continue
+		}
+		if err != nil || width != c.width || height != c.height {
+			t.Errorf("%s: expected %dx%d, got %dx%d (%v)", c.size, c.width, c.height, width, height, err)
+		}
+	}
+}
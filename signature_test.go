@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/julienschmidt/httprouter"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateSignatureBody(t *testing.T) {
+	const secret = "secret"
+	body := `[{"operation":"resize","params":{"width":300}}]`
+	params := url.Values{"url": {"http://server.com/image.jpg"}}
+	sign := SignRequest(secret, "/pipeline", params, []byte(body), time.Time{})
+
+	cases := []struct {
+		name     string
+		sign     string
+		body     string
+		expected int
+	}{
+		{"signed body", sign, body, http.StatusOK},
+		{"replaced body", sign, `[{"operation":"blur","params":{"sigma":100}}]`, http.StatusForbidden},
+		{"missing body", sign, "", http.StatusForbidden},
+		{"unsigned body", SignURL(secret, "/pipeline", params, time.Time{}), body, http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		var received string
+		handler := validateSignature(secret, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+			buf, _ := ioutil.ReadAll(r.Body)
+			received = string(buf)
+		})
+
+		query := url.Values{"url": params["url"], "sign": {c.sign}}
+		r := httptest.NewRequest("POST", "/pipeline?"+query.Encode(), strings.NewReader(c.body))
+		w := httptest.NewRecorder()
+		handler(w, r, nil)
+
+		if w.Code != c.expected {
+			t.Errorf("%s: expected status %d, got %d", c.name, c.expected, w.Code)
+		}
+		if c.expected == http.StatusOK && received != c.body {
+			t.Errorf("%s: expected the handler to read the body %q, got %q", c.name, c.body, received)
+		}
+	}
+}
+
+func TestSignRequestEmptyBody(t *testing.T) {
+	params := url.Values{"type": {"webp"}}
+	if SignRequest("secret", "/resize/300x/image.jpg", params, nil, time.Time{}) != SignURL("secret", "/resize/300x/image.jpg", params, time.Time{}) {
+		t.Error("expected the empty body signature to equal the URL signature")
+	}
+}
+
+func TestValidateSignature(t *testing.T) {
+	const secret = "secret"
+	const path = "/resize/300x200/http://server.com/image.jpg"
+	params := url.Values{"quality": {"80"}, "type": {"webp"}}
+	sign := SignURL(secret, path, params, time.Time{})
+
+	cases := []struct {
+		name     string
+		target   string
+		expected int
+	}{
+		{"signed", path + "?quality=80&type=webp&sign=" + sign, http.StatusOK},
+		{"signed with the params in another order", path + "?sign=" + sign + "&type=webp&quality=80", http.StatusOK},
+		{"missing signature", path + "?quality=80&type=webp", http.StatusForbidden},
+		{"invalid hex signature", path + "?quality=80&type=webp&sign=xyz", http.StatusForbidden},
+		{"tampered param", path + "?quality=100&type=webp&sign=" + sign, http.StatusForbidden},
+		{"added param", path + "?quality=80&type=webp&width=3000&sign=" + sign, http.StatusForbidden},
+		{"tampered path", "/resize/3000x2000/http://server.com/image.jpg?quality=80&type=webp&sign=" + sign, http.StatusForbidden},
+		{"other key", path + "?quality=80&type=webp&sign=" + SignURL("other", path, params, time.Time{}), http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		handler := validateSignature(secret, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {})
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", c.target, nil), nil)
+		if w.Code != c.expected {
+			t.Errorf("%s: expected status %d, got %d", c.name, c.expected, w.Code)
+		}
+	}
+}
+
+func TestSignURLNoParams(t *testing.T) {
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("/resize/300x/image.jpg"))
+	if SignURL("secret", "/resize/300x/image.jpg", nil, time.Time{}) != hex.EncodeToString(mac.Sum(nil)) {
+		t.Error("expected only the path to be signed")
+	}
+}
+
+func TestValidateSignatureExpires(t *testing.T) {
+	const secret = "secret"
+	const path = "/resize/300x200/http://server.com/image.jpg"
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+	expiresAt := func(expires time.Time) string {
+		return strconv.FormatInt(expires.Unix(), 10)
+	}
+
+	cases := []struct {
+		name     string
+		target   string
+		expected int
+	}{
+		{
+			"not expired",
+			path + "?expires=" + expiresAt(future) + "&sign=" + SignURL(secret, path, nil, future),
+			http.StatusOK,
+		},
+		{
+			"expired",
+			path + "?expires=" + expiresAt(past) + "&sign=" + SignURL(secret, path, nil, past),
+			http.StatusGone,
+		},
+		{
+			"extended expiry",
+			path + "?expires=" + expiresAt(future) + "&sign=" + SignURL(secret, path, nil, past),
+			http.StatusForbidden,
+		},
+		{
+			"removed expiry",
+			path + "?sign=" + SignURL(secret, path, nil, past),
+			http.StatusForbidden,
+		},
+		{
+			"invalid expires",
+			path + "?expires=tomorrow&sign=" + SignURL(secret, path, url.Values{"expires": {"tomorrow"}}, time.Time{}),
+			http.StatusForbidden,
+		},
+	}
+
+	for _, c := range cases {
+		handler := validateSignature(secret, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {})
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", c.target, nil), nil)
+		if w.Code != c.expected {
+			t.Errorf("%s: expected status %d, got %d", c.name, c.expected, w.Code)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// socketPath returns the Unix domain socket path, defined by the socket
+// option or by an address with the unix: prefix.
+func socketPath(o ServerOptions) string {
+	if o.Socket != "" {
+		return o.Socket
+	}
+	if strings.HasPrefix(o.Address, "unix:") {
+		return strings.TrimPrefix(o.Address, "unix:")
+	}
+	return ""
+}
+
+// listenSocket binds the Unix domain socket, removing the stale socket
+// file of a previous run, and applies the file permissions.
+func listenSocket(path, mode string) (net.Listener, error) {
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid socket mode: %s", mode)
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("cannot bind socket: %s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, os.FileMode(perm)); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ImageSource provides the image to process for a given request.
+type ImageSource interface {
+	Name() string
+	Matches(r *http.Request) bool
+	GetImage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) ([]byte, error)
+}
+
+// URLSource fetches the image from the remote URL defined in the request path.
+type URLSource struct {
+	fetcher *Fetcher
+	group   *sourceGroup
+}
+
+func NewURLSource(o ServerOptions) (*URLSource, error) {
+	policy, err := NewHostPolicy(o.URLAllowHosts)
+	if err != nil {
+		return nil, err
+	}
+	headers, err := parseHeaders(o.URLSourceHeaders)
+	if err != nil {
+		return nil, err
+	}
+	if len(headers) > 0 && len(o.URLAllowHosts) == 0 {
+		// The headers usually carry origin credentials, which any URL would receive
+		return nil, fmt.Errorf("-url-source-header requires -url-allow-hosts")
+	}
+	return &URLSource{fetcher: NewFetcher(policy, FetchOptions{
+		Timeout:      time.Duration(o.URLSourceTimeout) * time.Second,
+		Retries:      o.URLSourceRetries,
+		MaxRedirects: o.URLSourceMaxRedirects,
+		MaxBytes:     urlSourceMaxBytes(o),
+		AllowTypes:   o.URLSourceAllowTypes,
+		UserAgent:    o.URLSourceUserAgent,
+		Headers:      headers,
+	})}, nil
+}
+
+func (s *URLSource) Name() string {
+	return "url"
+}
+
+func (s *URLSource) Matches(r *http.Request) bool {
+	return true
+}
+
+func (s *URLSource) GetImage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) ([]byte, error) {
+	return s.group.Do("url:"+requestImageURL(r, ps), w, r, ps, s.fetch)
+}
+
+func (s *URLSource) fetch(w http.ResponseWriter, r *http.Request, ps httprouter.Params) ([]byte, error) {
+	imageUrl := requestImageURL(r, ps)
+	if imageUrl == "" {
+		return nil, NewError("missing image URL", http.StatusBadRequest)
+	}
+	buf, modified, err := s.fetcher.FetchModified(r.Context(), imageUrl)
+	setLastModified(w, modified)
+	return buf, err
+}
+
+// requestImageURL returns the image URL defined in the request path,
+// or in the url query param for routes without a path URL.
+func requestImageURL(r *http.Request, ps httprouter.Params) string {
+	if path := ps.ByName("url"); len(path) > 1 {
+		return path[1:]
+	}
+	return r.URL.Query().Get("url")
+}
+
+// parseHeaders parses the "Name: value" headers.
+func parseHeaders(values []string) (http.Header, error) {
+	headers := http.Header{}
+	for _, value := range values {
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid URL source header: %s", value)
+		}
+		headers.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	return headers, nil
+}
+
+// urlSourceMaxBytes returns the URL source download limit, which defaults to the max body size.
+func urlSourceMaxBytes(o ServerOptions) int64 {
+	if o.URLSourceMaxBytes > 0 {
+		return o.URLSourceMaxBytes
+	}
+	return o.MaxBodySize
+}
+
+// NewImageSources returns the enabled image sources, sorted by priority.
+// The URL source always goes last, since it matches any request.
+func NewImageSources(o ServerOptions) ([]ImageSource, error) {
+	sources := []ImageSource{}
+
+	// The concurrent fetches of the same image are coalesced by default
+	group := func() *sourceGroup {
+		if o.NoSourceCoalescing {
+			return nil
+		}
+		return newSourceGroup()
+	}
+
+	if o.S3.Enabled {
+		s3, err := NewS3Source(o.S3)
+		if err != nil {
+			return nil, err
+		}
+		s3.group = group()
+		sources = append(sources, s3)
+	}
+
+	if o.GCS.Enabled {
+		gcs, err := NewGCSSource(o.GCS)
+		if err != nil {
+			return nil, err
+		}
+		gcs.group = group()
+		sources = append(sources, gcs)
+	}
+
+	if o.Azure.Enabled {
+		azure, err := NewAzureSource(o.Azure)
+		if err != nil {
+			return nil, err
+		}
+		azure.group = group()
+		sources = append(sources, azure)
+	}
+
+	source, err := NewURLSource(o)
+	if err != nil {
+		return nil, err
+	}
+	source.group = group()
+	return append(sources, source), nil
+}
+
+func matchSource(sources []ImageSource, r *http.Request) ImageSource {
+	for _, source := range sources {
+		if source.Matches(r) {
+			logSource(r, source.Name())
+			return source
+		}
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	azureAPIVersion = "2020-10-02"
+	azureIMDSToken  = "http://169.254.169.254/metadata/identity/oauth2/token"
+	azureResource   = "https://storage.azure.com/"
+)
+
+type AzureOptions struct {
+	Enabled   bool   `yaml:"enabled"`
+	Account   string `yaml:"account"`
+	Container string `yaml:"container"`
+	Timeout   int    `yaml:"timeout"`
+}
+
+// AzureSource reads the image defined by the azureblob query param from an
+// Azure Blob Storage container, via the Blob service REST API.
+// Credentials are resolved from the AZURE_STORAGE_SAS_TOKEN or AZURE_STORAGE_KEY
+// environment variables, else from the managed identity of the instance.
+type AzureSource struct {
+	account   string
+	container string
+	client    *http.Client
+	sasToken  string
+	key       []byte
+	identity  *azureIdentity
+	group     *sourceGroup
+}
+
+func NewAzureSource(o AzureOptions) (*AzureSource, error) {
+	if o.Account == "" || o.Container == "" {
+		return nil, fmt.Errorf("Azure source requires an account and a container")
+	}
+
+	s := &AzureSource{
+		account:   o.Account,
+		container: o.Container,
+		client:    &http.Client{Timeout: time.Duration(o.Timeout) * time.Second},
+	}
+	switch {
+	case os.Getenv("AZURE_STORAGE_SAS_TOKEN") != "":
+		s.sasToken = strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?")
+	case os.Getenv("AZURE_STORAGE_KEY") != "":
+		key, err := base64.StdEncoding.DecodeString(os.Getenv("AZURE_STORAGE_KEY"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid AZURE_STORAGE_KEY: %s", err)
+		}
+		s.key = key
+	default:
+		s.identity = &azureIdentity{client: s.client, clientID: os.Getenv("AZURE_CLIENT_ID")}
+	}
+	return s, nil
+}
+
+func (s *AzureSource) Name() string {
+	return "azure"
+}
+
+func (s *AzureSource) Matches(r *http.Request) bool {
+	return r.URL.Query().Get("azureblob") != ""
+}
+
+func (s *AzureSource) GetImage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) ([]byte, error) {
+	return s.group.Do("azure:"+r.URL.Query().Get("azureblob"), w, r, ps, s.fetch)
+}
+
+func (s *AzureSource) fetch(w http.ResponseWriter, r *http.Request, ps httprouter.Params) ([]byte, error) {
+	name := r.URL.Query().Get("azureblob")
+	if err := checkBlobName(name); err != nil {
+		return nil, err
+	}
+	blobURL := &url.URL{
+		Scheme: "https",
+		Host:   s.account + ".blob.core.windows.net",
+		Path:   "/" + s.container + "/" + strings.TrimPrefix(name, "/"),
+	}
+	if s.sasToken != "" {
+		blobURL.RawQuery = s.sasToken
+	}
+
+	req, _ := http.NewRequest("GET", blobURL.String(), nil)
+	req = req.WithContext(r.Context())
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	if err := s.authorize(req); err != nil {
+		return nil, NewError(fmt.Sprintf("Error authorizing Azure request: %s (blob=%s)", err, name), http.StatusBadGateway)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, NewError(fmt.Sprintf("Error downloading Azure blob: %s (blob=%s)", err, name), http.StatusBadGateway)
+	}
+	defer res.Body.Close()
+	if requestID := res.Header.Get("x-ms-request-id"); requestID != "" {
+		w.Header().Set("X-Azure-Request-ID", requestID)
+	}
+	if res.StatusCode == http.StatusNotFound {
+		return nil, NewError(fmt.Sprintf("Azure blob not found: (blob=%s)", name), http.StatusNotFound)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, NewError(fmt.Sprintf("Error downloading Azure blob: (status=%d) (blob=%s)", res.StatusCode, name), http.StatusBadGateway)
+	}
+
+	buf, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, NewError(fmt.Sprintf("Error reading Azure blob: %s (blob=%s)", err, name), http.StatusBadGateway)
+	}
+	modified, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+	setLastModified(w, modified)
+	return buf, nil
+}
+
+// checkBlobName rejects the blob names with dot segments, which would
+// read outside of the container, also when percent encoded, even more
+// than once, or separated by backslashes.
+func checkBlobName(name string) error {
+	decoded := name
+	for {
+		unescaped, err := url.PathUnescape(decoded)
+		if err != nil {
+			return NewError(fmt.Sprintf("invalid Azure blob name: %s", name), http.StatusBadRequest)
+		}
+		if unescaped == decoded {
+			break
+		}
+		decoded = unescaped
+	}
+
+	segments := strings.FieldsFunc(decoded, func(r rune) bool {
+		return r == '/' || r == '\\'
+	})
+	for _, segment := range segments {
+		if segment == "." || segment == ".." {
+			return NewError(fmt.Sprintf("invalid Azure blob name: %s", name), http.StatusBadRequest)
+		}
+	}
+	return nil
+}
+
+// authorize signs the request with the shared key, or sets the managed
+// identity bearer token. SAS requests are authorized by the query string.
+func (s *AzureSource) authorize(req *http.Request) error {
+	switch {
+	case s.key != nil:
+		req.Header.Set("Authorization", "SharedKey "+s.account+":"+s.sign(req))
+	case s.identity != nil:
+		token, err := s.identity.Token()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// sign returns the shared key signature of a GET request with no body,
+// as defined by the Blob service authorization scheme.
+func (s *AzureSource) sign(req *http.Request) string {
+	canonical := "GET\n" + strings.Repeat("\n", 11) +
+		"x-ms-date:" + req.Header.Get("x-ms-date") + "\n" +
+		"x-ms-version:" + req.Header.Get("x-ms-version") + "\n" +
+		"/" + s.account + req.URL.EscapedPath()
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(canonical))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// azureIdentity requests and caches the managed identity access tokens
+// from the instance metadata service.
+type azureIdentity struct {
+	client   *http.Client
+	clientID string
+
+	mutex   sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (i *azureIdentity) Token() (string, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	// Renew the tokens ahead of their expiration
+	if i.token != "" && time.Now().Add(5*time.Minute).Before(i.expires) {
+		return i.token, nil
+	}
+
+	query := url.Values{"api-version": {"2018-02-01"}, "resource": {azureResource}}
+	if i.clientID != "" {
+		query.Set("client_id", i.clientID)
+	}
+	req, _ := http.NewRequest("GET", azureIMDSToken+"?"+query.Encode(), nil)
+	req.Header.Set("Metadata", "true")
+	res, err := i.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("cannot request managed identity token: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("cannot request managed identity token: (status=%d)", res.StatusCode)
+	}
+
+	var reply struct {
+		AccessToken string `json:"access_token"`
+		ExpiresOn   string `json:"expires_on"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&reply); err != nil {
+		return "", fmt.Errorf("invalid managed identity token reply: %s", err)
+	}
+	expires, _ := strconv.ParseInt(reply.ExpiresOn, 10, 64)
+	i.token, i.expires = reply.AccessToken, time.Unix(expires, 0)
+	return i.token, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestCheckBlobName(t *testing.T) {
+	cases := []struct {
+		name  string
+		valid bool
+	}{
+		{"image.jpg", true},
+		{"/path/to/image.jpg", true},
+		{"path/image..jpg", true},
+		{"path/..image.jpg", true},
+		{"path/my%20image.jpg", true},
+		{"../other/image.jpg", false},
+		{"path/../../other/image.jpg", false},
+		{"path/./image.jpg", false},
+		{"path/..", false},
+		{"%2e%2e/other/image.jpg", false},
+		{"%2E%2E%2Fother%2Fimage.jpg", false},
+		{"%252e%252e/other/image.jpg", false},
+		{"..%5cother%5cimage.jpg", false},
+		{"path\\..\\image.jpg", false},
+		{"path/%zz.jpg", false},
+	}
+
+	for _, c := range cases {
+		if err := checkBlobName(c.name); (err == nil) != c.valid {
+			t.Errorf("%s: expected valid %t, got %v", c.name, c.valid, err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+)
+
+const formFieldName = "file"
+
+// BodySource reads the image from the POST request body, either as raw
+// image bytes or as the file field of a multipart/form-data body.
+type BodySource struct {
+	maxBodySize int64
+}
+
+func (s BodySource) Name() string {
+	return "body"
+}
+
+func (s BodySource) Matches(r *http.Request) bool {
+	return r.Method == "POST"
+}
+
+func (s BodySource) GetImage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) ([]byte, error) {
+	if s.maxBodySize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxBodySize)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		return s.readFormFile(r)
+	}
+
+	buf, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, bodyError(err)
+	}
+	if len(buf) == 0 {
+		return nil, NewError("missing image in request body", http.StatusBadRequest)
+	}
+	return buf, nil
+}
+
+func (s BodySource) readFormFile(r *http.Request) ([]byte, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, NewError("invalid multipart body: "+err.Error(), http.StatusBadRequest)
+	}
+
+	var buf []byte
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, bodyError(err)
+		}
+		if part.FormName() != formFieldName {
+			continue
+		}
+		if buf != nil {
+			return nil, NewError("multiple file fields are not allowed", http.StatusBadRequest)
+		}
+		if buf, err = ioutil.ReadAll(part); err != nil {
+			return nil, bodyError(err)
+		}
+	}
+
+	if len(buf) == 0 {
+		return nil, NewError("missing file field in multipart body", http.StatusBadRequest)
+	}
+	return buf, nil
+}
+
+func bodyError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return NewError("request body is too large", http.StatusRequestEntityTooLarge)
+	}
+	return NewError("cannot read request body: "+err.Error(), http.StatusBadRequest)
+}
+
+// jsonBodyError replies 413 for the JSON bodies over the limit.
+func jsonBodyError(kind string, err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return NewError("request body is too large", http.StatusRequestEntityTooLarge)
+	}
+	return NewError("invalid "+kind+" JSON body: "+err.Error(), http.StatusBadRequest)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"cloud.google.com/go/storage"
+	"context"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+	"io"
+	"net/http"
+)
+
+type GCSOptions struct {
+	Enabled  bool   `yaml:"enabled"`
+	Bucket   string `yaml:"bucket"`
+	Endpoint string `yaml:"endpoint"`
+}
+
+type gcsRequestIDKey struct{}
+
+// GCSSource reads the image defined by the gcskey query param from a
+// Google Cloud Storage bucket, authenticating via application default credentials.
+type GCSSource struct {
+	bucket *storage.BucketHandle
+	group  *sourceGroup
+}
+
+func NewGCSSource(o GCSOptions) (*GCSSource, error) {
+	if o.Bucket == "" {
+		return nil, fmt.Errorf("GCS source requires a bucket")
+	}
+
+	ctx := context.Background()
+	authOpts := []option.ClientOption{option.WithScopes(storage.ScopeReadOnly)}
+	clientOpts := []option.ClientOption{}
+	if o.Endpoint != "" {
+		authOpts = append(authOpts, option.WithoutAuthentication())
+		clientOpts = append(clientOpts, option.WithEndpoint(o.Endpoint))
+	}
+
+	httpClient, _, err := htransport.NewClient(ctx, authOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create GCS HTTP client: %s", err)
+	}
+	httpClient.Transport = gcsTransport{httpClient.Transport}
+
+	client, err := storage.NewClient(ctx, append(clientOpts, option.WithHTTPClient(httpClient))...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create GCS client: %s", err)
+	}
+
+	return &GCSSource{bucket: client.Bucket(o.Bucket)}, nil
+}
+
+func (s *GCSSource) Name() string {
+	return "gcs"
+}
+
+func (s *GCSSource) Matches(r *http.Request) bool {
+	return r.URL.Query().Get("gcskey") != ""
+}
+
+func (s *GCSSource) GetImage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) ([]byte, error) {
+	return s.group.Do("gcs:"+r.URL.Query().Get("gcskey"), w, r, ps, s.fetch)
+}
+
+func (s *GCSSource) fetch(w http.ResponseWriter, r *http.Request, ps httprouter.Params) ([]byte, error) {
+	key := r.URL.Query().Get("gcskey")
+
+	requestID := new(string)
+	ctx := context.WithValue(r.Context(), gcsRequestIDKey{}, requestID)
+	defer func() {
+		if *requestID != "" {
+			w.Header().Set("X-GCS-Request-ID", *requestID)
+		}
+	}()
+
+	reader, err := s.bucket.Object(key).NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, NewError(fmt.Sprintf("GCS object not found: (key=%s)", key), http.StatusNotFound)
+	}
+	if err != nil {
+		return nil, NewError(fmt.Sprintf("Error downloading GCS object: %s (key=%s)", err, key), http.StatusBadGateway)
+	}
+	defer reader.Close()
+	setLastModified(w, reader.Attrs.LastModified)
+
+	// Read straight into a buffer sized after the object length
+	buf := bytes.NewBuffer(make([]byte, 0, reader.Attrs.Size))
+	if _, err := io.Copy(buf, reader); err != nil {
+		return nil, NewError(fmt.Sprintf("Error reading GCS object: %s (key=%s)", err, key), http.StatusBadGateway)
+	}
+	return buf.Bytes(), nil
+}
+
+// gcsTransport captures the GCS request ID so it can be replied for debugging.
+type gcsTransport struct {
+	base http.RoundTripper
+}
+
+func (t gcsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	if res != nil {
+		if requestID, ok := req.Context().Value(gcsRequestIDKey{}).(*string); ok {
+			*requestID = res.Header.Get("X-Guploader-Uploadid")
+		}
+	}
+	return res, err
+}
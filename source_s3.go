@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/julienschmidt/httprouter"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+type S3Options struct {
+	Enabled bool   `yaml:"enabled"`
+	Bucket  string `yaml:"bucket"`
+	Region  string `yaml:"region"`
+	Timeout int    `yaml:"timeout"`
+	Retries int    `yaml:"retries"`
+}
+
+// S3Source reads the image defined by the s3key query param from an S3 bucket.
+// Credentials are resolved by the standard AWS environment and instance role chain.
+type S3Source struct {
+	bucket string
+	client *s3.S3
+	group  *sourceGroup
+}
+
+func NewS3Source(o S3Options) (*S3Source, error) {
+	if o.Bucket == "" {
+		return nil, fmt.Errorf("S3 source requires a bucket")
+	}
+
+	config := aws.NewConfig().
+		WithHTTPClient(&http.Client{Timeout: time.Duration(o.Timeout) * time.Second}).
+		WithMaxRetries(o.Retries)
+	if o.Region != "" {
+		config = config.WithRegion(o.Region)
+	}
+
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create S3 session: %s", err)
+	}
+
+	return &S3Source{bucket: o.Bucket, client: s3.New(sess)}, nil
+}
+
+func (s *S3Source) Name() string {
+	return "s3"
+}
+
+func (s *S3Source) Matches(r *http.Request) bool {
+	return r.URL.Query().Get("s3key") != ""
+}
+
+func (s *S3Source) GetImage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) ([]byte, error) {
+	return s.group.Do("s3:"+r.URL.Query().Get("s3key"), w, r, ps, s.fetch)
+}
+
+func (s *S3Source) fetch(w http.ResponseWriter, r *http.Request, ps httprouter.Params) ([]byte, error) {
+	buf, modified, err := s.getObject(r, r.URL.Query().Get("s3key"))
+	setLastModified(w, modified)
+	return buf, err
+}
+
+// getObject downloads the object and returns its last modification time.
+func (s *S3Source) getObject(r *http.Request, key string) ([]byte, time.Time, error) {
+	res, err := s.client.GetObjectWithContext(r.Context(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, time.Time{}, s3Error(key, err)
+	}
+	defer res.Body.Close()
+
+	buf, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, time.Time{}, NewError(fmt.Sprintf("Error reading S3 object: %s (key=%s)", err, key), http.StatusBadGateway)
+	}
+	return buf, aws.TimeValue(res.LastModified), nil
+}
+
+func s3Error(key string, err error) error {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, "NotFound":
+			return NewError(fmt.Sprintf("S3 object not found: (key=%s)", key), http.StatusNotFound)
+		}
+	}
+	return NewError(fmt.Sprintf("Error downloading S3 object: %s (key=%s)", err, key), http.StatusBadGateway)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSrcsetWidths bounds the URLs generated by a srcset request.
+const maxSrcsetWidths = 32
+
+// srcsetParams are the srcset request params not forwarded to the image URLs.
+var srcsetParams = map[string]bool{
+	"url":       true,
+	"widths":    true,
+	"operation": true,
+	"sign":      true,
+	"key":       true,
+}
+
+// Srcset is the JSON reply of the /srcset endpoint.
+type Srcset struct {
+	Srcset string   `json:"srcset"`
+	URLs   []string `json:"urls"`
+}
+
+// srcsetController replies the image URLs of the operation for every width,
+// signed when -url-signature-key is defined, and the matching srcset string.
+// The other query params are forwarded to every image URL.
+func srcsetController(o ServerOptions) httprouter.Handle {
+	// Validated by NewServerMux
+	base, _ := normalizeBasePath(o.BasePath)
+
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		query := r.URL.Query()
+		operation := query.Get("operation")
+		if operation == "" {
+			operation = "resize"
+		}
+		if !isOperation(operation) || operation == "convert" || operation == "generate" {
+			badRequest(w, fmt.Sprintf("unsupported srcset operation: %s", operation))
+			return
+		}
+		if !operationAllowed(o, operation) {
+			writeError(w, operationForbidden(operation))
+			return
+		}
+		widths, err := parseSrcsetWidths(query.Get("widths"), o)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		params := url.Values{}
+		for key, values := range query {
+			if !srcsetParams[key] {
+				params[key] = values
+			}
+		}
+
+		reply := Srcset{URLs: []string{}}
+		candidates := []string{}
+		for _, width := range widths {
+			path := (&url.URL{Path: "/" + operation + "/" + strconv.Itoa(width) + "x/" + query.Get("url")}).EscapedPath()
+			signed := url.Values{}
+			for key, values := range params {
+				signed[key] = values
+			}
+			if o.URLSignatureKey != "" {
+				// The expires param, if any, is forwarded and signed as is
+				signed.Set("sign", SignURL(o.URLSignatureKey, path, params, time.Time{}))
+			}
+
+			imageURL := requestOrigin(r, o) + base + path
+			if len(signed) > 0 {
+				imageURL += "?" + signed.Encode()
+			}
+			reply.URLs = append(reply.URLs, imageURL)
+			candidates = append(candidates, imageURL+" "+strconv.Itoa(width)+"w")
+		}
+		reply.Srcset = strings.Join(candidates, ", ")
+
+		body, _ := json.Marshal(reply)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
+
+// parseSrcsetWidths parses the comma separated widths, bounded by -max-output-width.
+func parseSrcsetWidths(value string, o ServerOptions) ([]int, error) {
+	list := parseList(value)
+	if len(list) == 0 {
+		return nil, NewError("srcset requires the widths param", http.StatusBadRequest)
+	}
+	if len(list) > maxSrcsetWidths {
+		return nil, NewError(fmt.Sprintf("srcset exceeds the maximum of %d widths", maxSrcsetWidths), http.StatusBadRequest)
+	}
+
+	widths := make([]int, len(list))
+	for i, item := range list {
+		width, err := strconv.Atoi(item)
+		if err != nil || width <= 0 || (o.MaxOutputWidth > 0 && width > o.MaxOutputWidth) {
+			return nil, NewError(fmt.Sprintf("invalid srcset width: %s", item), http.StatusBadRequest)
+		}
+		widths[i] = width
+	}
+	return widths, nil
+}
+
+// requestOrigin returns the scheme and host the request was sent to,
+// read from the X-Forwarded-Proto and X-Forwarded-Host headers with -trust-proxy.
+func requestOrigin(r *http.Request, o ServerOptions) string {
+	scheme, host := "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if o.TrustProxy {
+		// The rightmost hops are the ones appended by the trusted proxy
+		if proto := lastHop(r.Header.Get("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwarded := lastHop(r.Header.Get("X-Forwarded-Host")); forwarded != "" {
+			host = forwarded
+		}
+	}
+	return scheme + "://" + host
+}
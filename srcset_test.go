@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"gopkg.in/h2non/bimg.v1"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSrcsetWidths(t *testing.T) {
+	o := ServerOptions{MaxOutputWidth: 2000}
+	cases := []struct {
+		value    string
+		expected []int
+	}{
+		{"320,640,1280", []int{320, 640, 1280}},
+		{" 320 , 640 ", []int{320, 640}},
+		{"2000", []int{2000}},
+		{"", nil},
+		{"2001", nil},
+		{"0", nil},
+		{"-320", nil},
+		{"wide", nil},
+		{strings.Repeat("100,", maxSrcsetWidths) + "100", nil},
+	}
+
+	for _, c := range cases {
+		widths, err := parseSrcsetWidths(c.value, o)
+		if c.expected == nil {
+			if err == nil || errorCode(err) != http.StatusBadRequest {
+				t.Errorf("%q: expected status %d, got %v", c.value, http.StatusBadRequest, err)
+			}
+			continue
+		}
+		if err != nil || len(widths) != len(c.expected) {
+			t.Errorf("%q: expected %v, got %v (%v)", c.value, c.expected, widths, err)
+			continue
+		}
+		for i := range widths {
+			if widths[i] != c.expected[i] {
+				t.Errorf("%q: expected %v, got %v", c.value, c.expected, widths)
+			}
+		}
+	}
+}
+
+func TestSrcsetURLsResolve(t *testing.T) {
+	upstream := newImageServer(t, 1600, 1200)
+	o := testServerOptions()
+	o.URLSignatureKey = "secret"
+	handler, err := NewServerMux(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := url.Values{"url": {upstream.URL + "/image.jpg"}, "widths": {"320,640"}, "type": {"webp"}}
+	query.Set("sign", SignURL(o.URLSignatureKey, "/srcset", query, time.Time{}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/srcset?"+query.Encode(), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Header().Get("Error"))
+	}
+	var reply Srcset
+	if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.URLs) != 2 || !strings.HasSuffix(reply.Srcset, " 640w") {
+		t.Fatalf("unexpected srcset reply: %+v", reply)
+	}
+
+	for i, width := range []int{320, 640} {
+		imageURL, err := url.Parse(reply.URLs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", imageURL.RequestURI(), nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected status %d, got %d: %s", imageURL, http.StatusOK, w.Code, w.Header().Get("Error"))
+			continue
+		}
+		size, err := bimg.Size(w.Body.Bytes())
+		if err != nil || size.Width != width || bimg.DetermineImageType(w.Body.Bytes()) != bimg.WEBP {
+			t.Errorf("%s: expected a %dpx WebP image, got %+v (%v)", imageURL, width, size, err)
+		}
+	}
+
+	// The candidates are signed, so they cannot be tampered with
+	tampered := strings.Replace(reply.URLs[0], "/resize/320x/", "/resize/3200x/", 1)
+	imageURL, _ := url.Parse(tampered)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", imageURL.RequestURI(), nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected the tampered URL to be rejected, got %d", w.Code)
+	}
+}
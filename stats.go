@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a JSON snapshot of the server counters, cheaper than
+// the Prometheus metrics since read with no lock.
+type Stats struct {
+	Uptime     float64 `json:"uptime"`
+	Requests   uint64  `json:"requests"`
+	InFlight   int64   `json:"inFlight"`
+	BytesIn    uint64  `json:"bytesIn"`
+	BytesOut   uint64  `json:"bytesOut"`
+	Goroutines int     `json:"goroutines"`
+	HeapAlloc  uint64  `json:"heapAlloc"`
+	Throttled  uint64  `json:"throttled"`
+}
+
+func statsController(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	body, _ := json.Marshal(Stats{
+		Uptime:     time.Since(startTime).Seconds(),
+		Requests:   atomic.LoadUint64(&metrics.Requests),
+		InFlight:   atomic.LoadInt64(&metrics.InFlight),
+		BytesIn:    atomic.LoadUint64(&metrics.BytesIn),
+		BytesOut:   atomic.LoadUint64(&metrics.BytesOut),
+		Goroutines: runtime.NumGoroutine(),
+		HeapAlloc:  mem.HeapAlloc,
+		Throttled:  atomic.LoadUint64(&metrics.Throttled),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
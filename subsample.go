@@ -0,0 +1,121 @@
+package main
+
+/*
+#cgo pkg-config: vips
+#include <stdlib.h>
+#include <vips/vips.h>
+
+// subsample_save_buffer encodes the image as JPEG, or as AVIF when avif is
+// set, with the chroma subsampling mode. The JPEG subsample_mode requires
+// libvips >= 8.10, and the AVIF one libvips >= 8.13.
+static int
+subsample_save_buffer(void *buf, size_t len, int avif, int quality, int speed, int interlace, int strip, int mode, void **out, size_t *out_len) {
+	VipsImage *in = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (in == NULL) {
+		return 1;
+	}
+
+	int err = 1;
+	if (avif) {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 13))
+		err = vips_heifsave_buffer(in, out, out_len,
+			"Q", quality,
+			"compression", VIPS_FOREIGN_HEIF_COMPRESSION_AV1,
+			"speed", speed,
+			"strip", strip,
+			"subsample_mode", mode,
+			NULL);
+#else
+		vips_error("subsample", "AVIF chroma subsampling requires libvips >= 8.13");
+#endif
+	} else {
+#if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 10))
+		err = vips_jpegsave_buffer(in, out, out_len,
+			"Q", quality,
+			"optimize_coding", TRUE,
+			"interlace", interlace,
+			"strip", strip,
+			"subsample_mode", mode,
+			NULL);
+#else
+		vips_error("subsample", "JPEG chroma subsampling requires libvips >= 8.10");
+#endif
+	}
+
+	g_object_unref(in);
+	return err;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/h2non/bimg.v1"
+	"net/http"
+	"net/url"
+	"unsafe"
+)
+
+// subsampleModes maps the subsample param to the libvips modes:
+// 420 always subsamples the chroma, 444 never does.
+var subsampleModes = map[string]C.int{
+	"420": C.VIPS_FOREIGN_SUBSAMPLE_ON,
+	"444": C.VIPS_FOREIGN_SUBSAMPLE_OFF,
+}
+
+// readSubsampleParams reads the subsample param. The default auto mode
+// lets libvips subsample the JPEG images below quality 90.
+func readSubsampleParams(query url.Values, opts *Options) error {
+	switch mode := query.Get("subsample"); mode {
+	case "", "auto":
+		opts.Subsample = ""
+	case "420", "444":
+		opts.Subsample = mode
+	default:
+		return NewError(fmt.Sprintf("unsupported subsample: %s", mode), http.StatusBadRequest)
+	}
+	return nil
+}
+
+// checkSubsample rejects the subsample param for the output types with no
+// chroma subsampling control. Lossy WebP images are always 4:2:0.
+func checkSubsample(kind bimg.ImageType, opts Options) error {
+	switch {
+	case opts.Subsample == "" || kind == bimg.JPEG || kind == bimg.AVIF:
+		return nil
+	case kind == bimg.WEBP && opts.Subsample == "420" && !opts.WebP.Lossless:
+		return nil
+	case kind == bimg.WEBP:
+		return NewError("WebP output only supports subsample=420, in lossy mode", http.StatusBadRequest)
+	}
+	return NewError(fmt.Sprintf("subsample param is not supported by the %s output type", bimg.ImageTypeName(kind)), http.StatusBadRequest)
+}
+
+// saveSubsampled encodes a losslessly processed image as JPEG or AVIF
+// with the chroma subsampling mode, which bimg does not support.
+func saveSubsampled(image []byte, kind bimg.ImageType, quality, speed int, interlace, strip bool, mode string) ([]byte, error) {
+	if len(image) == 0 {
+		return nil, errors.New("empty image")
+	}
+
+	avif, cInterlace, cStrip := C.int(0), C.int(0), C.int(0)
+	if kind == bimg.AVIF {
+		avif = 1
+	}
+	if interlace {
+		cInterlace = 1
+	}
+	if strip {
+		cStrip = 1
+	}
+
+	var out unsafe.Pointer
+	var length C.size_t
+	if C.subsample_save_buffer(unsafe.Pointer(&image[0]), C.size_t(len(image)), avif, C.int(quality), C.int(speed),
+		cInterlace, cStrip, subsampleModes[mode], &out, &length) != 0 {
+		return nil, vipsErrorf("cannot encode subsampled image")
+	}
+	defer C.g_free(C.gpointer(out))
+	return C.GoBytes(out, C.int(length)), nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// errSVGExternal is replied for the SVG images able to load external resources.
+var errSVGExternal = NewError("SVG images cannot define a DOCTYPE or reference external resources", http.StatusBadRequest)
+
+// cssReference matches the start of the CSS url() and @import references,
+// quoted or not. The closing delimiters are optional, as in CSS.
+var cssReference = regexp.MustCompile(`(?i)(?:@import\s*(?:url\()?|url\()\s*["']?\s*([^"')\s]*)`)
+
+// checkSVG tokenizes the SVG image and rejects the constructs able to load
+// external resources: any DOCTYPE, which defines the XML entities, the
+// processing instructions other than the XML declaration, and the href
+// attributes and CSS references which are not embedded data URIs or
+// references to the same document.
+func checkSVG(buf []byte) error {
+	decoder := xml.NewDecoder(bytes.NewReader(buf))
+	style := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return NewError("invalid SVG image: "+err.Error(), http.StatusBadRequest)
+		}
+
+		switch t := token.(type) {
+		case xml.Directive:
+			return errSVGExternal
+		case xml.ProcInst:
+			if t.Target != "xml" {
+				return errSVGExternal
+			}
+		case xml.StartElement:
+			if t.Name.Local == "style" {
+				style++
+			}
+			for _, attr := range t.Attr {
+				if attr.Name.Local == "href" && !svgLocalReference(attr.Value) {
+					return errSVGExternal
+				}
+				if !cssLocal(attr.Value) {
+					return errSVGExternal
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "style" {
+				style--
+			}
+		case xml.CharData:
+			if style > 0 && !cssLocal(string(t)) {
+				return errSVGExternal
+			}
+		}
+	}
+}
+
+// cssLocal reports whether every CSS reference is local. Escaped CSS is
+// rejected, since escapes can spell the references out.
+func cssLocal(css string) bool {
+	if strings.Contains(css, `\`) {
+		return false
+	}
+	for _, match := range cssReference.FindAllStringSubmatch(css, -1) {
+		if !svgLocalReference(match[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// svgLocalReference reports whether the reference is an embedded data URI
+// or a fragment of the same document.
+func svgLocalReference(ref string) bool {
+	ref = strings.TrimSpace(ref)
+	return ref == "" || strings.HasPrefix(ref, "#") || strings.HasPrefix(strings.ToLower(ref), "data:")
+}
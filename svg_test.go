@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCheckSVG(t *testing.T) {
+	cases := []struct {
+		name  string
+		svg   string
+		valid bool
+	}{
+		{"plain", `<svg xmlns="http://www.w3.org/2000/svg"><rect width="10" height="10"/></svg>`, true},
+		{"xml declaration", `<?xml version="1.0" encoding="UTF-8"?><svg xmlns="http://www.w3.org/2000/svg"/>`, true},
+		{"fragment href", `<svg xmlns="http://www.w3.org/2000/svg"><use href="#shape"/></svg>`, true},
+		{"data href", `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"><image xlink:href="data:image/png;base64,AAAA"/></svg>`, true},
+		{"fragment url", `<svg xmlns="http://www.w3.org/2000/svg"><rect fill="url(#gradient)"/></svg>`, true},
+		{"doctype", `<!DOCTYPE svg PUBLIC "-//W3C//DTD SVG 1.1//EN" "http://www.w3.org/Graphics/SVG/1.1/DTD/svg11.dtd"><svg xmlns="http://www.w3.org/2000/svg"/>`, false},
+		{"entity", `<!DOCTYPE svg [<!ENTITY file SYSTEM "file:///etc/passwd">]><svg xmlns="http://www.w3.org/2000/svg"><text>&file;</text></svg>`, false},
+		{"undefined entity", `<svg xmlns="http://www.w3.org/2000/svg"><text>&file;</text></svg>`, false},
+		{"stylesheet", `<?xml-stylesheet href="http://example.com/style.css"?><svg xmlns="http://www.w3.org/2000/svg"/>`, false},
+		{"network href", `<svg xmlns="http://www.w3.org/2000/svg"><image href="http://example.com/image.png"/></svg>`, false},
+		{"file xlink href", `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"><image xlink:href="file:///etc/passwd"/></svg>`, false},
+		{"relative href", `<svg xmlns="http://www.w3.org/2000/svg"><image href="image.png"/></svg>`, false},
+		{"spaced href", `<svg xmlns="http://www.w3.org/2000/svg"><image href="  http://example.com/image.png"/></svg>`, false},
+		{"attribute url", `<svg xmlns="http://www.w3.org/2000/svg"><rect fill="url(http://example.com/paint.svg#p)"/></svg>`, false},
+		{"style attribute url", `<svg xmlns="http://www.w3.org/2000/svg"><rect style="fill: URL( 'file:///etc/passwd' )"/></svg>`, false},
+		{"style element import", `<svg xmlns="http://www.w3.org/2000/svg"><style>@import "http://example.com/style.css";</style></svg>`, false},
+		{"style element import url", `<svg xmlns="http://www.w3.org/2000/svg"><style>@import url(http://example.com/style.css);</style></svg>`, false},
+		{"style element cdata url", `<svg xmlns="http://www.w3.org/2000/svg"><style><![CDATA[rect { fill: url(http://example.com/p.svg#p) }]]></style></svg>`, false},
+		{"unterminated url", `<svg xmlns="http://www.w3.org/2000/svg"><style>rect { fill: url(http://example.com/p.svg</style></svg>`, false},
+		{"escaped css", `<svg xmlns="http://www.w3.org/2000/svg"><style>rect { fill: u\72l(http://example.com/p.svg) }</style></svg>`, false},
+		{"style element data url", `<svg xmlns="http://www.w3.org/2000/svg"><style>rect { fill: url("data:image/png;base64,AAAA") }</style></svg>`, true},
+		{"malformed", `<svg xmlns="http://www.w3.org/2000/svg"><rect></svg>`, false},
+	}
+
+	for _, c := range cases {
+		err := checkSVG([]byte(c.svg))
+		if c.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", c.name, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%s: expected the SVG image to be rejected", c.name)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+/*
+#cgo pkg-config: vips
+#include <vips/vips.h>
+
+// thumbnail_buffer shrinks the image on load, decoding JPEG and WebP images
+// at a reduced resolution, and saves it as uncompressed PNG.
+static int
+thumbnail_buffer(void *buf, size_t len, int width, int height, int crop, int size, int no_rotate, void **out, size_t *out_len) {
+	VipsImage *image;
+	if (vips_thumbnail_buffer(buf, len, &image, width, "height", height,
+		"crop", crop, "size", size, "no_rotate", no_rotate, NULL)) {
+		return 1;
+	}
+
+	int err = vips_image_write_to_buffer(image, ".png", out, out_len, "compression", 0, NULL);
+	g_object_unref(image);
+	return err;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/h2non/bimg.v1"
+	"math"
+	"net/http"
+	"net/url"
+	"unsafe"
+)
+
+// thumbnailUnbounded is the unconstrained dimension, as vips_thumbnail
+// requires a width.
+const thumbnailUnbounded = 10000000
+
+var thumbnailCrops = map[string]C.int{
+	"none":      C.VIPS_INTERESTING_NONE,
+	"centre":    C.VIPS_INTERESTING_CENTRE,
+	"entropy":   C.VIPS_INTERESTING_ENTROPY,
+	"attention": C.VIPS_INTERESTING_ATTENTION,
+}
+
+var thumbnailSizes = map[string]C.int{
+	"both":  C.VIPS_SIZE_BOTH,
+	"down":  C.VIPS_SIZE_DOWN,
+	"force": C.VIPS_SIZE_FORCE,
+}
+
+// readThumbnailParams reads the thumbnail operation crop and size modes.
+func readThumbnailParams(query url.Values, opts *Options) error {
+	if opts.Operation != "thumbnail" {
+		return nil
+	}
+	if opts.Width == 0 && opts.Height == 0 {
+		return NewError("thumbnail operation requires width or height", http.StatusBadRequest)
+	}
+
+	opts.ThumbnailCrop, opts.ThumbnailSize = "none", "both"
+	if name := query.Get("crop"); name != "" {
+		if _, ok := thumbnailCrops[name]; !ok {
+			return NewError(fmt.Sprintf("unsupported thumbnail crop: %s", name), http.StatusBadRequest)
+		}
+		opts.ThumbnailCrop = name
+	}
+	if name := query.Get("size"); name != "" {
+		if _, ok := thumbnailSizes[name]; !ok {
+			return NewError(fmt.Sprintf("unsupported thumbnail size: %s", name), http.StatusBadRequest)
+		}
+		opts.ThumbnailSize = name
+	}
+	return nil
+}
+
+// thumbnail resizes the image with libvips thumbnail, which is faster and
+// uses less memory than the full decoding followed by the resize, and then
+// encodes it with the output params.
+func thumbnail(image []byte, params bimg.Options, opts Options) ([]byte, error) {
+	if len(image) == 0 {
+		return nil, errors.New("empty image")
+	}
+
+	width, height := C.int(opts.Width), C.int(opts.Height)
+	if width == 0 {
+		width = thumbnailUnbounded
+	}
+	if height == 0 {
+		height = thumbnailUnbounded
+	}
+	noRotate := C.int(0)
+	if opts.NoAutoRotate {
+		noRotate = 1
+	}
+
+	var out unsafe.Pointer
+	var length C.size_t
+	if C.thumbnail_buffer(unsafe.Pointer(&image[0]), C.size_t(len(image)), width, height,
+		thumbnailCrops[opts.ThumbnailCrop], thumbnailSizes[opts.ThumbnailSize], noRotate, &out, &length) != 0 {
+		return nil, vipsErrorf("cannot create thumbnail")
+	}
+	thumb := C.GoBytes(out, C.int(length))
+	C.g_free(C.gpointer(out))
+
+	if params.Type == bimg.UNKNOWN {
+		params.Type = bimg.DetermineImageType(image)
+	}
+	return bimg.Resize(thumb, bimg.Options{
+		Type:          params.Type,
+		Quality:       params.Quality,
+		Compression:   params.Compression,
+		Speed:         params.Speed,
+		Interlace:     params.Interlace,
+		NoProfile:     params.NoProfile,
+		StripMetadata: params.StripMetadata,
+		Lossless:      params.Lossless,
+		Background:    params.Background,
+		NoAutoRotate:  true,
+	})
+}
+
+// thumbnailSize mirrors the libvips thumbnail geometry.
+func thumbnailSize(inWidth, inHeight int, opts Options) (int, int) {
+	width, height := opts.Width, opts.Height
+	if opts.ThumbnailSize == "force" && width > 0 && height > 0 {
+		return width, height
+	}
+
+	hscale, vscale := math.Inf(1), math.Inf(1)
+	if width > 0 {
+		hscale = float64(width) / float64(inWidth)
+	}
+	if height > 0 {
+		vscale = float64(height) / float64(inHeight)
+	}
+	scale := math.Min(hscale, vscale)
+	cropped := opts.ThumbnailCrop != "none" && width > 0 && height > 0
+	if cropped {
+		scale = math.Max(hscale, vscale)
+	}
+	if opts.ThumbnailSize == "down" && scale > 1 {
+		scale = 1
+	}
+
+	outWidth := int(math.Round(float64(inWidth) * scale))
+	outHeight := int(math.Round(float64(inHeight) * scale))
+	if cropped {
+		outWidth, outHeight = int(math.Min(float64(outWidth), float64(width))), int(math.Min(float64(outHeight), float64(height)))
+	}
+	return outWidth, outHeight
+}
@@ -0,0 +1,101 @@
+package main
+
+/*
+#cgo pkg-config: vips
+#include <stdlib.h>
+#include <vips/vips.h>
+
+// tiff_save_buffer encodes the image as TIFF. The quality only applies
+// to the JPEG compression, and the predictor to LZW and Deflate.
+static int
+tiff_save_buffer(void *buf, size_t len, int compression, int predictor, int quality, void **out, size_t *out_len) {
+	VipsImage *in = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (in == NULL) {
+		return 1;
+	}
+
+	int err = vips_tiffsave_buffer(in, out, out_len,
+		"compression", compression,
+		"predictor", predictor,
+		"Q", quality,
+		NULL);
+
+	g_object_unref(in);
+	return err;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"unsafe"
+)
+
+// tiffCompressions maps the tiff-compression param to VipsForeignTiffCompression.
+var tiffCompressions = map[string]int{
+	"none":    C.VIPS_FOREIGN_TIFF_COMPRESSION_NONE,
+	"jpeg":    C.VIPS_FOREIGN_TIFF_COMPRESSION_JPEG,
+	"deflate": C.VIPS_FOREIGN_TIFF_COMPRESSION_DEFLATE,
+	"lzw":     C.VIPS_FOREIGN_TIFF_COMPRESSION_LZW,
+}
+
+// tiffPredictors maps the tiff-predictor param to VipsForeignTiffPredictor.
+var tiffPredictors = map[string]int{
+	"none":       C.VIPS_FOREIGN_TIFF_PREDICTOR_NONE,
+	"horizontal": C.VIPS_FOREIGN_TIFF_PREDICTOR_HORIZONTAL,
+	"float":      C.VIPS_FOREIGN_TIFF_PREDICTOR_FLOAT,
+}
+
+// TIFFOptions defines the TIFF encoding compression and predictor names.
+type TIFFOptions struct {
+	Compression string
+	Predictor   string
+}
+
+// custom reports whether the options require encoding with libvips,
+// since bimg always saves uncompressed TIFF images.
+func (o TIFFOptions) custom() bool {
+	return o.Compression != "" || o.Predictor != ""
+}
+
+// readTIFFParams reads the tiff-compression and tiff-predictor params.
+func readTIFFParams(query url.Values, opts *Options) error {
+	if name := query.Get("tiff-compression"); name != "" {
+		if _, ok := tiffCompressions[name]; !ok {
+			return NewError("invalid tiff-compression param: must be none, lzw, deflate or jpeg", http.StatusBadRequest)
+		}
+		opts.TIFF.Compression = name
+	}
+	if name := query.Get("tiff-predictor"); name != "" {
+		if _, ok := tiffPredictors[name]; !ok {
+			return NewError("invalid tiff-predictor param: must be none, horizontal or float", http.StatusBadRequest)
+		}
+		opts.TIFF.Predictor = name
+	}
+	return nil
+}
+
+// saveTIFF encodes a losslessly processed image as TIFF.
+func saveTIFF(image []byte, quality int, o TIFFOptions) ([]byte, error) {
+	if len(image) == 0 {
+		return nil, errors.New("empty image")
+	}
+
+	compression, predictor := tiffCompressions["none"], tiffPredictors["horizontal"]
+	if o.Compression != "" {
+		compression = tiffCompressions[o.Compression]
+	}
+	if o.Predictor != "" {
+		predictor = tiffPredictors[o.Predictor]
+	}
+
+	var out unsafe.Pointer
+	var length C.size_t
+	if C.tiff_save_buffer(unsafe.Pointer(&image[0]), C.size_t(len(image)), C.int(compression), C.int(predictor), C.int(quality), &out, &length) != 0 {
+		return nil, vipsErrorf("cannot encode TIFF image")
+	}
+	defer C.g_free(C.gpointer(out))
+	return C.GoBytes(out, C.int(length)), nil
+}
@@ -0,0 +1,100 @@
+package main
+
+/*
+#cgo pkg-config: vips
+#include <vips/vips.h>
+
+// tile_buffer pads the watermark with spacing transparent pixels, rotates it,
+// and replicates it across the width and height, saved as PNG. A tile is
+// anchored at the gx and gy fractions of the free width and height.
+static int
+tile_buffer(void *buf, size_t len, int spacing, double angle, double gx, double gy, int width, int height, void **out, size_t *out_len) {
+	VipsImage *base = vips_image_new(), **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 5);
+	VipsImage *mark = t[0] = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (mark == NULL) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	if (!vips_image_hasalpha(mark)) {
+		if (vips_addalpha(mark, &t[1], NULL)) {
+			g_object_unref(base);
+			return 1;
+		}
+		mark = t[1];
+	}
+	if (vips_embed(mark, &t[2], 0, 0, mark->Xsize + spacing, mark->Ysize + spacing, NULL)) {
+		g_object_unref(base);
+		return 1;
+	}
+	mark = t[2];
+	if (angle != 0) {
+		if (vips_similarity(mark, &t[3], "angle", angle, NULL)) {
+			g_object_unref(base);
+			return 1;
+		}
+		mark = t[3];
+	}
+
+	int tw = mark->Xsize, th = mark->Ysize;
+	int left = ((int) (gx * (width - tw)) % tw + tw) % tw, top = ((int) (gy * (height - th)) % th + th) % th;
+	int ox = (tw - left) % tw, oy = (th - top) % th;
+	int across = (width + ox + tw - 1) / tw, down = (height + oy + th - 1) / th;
+	if (vips_replicate(mark, &t[4], across, down, NULL)) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	VipsImage *tiled;
+	int err = vips_extract_area(t[4], &tiled, ox, oy, width, height, NULL);
+	if (err == 0) {
+		err = vips_image_write_to_buffer(tiled, ".png", out, out_len, NULL);
+		g_object_unref(tiled);
+	}
+	g_object_unref(base);
+	return err;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"gopkg.in/h2non/bimg.v1"
+	"strings"
+	"unsafe"
+)
+
+// defaultTileSpacing is the distance in pixels between the tiled watermarks.
+const defaultTileSpacing = 100
+
+// tileWatermark repeats the watermark across the image size with libvips
+// replicate, which references the same tile instead of compositing it
+// once per position. The grid is aligned to a tile anchored by gravity.
+func tileWatermark(mark []byte, size bimg.ImageSize, w WatermarkOptions) ([]byte, error) {
+	if len(mark) == 0 {
+		return nil, errors.New("empty watermark image")
+	}
+
+	gx, gy := 0.0, 0.0
+	if strings.HasSuffix(w.Gravity, "east") {
+		gx = 1
+	}
+	if strings.HasPrefix(w.Gravity, "south") {
+		gy = 1
+	}
+	if w.Gravity == "north" || w.Gravity == "south" || w.Gravity == "centre" || w.Gravity == "center" {
+		gx = 0.5
+	}
+	if w.Gravity == "east" || w.Gravity == "west" || w.Gravity == "centre" || w.Gravity == "center" {
+		gy = 0.5
+	}
+
+	var out unsafe.Pointer
+	var length C.size_t
+	if C.tile_buffer(unsafe.Pointer(&mark[0]), C.size_t(len(mark)), C.int(w.Spacing), C.double(w.Angle), C.double(gx), C.double(gy),
+		C.int(size.Width), C.int(size.Height), &out, &length) != 0 {
+		return nil, vipsErrorf("cannot tile watermark")
+	}
+	defer C.g_free(C.gpointer(out))
+	return C.GoBytes(out, C.int(length)), nil
+}
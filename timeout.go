@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// statusClientClosed is the nginx non-standard status logged when the
+// client closes the connection before the response.
+const statusClientClosed = 499
+
+// maxTimeoutParam bounds the timeout param seconds, preventing the
+// duration overflow when there is no server max.
+const maxTimeoutParam = 1 << 31
+
+// Processing bounds the image processing of a request by the
+// -processing-timeout, or the timeout param, and by the client connection.
+type Processing struct {
+	ctx       context.Context
+	requested bool
+	running   sync.WaitGroup
+}
+
+func newProcessing(r *http.Request, o ServerOptions) (*Processing, context.CancelFunc, error) {
+	timeout, requested, err := requestTimeout(r.URL.Query(), o)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(r.Context(), timeout)
+	}
+	return &Processing{ctx: ctx, requested: requested}, cancel, nil
+}
+
+// requestTimeout returns the processing deadline defined by the timeout
+// param, in seconds, clamped to the -max-processing-timeout, which defaults
+// to the -processing-timeout. With no param, the -processing-timeout applies.
+func requestTimeout(query url.Values, o ServerOptions) (time.Duration, bool, error) {
+	timeout := time.Duration(o.ProcessingTimeout) * time.Second
+	value := query.Get("timeout")
+	if value == "" {
+		return timeout, false, nil
+	}
+
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds <= 0 || math.IsInf(seconds, 0) || math.IsNaN(seconds) {
+		return 0, false, NewError("invalid timeout param: must be a positive number of seconds", http.StatusBadRequest)
+	}
+	limit := o.MaxProcessingTimeout
+	if limit == 0 {
+		limit = o.ProcessingTimeout
+	}
+	if limit > 0 && seconds > float64(limit) {
+		seconds = float64(limit)
+	}
+	seconds = math.Min(seconds, maxTimeoutParam)
+	return time.Duration(seconds * float64(time.Second)), true, nil
+}
+
+// maxAbandonedOps bounds the abandoned operations still running in
+// background, which are not bounded by the queue with no -max-concurrent-ops.
+var maxAbandonedOps = int32(4 * runtime.NumCPU())
+
+// abandonedOps counts the abandoned operations still running.
+var abandonedOps int32
+
+// Run runs the operation until done or the context is canceled. The
+// operation runs on a locked OS thread, so on cancellation the libvips
+// images it evaluates are killed, and the abandoned operation stops at its
+// next work unit. The request is replied without waiting for it.
+func (p *Processing) Run(process func() ([]byte, error)) ([]byte, error) {
+	if err := p.ctx.Err(); err != nil {
+		return nil, processingError(err, p.requested)
+	}
+	if atomic.LoadInt32(&abandonedOps) >= maxAbandonedOps {
+		return nil, NewError("too many abandoned operations still running", http.StatusServiceUnavailable)
+	}
+
+	type result struct {
+		image []byte
+		err   error
+	}
+	done := make(chan result, 1)
+	cancel := newVipsCancel()
+	// state is running (0), done (1) or abandoned (2)
+	var state int32
+	p.running.Add(1)
+	go func() {
+		defer p.running.Done()
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		cancel.bind()
+		defer cancel.done()
+
+		image, err := process()
+		// The error buffer is read and cleared by the operation thread
+		if message := strings.TrimSpace(drainVipsErrors()); message != "" {
+			debug("libvips: %s", message)
+		}
+		err = asVipsError(err)
+		if !atomic.CompareAndSwapInt32(&state, 0, 1) {
+			atomic.AddInt32(&abandonedOps, -1)
+		}
+		done <- result{image, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.image, res.err
+	case <-p.ctx.Done():
+		cancel.Cancel()
+		if atomic.CompareAndSwapInt32(&state, 0, 2) {
+			atomic.AddInt32(&abandonedOps, 1)
+		}
+		return nil, processingError(p.ctx.Err(), p.requested)
+	}
+}
+
+// Release releases the processing slot once the abandoned operations are
+// done, so they keep holding it while still running.
+func (p *Processing) Release(release func()) {
+	go func() {
+		p.running.Wait()
+		release()
+	}()
+}
+
+// processingError replies 504 for the deadline requested by the client,
+// and 503 for the server deadline.
+func processingError(err error, requested bool) error {
+	if err == context.DeadlineExceeded && requested {
+		return NewError("request timeout exceeded", http.StatusGatewayTimeout)
+	}
+	if err == context.DeadlineExceeded {
+		return NewError("processing timeout exceeded", http.StatusServiceUnavailable)
+	}
+	return NewError("client closed request", statusClientClosed)
+}
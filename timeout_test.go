@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProcessingRunCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	processing := &Processing{ctx: ctx}
+	unblock := make(chan struct{})
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, err := processing.Run(func() ([]byte, error) {
+		<-unblock
+		return []byte("image"), nil
+	})
+	if err == nil || errorCode(err) != statusClientClosed {
+		t.Fatalf("expected a client closed error, got %v", err)
+	}
+
+	released := make(chan struct{})
+	processing.Release(func() { close(released) })
+	select {
+	case <-released:
+		t.Fatal("expected the slot to be held by the abandoned operation")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(unblock)
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatal("expected the slot to be released once the operation is done")
+	}
+	if count := atomic.LoadInt32(&abandonedOps); count != 0 {
+		t.Errorf("expected no abandoned operations, got %d", count)
+	}
+}
+
+func TestProcessingRunDeadline(t *testing.T) {
+	cases := []struct {
+		requested bool
+		expected  int
+	}{
+		{true, http.StatusGatewayTimeout},
+		{false, http.StatusServiceUnavailable},
+	}
+
+	for _, c := range cases {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		processing := &Processing{ctx: ctx, requested: c.requested}
+		_, err := processing.Run(func() ([]byte, error) {
+			time.Sleep(50 * time.Millisecond)
+			return nil, nil
+		})
+		cancel()
+		if errorCode(err) != c.expected {
+			t.Errorf("requested %t: expected status %d, got %v", c.requested, c.expected, err)
+		}
+		processing.running.Wait()
+	}
+}
+
+func TestProcessingRunAbandonedLimit(t *testing.T) {
+	defer func(max int32) { maxAbandonedOps = max }(maxAbandonedOps)
+	maxAbandonedOps = 1
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	unblock := make(chan struct{})
+	abandoned := &Processing{ctx: ctx}
+	abandoned.Run(func() ([]byte, error) {
+		<-unblock
+		return nil, nil
+	})
+
+	processing := &Processing{ctx: context.Background()}
+	_, err := processing.Run(func() ([]byte, error) {
+		return []byte("image"), nil
+	})
+	if errorCode(err) != http.StatusServiceUnavailable {
+		t.Errorf("expected the abandoned operations limit to reply 503, got %v", err)
+	}
+
+	close(unblock)
+	abandoned.running.Wait()
+	image, err := processing.Run(func() ([]byte, error) {
+		return []byte("image"), nil
+	})
+	if err != nil || string(image) != "image" {
+		t.Errorf("expected the operation to run once the abandoned one is done, got %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+/*
+#cgo pkg-config: vips
+#include <stdlib.h>
+#include <vips/vips.h>
+
+// find_trim_buffer finds the bounding box of the non-background area. The
+// background defaults to the top-left pixel color.
+static int
+find_trim_buffer(void *buf, size_t len, int autorotate, double threshold, double *rgb, int has_background,
+	int *left, int *top, int *width, int *height) {
+	VipsImage *in = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (in == NULL) {
+		return 1;
+	}
+
+	// Intermediate images are released with the input image
+	VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(in), 1);
+	VipsImage *image = in;
+	if (autorotate) {
+		if (vips_autorot(image, &t[0], NULL)) {
+			g_object_unref(in);
+			return 1;
+		}
+		image = t[0];
+	}
+
+	// The background requires a value per band, excluding alpha
+	int bands = image->Bands;
+	if (vips_image_hasalpha(image)) {
+		bands--;
+	}
+	if (bands > 3) {
+		bands = 3;
+	}
+
+	double values[3];
+	if (has_background) {
+		for (int i = 0; i < bands; i++) {
+			values[i] = bands < 3 ? rgb[0] : rgb[i];
+		}
+	} else {
+		double *point;
+		int n;
+		if (vips_getpoint(image, &point, &n, 0, 0, NULL)) {
+			g_object_unref(in);
+			return 1;
+		}
+		for (int i = 0; i < bands && i < n; i++) {
+			values[i] = point[i];
+		}
+		g_free(point);
+	}
+
+	VipsArrayDouble *background = vips_array_double_new(values, bands);
+	int err = vips_find_trim(image, left, top, width, height,
+		"threshold", threshold, "background", background, NULL);
+	vips_area_unref(VIPS_AREA(background));
+
+	g_object_unref(in);
+	return err;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"gopkg.in/h2non/bimg.v1"
+	"net/url"
+	"unsafe"
+)
+
+// trimHeader reports whether the trim operation removed any border.
+const trimHeader = "X-Trim-Applied"
+
+// defaultTrimThreshold is the libvips default background difference threshold.
+const defaultTrimThreshold = 10
+
+// readTrimParams reads the trim operation threshold and background.
+func readTrimParams(query url.Values, opts *Options) error {
+	if opts.Operation != "trim" {
+		return nil
+	}
+
+	opts.Threshold = defaultTrimThreshold
+	if query.Get("threshold") != "" {
+		threshold, err := parseFloatParam(query, "threshold", 0, 255)
+		if err != nil {
+			return err
+		}
+		opts.Threshold = threshold
+	}
+	return readBackground(query, opts)
+}
+
+// findTrim returns the region of the auto rotated image within the uniform
+// borders, and whether any border was found. Images with no border, or
+// entirely uniform, return the whole image region.
+func findTrim(image []byte, opts Options) (Region, bool, error) {
+	if len(image) == 0 {
+		return Region{}, false, errors.New("empty image")
+	}
+
+	var rgb []C.double
+	hasBackground := C.int(0)
+	if opts.Background != nil {
+		rgb = []C.double{C.double(opts.Background.R), C.double(opts.Background.G), C.double(opts.Background.B)}
+		hasBackground = 1
+	} else {
+		rgb = []C.double{0, 0, 0}
+	}
+	autorotate := C.int(0)
+	if !opts.NoAutoRotate {
+		autorotate = 1
+	}
+
+	var left, top, width, height C.int
+	if C.find_trim_buffer(unsafe.Pointer(&image[0]), C.size_t(len(image)), autorotate, C.double(opts.Threshold),
+		&rgb[0], hasBackground, &left, &top, &width, &height) != 0 {
+		return Region{}, false, vipsErrorf("cannot trim image")
+	}
+
+	meta, err := bimg.Metadata(image)
+	if err != nil {
+		return Region{}, false, err
+	}
+	imageWidth, imageHeight := orientedSize(meta, opts.NoAutoRotate)
+	if width == 0 || height == 0 {
+		left, top, width, height = 0, 0, C.int(imageWidth), C.int(imageHeight)
+	}
+
+	pixels := func(value C.int) Coordinate {
+		return Coordinate{Value: float64(value), Defined: true}
+	}
+	region := Region{Left: pixels(left), Top: pixels(top), Width: pixels(width), Height: pixels(height)}
+	return region, int(width) != imageWidth || int(height) != imageHeight, nil
+}
+
+// trim crops the uniform borders, unless already found by the controller,
+// and then resizes the image if a size is requested.
+func trim(image []byte, params bimg.Options, opts Options) ([]byte, error) {
+	region := opts.Region
+	if !region.Width.Defined {
+		var err error
+		if region, _, err = findTrim(image, opts); err != nil {
+			return nil, err
+		}
+	}
+	return extract(image, params, region)
+}
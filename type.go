@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/h2non/bimg.v1"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// heifBrands stores the ISO BMFF major brands used by HEIF/HEIC images.
+var heifBrands = [][]byte{
+	[]byte("heic"), []byte("heix"), []byte("hevc"), []byte("hevx"),
+	[]byte("heim"), []byte("heis"), []byte("mif1"), []byte("msf1"),
+}
+
+// isHEIF sniffs the HEIF/HEIC magic bytes: an ftyp box with a HEIF brand.
+func isHEIF(buf []byte) bool {
+	if len(buf) < 12 || !bytes.Equal(buf[4:8], []byte("ftyp")) {
+		return false
+	}
+	for _, brand := range heifBrands {
+		if bytes.Equal(buf[8:12], brand) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkImageType verifies libvips is able to decode the given image.
+func checkImageType(buf []byte) error {
+	if bimg.DetermineImageType(buf) != bimg.UNKNOWN {
+		return nil
+	}
+	if isHEIF(buf) {
+		return NewError("HEIF/HEIC images require libvips compiled with libheif support", http.StatusUnsupportedMediaType)
+	}
+	if isPDF(buf) {
+		return NewError("PDF documents require libvips compiled with poppler support", http.StatusUnsupportedMediaType)
+	}
+	if bimg.IsSVGImage(buf) {
+		return NewError("SVG images require libvips compiled with librsvg support", http.StatusUnsupportedMediaType)
+	}
+	return NewError("unsupported or unknown image type", http.StatusUnsupportedMediaType)
+}
+
+// negotiatedTypes are the output types selected from the Accept header, by preference.
+var negotiatedTypes = []struct {
+	mime string
+	code bimg.ImageType
+}{
+	{"image/avif", bimg.AVIF},
+	{"image/webp", bimg.WEBP},
+}
+
+// acceptedValues parses an Accept style header, excluding the q=0 values.
+func acceptedValues(header string) map[string]bool {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		value := strings.ToLower(strings.TrimSpace(fields[0]))
+		accepted[value] = true
+		for _, field := range fields[1:] {
+			field = strings.TrimSpace(field)
+			if q, err := strconv.ParseFloat(strings.TrimPrefix(field, "q="), 64); strings.HasPrefix(field, "q=") && err == nil && q == 0 {
+				accepted[value] = false
+			}
+		}
+	}
+	return accepted
+}
+
+// negotiateType returns the preferred output type accepted by the client,
+// or UNKNOWN to keep the source image type.
+func negotiateType(accept string) bimg.ImageType {
+	accepted := acceptedValues(accept)
+	for _, t := range negotiatedTypes {
+		if accepted[t.mime] && bimg.IsTypeSupportedSave(t.code) {
+			return t.code
+		}
+	}
+	return bimg.UNKNOWN
+}
+
+// parseDefaultFormat returns the -default-format output type,
+// or UNKNOWN for auto, which keeps the source image type.
+func parseDefaultFormat(name string) (bimg.ImageType, error) {
+	if name == "" || name == "auto" {
+		return bimg.UNKNOWN, nil
+	}
+	if code, ok := lookupImageType(name); ok {
+		return code, nil
+	}
+	return bimg.UNKNOWN, fmt.Errorf("invalid default format: %s", name)
+}
@@ -1,6 +1,12 @@
 package main
 
-import "gopkg.in/h2non/bimg.v0"
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/h2non/bimg.v1"
+	"net/http"
+	"runtime"
+)
 
 const Version = "0.1.2"
 
@@ -11,3 +17,42 @@ type Versions struct {
 }
 
 var CurrentVersions = Versions{Version, bimg.Version, bimg.VipsVersion}
+
+// BuildInfo describes the build and the libvips capabilities.
+type BuildInfo struct {
+	Versions
+	GoVersion string                   `json:"go"`
+	Formats   map[string]FormatSupport `json:"formats"`
+	Features  map[string]bool          `json:"features"`
+}
+
+// FormatSupport reports whether libvips is able to load and save an image format.
+type FormatSupport struct {
+	Load bool `json:"load"`
+	Save bool `json:"save"`
+}
+
+func NewBuildInfo() BuildInfo {
+	formats := map[string]FormatSupport{}
+	for code, name := range bimg.ImageTypes {
+		formats[name] = FormatSupport{bimg.IsTypeSupported(code), bimg.IsTypeSupportedSave(code)}
+	}
+
+	return BuildInfo{
+		Versions:  CurrentVersions,
+		GoVersion: runtime.Version(),
+		Formats:   formats,
+		Features: map[string]bool{
+			"heif":      bimg.IsTypeSupported(bimg.HEIF),
+			"avif":      bimg.IsTypeSupportedSave(bimg.AVIF),
+			"magick":    bimg.IsTypeSupported(bimg.MAGICK),
+			"smartcrop": smartCropSupported,
+		},
+	}
+}
+
+func versionsController(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	body, _ := json.Marshal(NewBuildInfo())
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
@@ -0,0 +1,116 @@
+package main
+
+/*
+#cgo pkg-config: vips
+#include <stdlib.h>
+#include <vips/vips.h>
+
+// vips_self_test encodes a blank image to every format of formats, and
+// decodes it back, checking the libvips loaders and savers are available.
+static int
+vips_self_test(const char *formats) {
+	VipsImage *base = vips_image_new(), **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 2);
+	if (vips_black(&t[0], 8, 8, "bands", 3, NULL) || vips_cast(t[0], &t[1], VIPS_FORMAT_UCHAR, NULL)) {
+		g_object_unref(base);
+		return 1;
+	}
+
+	char **suffixes = g_strsplit(formats, ",", -1);
+	int err = 0;
+	for (int i = 0; suffixes[i] != NULL && err == 0; i++) {
+		void *buf;
+		size_t len;
+		if (vips_image_write_to_buffer(t[1], suffixes[i], &buf, &len, NULL)) {
+			err = 1;
+			break;
+		}
+		VipsImage *image = vips_image_new_from_buffer(buf, len, "", NULL);
+		if (image == NULL || vips_image_get_width(image) != 8) {
+			err = 1;
+		}
+		if (image != NULL) {
+			g_object_unref(image);
+		}
+		g_free(buf);
+	}
+
+	g_strfreev(suffixes);
+	g_object_unref(base);
+	return err;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// selfTestFormats are the formats every node must encode and decode.
+const selfTestFormats = ".jpg,.png"
+
+// selfTestVips encodes and decodes a trivial image, so a node with a broken
+// libvips fails to start instead of failing its first image requests.
+// libvips itself is initialized by bimg, which panics on failure.
+func selfTestVips() error {
+	formats := C.CString(selfTestFormats)
+	defer C.free(unsafe.Pointer(formats))
+	if C.vips_self_test(formats) != 0 {
+		return fmt.Errorf("libvips self test failed: %s", strings.TrimSpace(drainVipsErrors()))
+	}
+	return nil
+}
+
+// vipsSelfTest runs the startup self test, replaced by the tests
+// simulating a broken libvips install.
+var vipsSelfTest = selfTestVips
+
+// VipsOptions tunes the libvips operation cache and thread pool.
+// Negative values keep the bimg defaults.
+type VipsOptions struct {
+	CacheMax    int `yaml:"cacheMax"`
+	CacheMaxMem int `yaml:"cacheMaxMem"`
+	Concurrency int `yaml:"concurrency"`
+	MaxFiles    int `yaml:"maxFiles"`
+}
+
+// configureVips applies the tuning options and returns the effective values.
+func configureVips(o VipsOptions) VipsOptions {
+	if o.CacheMax >= 0 {
+		C.vips_cache_set_max(C.int(o.CacheMax))
+	}
+	if o.CacheMaxMem >= 0 {
+		C.vips_cache_set_max_mem(C.size_t(o.CacheMaxMem))
+	}
+	if o.Concurrency >= 0 {
+		// Zero selects the VIPS_CONCURRENCY variable or the number of cores
+		C.vips_concurrency_set(C.int(o.Concurrency))
+	}
+	if o.MaxFiles >= 0 {
+		C.vips_cache_set_max_files(C.int(o.MaxFiles))
+	}
+
+	return VipsOptions{
+		CacheMax:    int(C.vips_cache_get_max()),
+		CacheMaxMem: int(C.vips_cache_get_max_mem()),
+		Concurrency: int(C.vips_concurrency_get()),
+		MaxFiles:    int(C.vips_cache_get_max_files()),
+	}
+}
+
+// drainVipsErrors clears the libvips error buffer, so the messages left by
+// an operation, such as the warnings of the operations that succeed, do not
+// bleed into the errors of unrelated requests.
+func drainVipsErrors() string {
+	message := C.GoString(C.vips_error_buffer())
+	C.vips_error_clear()
+	return message
+}
+
+// vipsErrorf returns the libvips error of a failed operation, described by
+// the formatted message, and clears the error buffer. It must be called by
+// the same OS thread as the operation.
+func vipsErrorf(format string, args ...interface{}) error {
+	return vipsError{fmt.Sprintf(format, args...) + ": " + strings.TrimSpace(drainVipsErrors())}
+}
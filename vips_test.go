@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestSelfTestVips(t *testing.T) {
+	if err := selfTestVips(); err != nil {
+		t.Fatalf("expected the libvips self test to pass: %s", err)
+	}
+}
+
+func TestServerVipsFailure(t *testing.T) {
+	// Reserve a free port, released for the server to bind it
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	failure := errors.New("libvips self test failed: unable to load jpeg")
+	defer func(selfTest func() error) { vipsSelfTest = selfTest }(vipsSelfTest)
+	vipsSelfTest = func() error { return failure }
+
+	if err := Server(ServerOptions{Address: "127.0.0.1", Port: port}); err != failure {
+		t.Fatalf("expected the self test error, got %v", err)
+	}
+
+	// The port is still free, since the server never started listening
+	l, err = net.Listen("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("expected the server not to listen: %s", err)
+	}
+	l.Close()
+}
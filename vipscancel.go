@@ -0,0 +1,97 @@
+package main
+
+/*
+#cgo pkg-config: vips
+#include <stdlib.h>
+#include <vips/vips.h>
+
+// cancel_flag is the cancellation flag of the operations run by the thread.
+static __thread volatile int *cancel_flag = NULL;
+
+// cancel_postbuild_hook enables the evaluation signals of every image
+// built by a thread with a cancellation flag.
+static gboolean
+cancel_postbuild_hook(GSignalInvocationHint *hint, guint n, const GValue *params, gpointer data) {
+	if (cancel_flag) {
+		GObject *object = g_value_get_object(&params[0]);
+		if (VIPS_IS_IMAGE(object)) {
+			vips_image_set_progress(VIPS_IMAGE(object), TRUE);
+		}
+	}
+	return TRUE;
+}
+
+// cancel_eval_hook kills the evaluated image once the flag is set. The
+// evaluation signals are emitted by the thread running the operation.
+static gboolean
+cancel_eval_hook(GSignalInvocationHint *hint, guint n, const GValue *params, gpointer data) {
+	if (cancel_flag && __atomic_load_n(cancel_flag, __ATOMIC_RELAXED)) {
+		vips_image_set_kill(VIPS_IMAGE(g_value_get_object(&params[0])), TRUE);
+	}
+	return TRUE;
+}
+
+static void
+cancel_install_hooks(void) {
+	g_type_class_unref(g_type_class_ref(VIPS_TYPE_IMAGE));
+	g_signal_add_emission_hook(g_signal_lookup("postbuild", VIPS_TYPE_OBJECT), 0, cancel_postbuild_hook, NULL, NULL);
+	g_signal_add_emission_hook(g_signal_lookup("eval", VIPS_TYPE_IMAGE), 0, cancel_eval_hook, NULL, NULL);
+}
+
+static void
+cancel_bind(int *flag) {
+	cancel_flag = flag;
+}
+
+static void
+cancel_set(int *flag) {
+	__atomic_store_n(flag, 1, __ATOMIC_RELAXED);
+}
+*/
+import "C"
+
+import (
+	"sync"
+	"unsafe"
+)
+
+var cancelHooks sync.Once
+
+// vipsCancel interrupts the libvips operations run by a locked OS thread.
+// Once canceled, libvips kills the images evaluated by the thread, so the
+// operations fail at their next work unit instead of running to completion.
+type vipsCancel struct {
+	mutex sync.Mutex
+	flag  *C.int
+}
+
+func newVipsCancel() *vipsCancel {
+	cancelHooks.Do(func() {
+		C.cancel_install_hooks()
+	})
+	return &vipsCancel{flag: (*C.int)(C.calloc(1, C.size_t(unsafe.Sizeof(C.int(0)))))}
+}
+
+// bind binds the flag to the calling goroutine thread, which must be
+// locked until done.
+func (c *vipsCancel) bind() {
+	C.cancel_bind(c.flag)
+}
+
+// Cancel kills the running operations of the bound thread.
+func (c *vipsCancel) Cancel() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.flag != nil {
+		C.cancel_set(c.flag)
+	}
+}
+
+// done unbinds the flag from the calling thread and frees it.
+func (c *vipsCancel) done() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	C.cancel_bind(nil)
+	C.free(unsafe.Pointer(c.flag))
+	c.flag = nil
+}
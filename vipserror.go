@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// corruptImageErrors are the libvips and bimg messages, lower cased, of the
+// images that cannot be decoded.
+var corruptImageErrors = []string{
+	"unsupported image format",
+	"not a known file format",
+	"not in a known format",
+	"premature end",
+	"corrupt",
+	"truncated",
+	"vipsforeignload",
+	"vipsjpeg",
+	"vipspng",
+	"libspng",
+	"jpegload",
+	"pngload",
+	"webpload",
+	"gifload",
+	"tiffload",
+	"heifload",
+	"svgload",
+	"pdfload",
+	"magickload",
+}
+
+// vipsError is an error reported by libvips. Only these errors are
+// translated, so the other errors, such as the fetch errors of the image
+// URLs containing any of the patterns, are replied as is.
+type vipsError struct {
+	message string
+}
+
+func (e vipsError) Error() string {
+	return e.message
+}
+
+// asVipsError marks the errors of an image operation, other than the replied
+// ones, as libvips errors: bimg reports the libvips errors as plain errors.
+func asVipsError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(Error); ok {
+		return err
+	}
+	if _, ok := err.(vipsError); ok {
+		return err
+	}
+	return vipsError{err.Error()}
+}
+
+// publicError translates the libvips errors replied to the request.
+func publicError(w http.ResponseWriter, err error) error {
+	return translateError(w.Header().Get(requestIDHeader), err)
+}
+
+// translateError translates the libvips errors to clean user facing messages,
+// since the libvips diagnostics are cryptic and may leak internal paths.
+// The full diagnostic is logged along with the request or job ID.
+func translateError(id string, err error) error {
+	var vips vipsError
+	if !errors.As(err, &vips) {
+		return err
+	}
+	message := vips.message
+	lower := strings.ToLower(message)
+	for _, pattern := range corruptImageErrors {
+		if strings.Contains(lower, pattern) {
+			logVipsError(id, message)
+			return NewError("unsupported or corrupt image data", http.StatusUnsupportedMediaType)
+		}
+	}
+	logVipsError(id, message)
+	return NewError("image processing failed", errorCode(err))
+}
+
+func logVipsError(id, message string) {
+	debug("libvips error (request=%s): %s", id, strings.TrimSpace(message))
+}
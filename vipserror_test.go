@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"gopkg.in/h2non/bimg.v1"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"testing"
+)
+
+func TestTranslateTruncatedJPEG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 64, 64)), nil); err != nil {
+		t.Fatal(err)
+	}
+	// Cut the image at its start of scan marker, before any pixel data
+	truncated := buf.Bytes()[:bytes.Index(buf.Bytes(), []byte{0xff, 0xda})]
+
+	processing := &Processing{ctx: context.Background()}
+	_, err := processing.Run(func() ([]byte, error) {
+		return Resize(truncated, Options{Operation: "resize", Width: 32, Height: 32, Type: bimg.JPEG})
+	})
+	if err == nil {
+		t.Fatal("expected the truncated image to fail")
+	}
+
+	translated := translateError("test", err)
+	if errorCode(translated) != http.StatusUnsupportedMediaType {
+		t.Errorf("expected status %d, got %d: %s", http.StatusUnsupportedMediaType, errorCode(translated), translated)
+	}
+	if translated.Error() != "unsupported or corrupt image data" {
+		t.Errorf("unexpected message: %s", translated)
+	}
+}
+
+func TestTranslateError(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		expected string
+		code     int
+	}{
+		{
+			"corrupt image",
+			vipsError{"VipsJpeg: Premature end of JPEG file"},
+			"unsupported or corrupt image data",
+			http.StatusUnsupportedMediaType,
+		},
+		{
+			"processing failure",
+			vipsError{"vips_embed: bad dimensions"},
+			"image processing failed",
+			http.StatusBadRequest,
+		},
+		{
+			"fetch error of a corrupt named image",
+			fmt.Errorf("Error downloading image: (status=404) (url=/images/corrupt-truncated.jpg)"),
+			"Error downloading image: (status=404) (url=/images/corrupt-truncated.jpg)",
+			http.StatusBadRequest,
+		},
+		{
+			"replied error",
+			NewError("cannot load watermark image: jpegload failed", http.StatusBadGateway),
+			"cannot load watermark image: jpegload failed",
+			http.StatusBadGateway,
+		},
+	}
+
+	for _, c := range cases {
+		err := translateError("test", c.err)
+		if err.Error() != c.expected || errorCode(err) != c.code {
+			t.Errorf("%s: expected %q (%d), got %q (%d)", c.name, c.expected, c.code, err, errorCode(err))
+		}
+	}
+}
+
+func TestAsVipsError(t *testing.T) {
+	if asVipsError(nil) != nil {
+		t.Error("expected no error")
+	}
+	replied := NewError("invalid width", http.StatusBadRequest)
+	if asVipsError(replied) != error(replied) {
+		t.Error("expected the replied errors to be kept")
+	}
+	if _, ok := asVipsError(fmt.Errorf("VipsJpeg: out of memory")).(vipsError); !ok {
+		t.Error("expected the plain errors to be libvips errors")
+	}
+}
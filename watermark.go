@@ -0,0 +1,280 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"gopkg.in/h2non/bimg.v1"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WatermarkOptions defines the watermark image to composite over the output.
+type WatermarkOptions struct {
+	URL     string
+	Image   []byte
+	Opacity float32
+	Left    int
+	Top     int
+	Gravity string
+	Scale   float64
+	Tile    bool
+	Spacing int
+	Angle   float64
+}
+
+var watermarkGravities = map[string]bool{
+	"northwest": true, "north": true, "northeast": true,
+	"west": true, "centre": true, "center": true, "east": true,
+	"southwest": true, "south": true, "southeast": true,
+}
+
+type watermarkEntry struct {
+	location string
+	image    []byte
+	expires  time.Time
+}
+
+// WatermarkStore fetches watermark images by URL, or by S3 key when the
+// S3 source is enabled, caching them in memory for the configured TTL.
+// The least recently used images are evicted past the max cache size.
+type WatermarkStore struct {
+	ttl     time.Duration
+	maxSize int64
+	url     *URLSource
+	s3      *S3Source
+
+	mutex   sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+func NewWatermarkStore(o ServerOptions, sources []ImageSource) *WatermarkStore {
+	store := &WatermarkStore{
+		ttl:     time.Duration(o.WatermarkCacheTTL) * time.Second,
+		maxSize: o.WatermarkCacheMaxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+	for _, source := range sources {
+		switch s := source.(type) {
+		case *URLSource:
+			store.url = s
+		case *S3Source:
+			store.s3 = s
+		}
+	}
+	return store
+}
+
+// Resolve loads the watermark image defined in the options, if any.
+func (s *WatermarkStore) Resolve(r *http.Request, opts *Options) error {
+	if opts.Watermark.URL == "" {
+		return nil
+	}
+
+	image, err := s.get(r, opts.Watermark.URL)
+	if err != nil {
+		return NewError("cannot load watermark image: "+err.Error(), errorCode(err))
+	}
+	if bimg.DetermineImageType(image) == bimg.UNKNOWN {
+		return NewError("unsupported watermark image type", http.StatusBadRequest)
+	}
+
+	opts.Watermark.Image = image
+	return nil
+}
+
+func (s *WatermarkStore) get(r *http.Request, location string) ([]byte, error) {
+	if image, ok := s.cached(location); ok {
+		return image, nil
+	}
+
+	image, err := s.fetch(r, location)
+	if err != nil {
+		return nil, err
+	}
+	s.store(location, image)
+	return image, nil
+}
+
+func (s *WatermarkStore) cached(location string) ([]byte, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	elem, ok := s.entries[location]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*watermarkEntry)
+	if !time.Now().Before(entry.expires) {
+		s.remove(elem)
+		return nil, false
+	}
+	s.lru.MoveToFront(elem)
+	return entry.image, true
+}
+
+// store caches the image, unless it alone exceeds the max cache size.
+func (s *WatermarkStore) store(location string, image []byte) {
+	size := int64(len(image))
+	if s.ttl <= 0 || (s.maxSize > 0 && size > s.maxSize) {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if elem, ok := s.entries[location]; ok {
+		s.remove(elem)
+	}
+	s.entries[location] = s.lru.PushFront(&watermarkEntry{location, image, time.Now().Add(s.ttl)})
+	s.size += size
+	s.evict()
+}
+
+// evict drops the expired images, and then the least recently used ones
+// while the cache exceeds its max size.
+func (s *WatermarkStore) evict() {
+	now := time.Now()
+	for elem := s.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if now.After(elem.Value.(*watermarkEntry).expires) {
+			s.remove(elem)
+		}
+		elem = next
+	}
+	for s.maxSize > 0 && s.size > s.maxSize {
+		s.remove(s.lru.Back())
+	}
+}
+
+func (s *WatermarkStore) remove(elem *list.Element) {
+	entry := s.lru.Remove(elem).(*watermarkEntry)
+	delete(s.entries, entry.location)
+	s.size -= int64(len(entry.image))
+}
+
+func (s *WatermarkStore) fetch(r *http.Request, location string) ([]byte, error) {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return s.url.fetcher.Fetch(r.Context(), location)
+	}
+	if s.s3 == nil {
+		return nil, NewError("watermark image must be an http(s) URL", http.StatusBadRequest)
+	}
+	buf, _, err := s.s3.getObject(r, location)
+	return buf, err
+}
+
+// applyWatermark composites the watermark over the already processed image,
+// encoding the result with the final output options.
+func applyWatermark(image []byte, params bimg.Options, w WatermarkOptions) ([]byte, error) {
+	size, err := bimg.Size(image)
+	if err != nil {
+		return nil, err
+	}
+
+	mark, err := fitWatermark(w.Image, size, w.Scale)
+	if err != nil {
+		return nil, err
+	}
+	markSize, err := bimg.Size(mark)
+	if err != nil {
+		return nil, err
+	}
+
+	left, top := watermarkPosition(w, size, markSize)
+	if w.Tile {
+		if mark, err = tileWatermark(mark, size, w); err != nil {
+			return nil, err
+		}
+		left, top = 0, 0
+	}
+	return bimg.Resize(image, bimg.Options{
+		Type:          params.Type,
+		Quality:       params.Quality,
+		Compression:   params.Compression,
+		Speed:         params.Speed,
+		Interlace:     params.Interlace,
+		NoProfile:     params.NoProfile,
+		StripMetadata: params.StripMetadata,
+		Lossless:      params.Lossless,
+		NoAutoRotate:  true,
+		WatermarkImage: bimg.WatermarkImage{
+			Left:    left,
+			Top:     top,
+			Buf:     mark,
+			Opacity: w.Opacity,
+		},
+	})
+}
+
+// fitWatermark scales the watermark relative to the image width, if a scale
+// factor is defined, and clamps it to fit within the image bounds.
+func fitWatermark(mark []byte, size bimg.ImageSize, scale float64) ([]byte, error) {
+	markSize, err := bimg.Size(mark)
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := float64(markSize.Width), float64(markSize.Height)
+	if scale > 0 {
+		height = height * float64(size.Width) * scale / width
+		width = float64(size.Width) * scale
+	}
+	if width > float64(size.Width) {
+		height = height * float64(size.Width) / width
+		width = float64(size.Width)
+	}
+	if height > float64(size.Height) {
+		width = width * float64(size.Height) / height
+		height = float64(size.Height)
+	}
+
+	if int(width) == markSize.Width && int(height) == markSize.Height {
+		return mark, nil
+	}
+	return bimg.Resize(mark, bimg.Options{
+		Width:   int(width),
+		Height:  int(height),
+		Force:   true,
+		Enlarge: true,
+		Type:    bimg.PNG,
+	})
+}
+
+// watermarkPosition returns the watermark coordinates anchored by gravity,
+// using left and top as the margins from the anchored edges.
+func watermarkPosition(w WatermarkOptions, size, mark bimg.ImageSize) (int, int) {
+	left, top := w.Left, w.Top
+	if strings.HasSuffix(w.Gravity, "east") {
+		left = size.Width - mark.Width - w.Left
+	}
+	if strings.HasPrefix(w.Gravity, "south") {
+		top = size.Height - mark.Height - w.Top
+	}
+	if w.Gravity == "north" || w.Gravity == "south" || w.Gravity == "centre" || w.Gravity == "center" {
+		left = (size.Width-mark.Width)/2 + w.Left
+	}
+	if w.Gravity == "east" || w.Gravity == "west" || w.Gravity == "centre" || w.Gravity == "center" {
+		top = (size.Height-mark.Height)/2 + w.Top
+	}
+	return clamp(left, 0, size.Width-mark.Width), clamp(top, 0, size.Height-mark.Height)
+}
+
+func clamp(value, min, max int) int {
+	if value > max {
+		value = max
+	}
+	if value < min {
+		value = min
+	}
+	return value
+}
+
+func parseWatermarkGravity(name string) (string, error) {
+	if !watermarkGravities[name] {
+		return "", NewError(fmt.Sprintf("unsupported watermark gravity: %s", name), http.StatusBadRequest)
+	}
+	return name, nil
+}
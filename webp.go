@@ -0,0 +1,105 @@
+package main
+
+/*
+#cgo pkg-config: vips
+#include <stdlib.h>
+#include <vips/vips.h>
+
+// webp_save_buffer encodes the image as WebP. reduction_effort is still
+// accepted by libvips >= 8.12, which renamed it to effort.
+static int
+webp_save_buffer(void *buf, size_t len, int quality, int lossless, int near_lossless, int effort, void **out, size_t *out_len) {
+	VipsImage *in = vips_image_new_from_buffer(buf, len, "", NULL);
+	if (in == NULL) {
+		return 1;
+	}
+
+	int err = vips_webpsave_buffer(in, out, out_len,
+		"Q", quality,
+		"lossless", lossless,
+		"near_lossless", near_lossless,
+		"reduction_effort", effort,
+		NULL);
+
+	g_object_unref(in);
+	return err;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"unsafe"
+)
+
+// defaultWebPEffort is the libvips default WebP compression effort.
+const defaultWebPEffort = 4
+
+// WebPOptions defines the WebP encoding mode. NearLossless and Effort
+// are negative when not defined.
+type WebPOptions struct {
+	Lossless     bool
+	NearLossless int
+	Effort       int
+}
+
+// custom reports whether the options require encoding with libvips,
+// since bimg only supports the lossless mode.
+func (o WebPOptions) custom() bool {
+	return o.NearLossless >= 0 || o.Effort >= 0
+}
+
+// readWebPParams reads the lossless, nearlossless and webp-effort params.
+// The lossless modes cannot be combined with a quality.
+func readWebPParams(query url.Values, opts *Options) error {
+	var err error
+	if opts.WebP.Lossless, err = parseBoolParam(query, "lossless", false); err != nil {
+		return err
+	}
+	if query.Get("nearlossless") != "" {
+		if opts.WebP.NearLossless, err = parseIntParam(query, "nearlossless", 0, 100); err != nil {
+			return err
+		}
+	}
+	if query.Get("webp-effort") != "" {
+		if opts.WebP.Effort, err = parseIntParam(query, "webp-effort", 0, 6); err != nil {
+			return err
+		}
+	}
+
+	if (opts.WebP.Lossless || opts.WebP.NearLossless >= 0) && (opts.QualityParam || opts.AutoQuality) {
+		return NewError("lossless and quality params are mutually exclusive", http.StatusBadRequest)
+	}
+	return nil
+}
+
+// saveWebP encodes a losslessly processed image as WebP with the options
+// not supported by bimg. Near lossless uses its level as the quality.
+func saveWebP(image []byte, quality int, o WebPOptions) ([]byte, error) {
+	if len(image) == 0 {
+		return nil, errors.New("empty image")
+	}
+
+	lossless, nearLossless := C.int(0), C.int(0)
+	if o.Lossless {
+		lossless = 1
+	}
+	if o.NearLossless >= 0 {
+		lossless, nearLossless = 1, 1
+		quality = o.NearLossless
+	}
+	effort := o.Effort
+	if effort < 0 {
+		effort = defaultWebPEffort
+	}
+
+	var out unsafe.Pointer
+	var length C.size_t
+	if C.webp_save_buffer(unsafe.Pointer(&image[0]), C.size_t(len(image)), C.int(quality), lossless, nearLossless, C.int(effort), &out, &length) != 0 {
+		return nil, vipsErrorf("cannot encode WebP image")
+	}
+	defer C.g_free(C.gpointer(out))
+	return C.GoBytes(out, C.int(length)), nil
+}